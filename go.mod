@@ -14,5 +14,7 @@ require (
 	github.com/peterbourgon/ff/v3 v3.3.0
 	golang.org/x/crypto v0.7.0 // indirect
 	golang.org/x/oauth2 v0.6.0
+	golang.org/x/sys v0.6.0
 	google.golang.org/protobuf v1.30.0 // indirect
+	modernc.org/sqlite v1.21.2
 )
@@ -0,0 +1,78 @@
+// Package apitest provides helpers to test the API clients of the providers
+// against a stand-in server.
+package apitest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// NewServer starts a stand-in API server with the given handler, it's closed
+// once the test finishes. If header is not empty, every request is checked
+// to send it with the given value, i.e: the token.
+func NewServer(c *qt.C, header, value string, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if header != "" {
+			c.Check(r.Header.Get(header), qt.Equals, value)
+		}
+		handler(w, r)
+	}))
+	c.Cleanup(server.Close)
+	return server
+}
+
+// Sleeper doesn't sleep, but records the durations it would have slept.
+type Sleeper struct {
+	mu    sync.Mutex
+	slept []time.Duration
+}
+
+// Sleep records the given duration. It has the signature of the sleep
+// functions of the clients.
+func (s *Sleeper) Sleep(ctx context.Context, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.slept = append(s.slept, d)
+	return ctx.Err()
+}
+
+// Slept returns the recorded durations.
+func (s *Sleeper) Slept() []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]time.Duration(nil), s.slept...)
+}
+
+// Throttle returns a handler that responds with 429 Too Many Requests and
+// the given Retry-After header for the first n requests, and calls handler
+// for the rest.
+func Throttle(n int, retryAfter string, handler http.HandlerFunc) http.HandlerFunc {
+	var (
+		mu    sync.Mutex
+		count int
+	)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		throttled := count <= n
+		mu.Unlock()
+
+		if throttled {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		handler(w, r)
+	}
+}
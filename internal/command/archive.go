@@ -0,0 +1,206 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/config"
+	"github.com/fatih/starhook/internal/fsstore"
+
+	"github.com/dustin/go-humanize"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// archiveCmd creates a new ffcli.Command for the archive subcommand.
+func archiveCmd(rootConfig *RootConfig) *ffcli.Command {
+	fs := flag.NewFlagSet("starhook archive", flag.ExitOnError)
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "archive",
+		ShortUsage: "starhook archive <subcommand> [flags]",
+		ShortHelp:  "Manage archived repositories",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			return flag.ErrHelp
+		},
+		Subcommands: []*ffcli.Command{
+			archiveListCmd(rootConfig),
+			archiveRestoreCmd(rootConfig),
+		},
+	}
+}
+
+func archiveListCmd(rootConfig *RootConfig) *ffcli.Command {
+	fs := flag.NewFlagSet("starhook archive list", flag.ExitOnError)
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "list",
+		ShortUsage: "starhook archive list [flags]",
+		ShortHelp:  "List archived repositories of the selected reposet",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			rs, err := selectedRepoSet()
+			if err != nil {
+				return err
+			}
+
+			archived, err := archivedRepos(rs)
+			if err != nil {
+				return err
+			}
+
+			const padding = 3
+			w := tabwriter.NewWriter(rootConfig.out, 0, 0, padding, ' ', 0)
+			for _, a := range archived {
+				rel, err := filepath.Rel(rs.ReposDir, a.Path)
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(w, "%s\t%s\t%s\n", a.Repo.Nwo, humanize.Time(a.ArchivedAt), rel)
+			}
+			w.Flush()
+
+			log.Printf("==> archived %d repositories\n", len(archived))
+			return nil
+		},
+	}
+}
+
+func archiveRestoreCmd(rootConfig *RootConfig) *ffcli.Command {
+	fs := flag.NewFlagSet("starhook archive restore", flag.ExitOnError)
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "restore",
+		ShortUsage: "starhook archive restore [flags] <owner/name | path>",
+		ShortHelp:  "Restore an archived repository into the selected reposet",
+		LongHelp: "Restore an archived repository into the selected reposet. If a repository " +
+			"was archived multiple times, the most recent one is restored, unless the path " +
+			"of the archive (as printed by 'starhook archive list') is passed.",
+		FlagSet: fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return flag.ErrHelp
+			}
+
+			rs, err := selectedRepoSet()
+			if err != nil {
+				return err
+			}
+
+			lock, err := lockRepoSet(rs)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			store, fsStore, err := openStores(rs, nil, "")
+			if err != nil {
+				return err
+			}
+			if c, ok := store.(io.Closer); ok {
+				defer c.Close()
+			}
+
+			all, err := fsStore.ArchivedRepos()
+			if err != nil {
+				return err
+			}
+
+			var archived *fsstore.ArchivedRepo
+			for _, a := range all {
+				rel, err := filepath.Rel(rs.ReposDir, a.Path)
+				if err != nil {
+					return err
+				}
+
+				// archived repos are sorted, the first match is the most
+				// recent one
+				if a.Repo.Nwo == args[0] || rel == filepath.Clean(args[0]) || a.Path == args[0] {
+					archived = a
+					break
+				}
+			}
+
+			if archived == nil {
+				return fmt.Errorf("no archived repository found for %q", args[0])
+			}
+
+			repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+			if err != nil {
+				return err
+			}
+
+			for _, repo := range repos {
+				if repo.Nwo == archived.Repo.Nwo {
+					return fmt.Errorf("repository %q is already part of the reposet", repo.Nwo)
+				}
+			}
+
+			// the repository is added to the store first, the archive is
+			// kept if it can't be added
+			repo := archived.Repo
+			repo.ID = 0
+			id, err := store.CreateRepo(ctx, repo)
+			if err != nil {
+				return err
+			}
+
+			if err := fsStore.RestoreRepo(ctx, archived); err != nil {
+				if derr := store.DeleteRepo(ctx, internal.RepositoryBy{RepoID: &id}); derr != nil {
+					log.Printf("[ERROR] couldn't remove %q from the store: %s\n", repo.Nwo, derr)
+				}
+				return err
+			}
+
+			log.Printf("%q is restored. It's removed again on the next sync, unless it's part of the query of reposet %q\n",
+				repo.Nwo, rs.Name)
+			return nil
+		},
+	}
+}
+
+// archivedRepos returns the archived repositories of the given reposet. The
+// archive is read while the reposet is locked, the metadata store isn't
+// opened.
+func archivedRepos(rs *config.RepoSet) ([]*fsstore.ArchivedRepo, error) {
+	// nothing is archived if the reposet was never synced, the directory
+	// isn't created
+	if _, err := os.Stat(rs.ReposDir); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	lock, err := lockRepoSet(rs)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	fsStore, err := newRepositoryStore(rs, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return fsStore.ArchivedRepos()
+}
+
+// selectedRepoSet loads the configuration and returns the selected reposet.
+func selectedRepoSet() (*config.RepoSet, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.SelectedRepoSet()
+}
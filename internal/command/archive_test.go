@@ -0,0 +1,85 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/config"
+	"github.com/fatih/starhook/internal/jsonstore"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestArchiveListCmd(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	rs := newTestRepoSet(c, &config.FilterRules{})
+	captureLog(c)
+
+	var out bytes.Buffer
+	rootConfig := &RootConfig{out: &out}
+
+	err := archiveListCmd(rootConfig).ParseAndRun(ctx, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(out.String(), qt.Equals, "")
+
+	_, err = os.Stat(storePath(rs))
+	c.Assert(os.IsNotExist(err), qt.IsTrue, qt.Commentf("store should not be created"))
+
+	lock, err := lockRepoSet(rs)
+	c.Assert(err, qt.IsNil)
+	defer lock.Release()
+
+	err = archiveListCmd(rootConfig).ParseAndRun(ctx, nil)
+	c.Assert(err, qt.ErrorMatches, `reposet "oss" is in use by another starhook process.*`)
+}
+
+func TestArchiveRestoreCmd_restoreFails(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	rs := newTestRepoSet(c, &config.FilterRules{})
+	captureLog(c)
+
+	repo := &internal.Repository{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go"}
+
+	fsStore, err := newRepositoryStore(rs, nil, "")
+	c.Assert(err, qt.IsNil)
+	repoDir, err := fsStore.RepoDir(repo)
+	c.Assert(err, qt.IsNil)
+	c.Assert(os.MkdirAll(repoDir, 0o755), qt.IsNil)
+
+	_, err = fsStore.ArchiveRepo(ctx, repo)
+	c.Assert(err, qt.IsNil)
+
+	// the repository can't be moved back onto an existing directory
+	c.Assert(os.MkdirAll(repoDir, 0o755), qt.IsNil)
+
+	rootConfig := &RootConfig{out: &bytes.Buffer{}}
+	err = archiveRestoreCmd(rootConfig).ParseAndRun(ctx, []string{repo.Nwo})
+	c.Assert(err, qt.ErrorMatches, `can't restore "fatih/vim-go": .* already exists`)
+
+	// the repository isn't added to the store and can be restored again
+	store, err := jsonstore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+	c.Assert(err, qt.IsNil)
+	repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 0)
+
+	archived, err := fsStore.ArchivedRepos()
+	c.Assert(err, qt.IsNil)
+	c.Assert(archived, qt.HasLen, 1)
+
+	c.Assert(os.Remove(repoDir), qt.IsNil)
+	err = archiveRestoreCmd(rootConfig).ParseAndRun(ctx, []string{repo.Nwo})
+	c.Assert(err, qt.IsNil)
+
+	repos, err = store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+	c.Assert(repos[0].Nwo, qt.Equals, repo.Nwo)
+}
@@ -8,12 +8,16 @@ import (
 	"io"
 	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/99designs/keyring"
+	"github.com/fatih/starhook/internal"
 	"github.com/fatih/starhook/internal/config"
+	"github.com/fatih/starhook/internal/fsstore"
 	"github.com/lucasepe/codename"
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
@@ -23,6 +27,41 @@ const (
 	keyringService = "starhook"
 )
 
+// tokenKey returns the keyring key of the token for the given GitHub host.
+func tokenKey(host string) string {
+	return keyringKey + ":" + host
+}
+
+// loadToken loads the token of the given GitHub host from the keyring.
+// Tokens of github.com that were stored before tokens were stored per host
+// are used as well.
+func loadToken(ring keyring.Keyring, host string) (string, error) {
+	i, err := ring.Get(tokenKey(host))
+	if errors.Is(err, keyring.ErrKeyNotFound) && host == config.DefaultHost {
+		i, err = ring.Get(keyringKey)
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't load the token of %q: %w", host, err)
+	}
+
+	return string(i.Data), nil
+}
+
+// loadRepoSetToken loads the token of the given reposet's provider from the
+// keyring. It's empty for providers that don't need a token.
+func loadRepoSetToken(rs *config.RepoSet) (string, error) {
+	if !rs.NeedsToken() {
+		return "", nil
+	}
+
+	ring, err := openKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	return loadToken(ring, rs.ProviderHost())
+}
+
 // Config is the config for the list subcommand, including a reference to the
 // global config, for access to global flags.
 type Config struct {
@@ -38,9 +77,6 @@ func configCmd(rootConfig *RootConfig) *ffcli.Command {
 	fs := flag.NewFlagSet("starhook config", flag.ExitOnError)
 	rootConfig.RegisterFlags(fs)
 
-	// TODO following subcommands need to be added
-	// config set key value   // update existing value, only for token and query
-
 	return &ffcli.Command{
 		Name:       "config",
 		ShortUsage: "starhook config [flags] [<prefix>]",
@@ -49,10 +85,14 @@ func configCmd(rootConfig *RootConfig) *ffcli.Command {
 		Exec:       cfg.Exec,
 		Subcommands: []*ffcli.Command{
 			configDeleteCmd(rootConfig),
+			configFilterCmd(rootConfig),
+			configGetCmd(rootConfig),
 			configInitCmd(rootConfig),
 			configListCmd(rootConfig),
+			configSetCmd(rootConfig),
 			configShowCmd(rootConfig),
 			configSwitchCmd(rootConfig),
+			configUnsetCmd(rootConfig),
 		},
 	}
 }
@@ -64,10 +104,27 @@ func (c *Config) Exec(ctx context.Context, _ []string) error {
 
 func configInitCmd(rootConfig *RootConfig) *ffcli.Command {
 	var (
-		name  string // optional
-		token string
-		dir   string
-		query string
+		name   string // optional
+		token  string
+		dir    string
+		query  internal.Queries
+		layout string
+		policy string
+		store  string
+
+		protocol string
+		sshHost  string
+		provider string
+		host     string
+		apiURL   string
+
+		source string
+		org    string
+		team   string
+		user   string
+		repos  string
+		path   string
+		tags   string
 
 		force bool
 	)
@@ -75,10 +132,25 @@ func configInitCmd(rootConfig *RootConfig) *ffcli.Command {
 	fst := flag.NewFlagSet("starhook config init", flag.ExitOnError)
 	rootConfig.RegisterFlags(fst)
 
-	fst.StringVar(&token, "token", "", "github token, i.e: GITHUB_TOKEN")
+	fst.StringVar(&token, "token", "", "API token of the provider, i.e: GITHUB_TOKEN")
 	fst.StringVar(&dir, "dir", "", "absolute path to download the repositories")
-	fst.StringVar(&query, "query", "", "query to fetch the repositories")
+	fst.Var(&query, "query", "query to fetch the repositories, if --source is 'search'. Can be passed multiple times to fetch the repositories matching any of the queries")
+	fst.StringVar(&source, "source", internal.SourceSearch, "where to fetch the repositories from: 'search', 'org', 'user', 'starred', 'team', 'list' or 'dir'")
+	fst.StringVar(&org, "org", "", "organization of the 'org' and 'team' sources")
+	fst.StringVar(&team, "team", "", "team slug of the 'team' source")
+	fst.StringVar(&user, "user", "", "user of the 'user' and 'starred' sources (default: authenticated user)")
+	fst.StringVar(&repos, "repos", "", "comma separated list of repositories of the 'list' source, i.e: 'fatih/color,fatih/structs'")
+	fst.StringVar(&path, "path", "", "directory of bare repositories of the 'dir' source")
 	fst.StringVar(&name, "name", "", "name of the configuration (optional)")
+	fst.StringVar(&tags, "tags", "", "comma separated tags to sync the reposet with others, i.e: 'work,oss' (optional)")
+	fst.StringVar(&policy, "remove-policy", string(internal.DefaultRemovePolicy), "what to do with repositories that are no longer part of the reposet: 'archive', 'delete' or 'keep'")
+	fst.StringVar(&layout, "layout", fsstore.LayoutFlat, "layout of the repositories inside --dir: 'flat', 'owner/name' or a template, i.e: '{{.Owner}}-{{.Name}}'")
+	fst.StringVar(&provider, "provider", config.ProviderGitHub, "code hosting service of the repositories: 'github', 'gitlab', 'gitea' or 'file'")
+	fst.StringVar(&host, "host", "", "host of the provider, i.e: the host of a GitHub Enterprise Server instance (default: github.com or gitlab.com)")
+	fst.StringVar(&apiURL, "api-url", "", "API URL of the provider, only needed if it's not served from its default path, i.e: 'https://<host>/api/v3/' for GitHub Enterprise Server")
+	fst.StringVar(&store, "store", config.StoreJSON, "how to store the metadata of the repositories inside --dir: 'json' or 'sqlite'")
+	fst.StringVar(&protocol, "clone-protocol", fsstore.ProtocolHTTPS, "protocol to clone the repositories with: 'https' or 'ssh'")
+	fst.StringVar(&sshHost, "ssh-host", "", "host to clone the repositories from via ssh, can be an alias from ~/.ssh/config (default: the provider's host)")
 	fst.BoolVar(&force, "force", false, "override existing configuration for a given --name ")
 
 	return &ffcli.Command{
@@ -87,10 +159,10 @@ func configInitCmd(rootConfig *RootConfig) *ffcli.Command {
 		ShortHelp:  "Initialize a new configuration",
 		FlagSet:    fst,
 		Exec: func(ctx context.Context, _ []string) error {
-			if token == "" {
+			if token == "" && provider != config.ProviderFile {
 				return errors.New("--token should be set")
 			}
-			if query == "" {
+			if source == internal.SourceSearch && len(query) == 0 {
 				return errors.New("--query should be set")
 			}
 			if dir == "" {
@@ -101,6 +173,74 @@ func configInitCmd(rootConfig *RootConfig) *ffcli.Command {
 				return fmt.Errorf("--dir %q should be an absolute path", dir)
 			}
 
+			if path != "" && !filepath.IsAbs(path) {
+				return fmt.Errorf("--path %q should be an absolute path", path)
+			}
+
+			if _, err := fsstore.ParseLayout(layout); err != nil {
+				return fmt.Errorf("--layout: %w", err)
+			}
+
+			switch provider {
+			case config.ProviderGitHub, config.ProviderGitLab:
+			case config.ProviderGitea:
+				if host == "" {
+					return errors.New("--host should be set for the 'gitea' provider")
+				}
+			case config.ProviderFile:
+				if source != internal.SourceDir && source != internal.SourceList {
+					return fmt.Errorf("--source %q is not supported by the 'file' provider, should be 'dir' or 'list'", source)
+				}
+				if protocol != fsstore.ProtocolHTTPS {
+					return errors.New("--clone-protocol is not supported by the 'file' provider")
+				}
+			default:
+				return fmt.Errorf("--provider %q should be 'github', 'gitlab', 'gitea' or 'file'", provider)
+			}
+
+			if strings.Contains(host, "/") {
+				return fmt.Errorf("--host %q should be a host name, i.e: github.example.com", host)
+			}
+
+			if apiURL != "" {
+				if u, err := url.Parse(apiURL); err != nil || u.Scheme == "" || u.Host == "" {
+					return fmt.Errorf("--api-url %q should be an absolute URL", apiURL)
+				}
+			}
+
+			if store != config.StoreJSON && store != config.StoreSQLite {
+				return fmt.Errorf("--store %q should be 'json' or 'sqlite'", store)
+			}
+
+			if _, err := fsstore.ParseCloneProtocol(protocol); err != nil {
+				return fmt.Errorf("--clone-protocol: %w", err)
+			}
+
+			if _, err := internal.ParseRemovePolicy(policy); err != nil {
+				return fmt.Errorf("--remove-policy: %w", err)
+			}
+
+			var src *internal.Source
+			if source != internal.SourceSearch {
+				src = &internal.Source{
+					Kind: source,
+					Org:  org,
+					Team: team,
+					User: user,
+					Path: path,
+				}
+
+				for _, repo := range strings.Split(repos, ",") {
+					if repo = strings.TrimSpace(repo); repo != "" {
+						src.Repos = append(src.Repos, repo)
+					}
+				}
+
+				if err := src.Validate(); err != nil {
+					return fmt.Errorf("--source: %w", err)
+				}
+			}
+
 			name := name
 			if name == "" {
 				rng, err := codename.DefaultRNG()
@@ -112,33 +252,64 @@ func configInitCmd(rootConfig *RootConfig) *ffcli.Command {
 
 			rs := &config.RepoSet{
 				Name:     name,
+				Tags:     splitList(tags),
 				Query:    query,
+				Source:   src,
 				ReposDir: dir,
+				Host:     host,
+				APIURL:   apiURL,
+				Layout:   layout,
+
+				CloneProtocol: protocol,
+				SSHHost:       sshHost,
+				RemovePolicy:  policy,
 			}
 
-			ring, err := openKeyring()
-			if err != nil {
-				return err
+			if provider != config.ProviderGitHub {
+				rs.Provider = provider
 			}
 
+			if store != config.StoreJSON {
+				rs.Store = store
+			}
+
+			newConfig := false
 			cfg, err := config.Load()
 			if err != nil {
-				if errors.Is(err, fs.ErrNotExist) {
-					cfg, err = config.New()
-					if err != nil {
-						return err
-					}
+				if !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+
+				cfg, err = config.New()
+				if err != nil {
+					return err
+				}
+				newConfig = true
+			}
 
+			// a token is stored once per host, a different token replaces
+			// the stored one for all reposets of the host
+			if rs.NeedsToken() {
+				ring, err := openKeyring()
+				if err != nil {
+					return err
+				}
+
+				tokenHost := rs.ProviderHost()
+				stored, err := loadToken(ring, tokenHost)
+				if err == nil && stored != token {
+					log.Printf("[WARN] replacing the stored token of %q, it's used by all reposets of the host\n", tokenHost)
+				}
+
+				if newConfig || err != nil || stored != token {
 					err = ring.Set(keyring.Item{
-						Key:         keyringKey,
+						Key:         tokenKey(tokenHost),
 						Data:        []byte(token),
-						Description: "GitHub API token for Starhook CLI",
+						Description: fmt.Sprintf("API token (%s) for Starhook CLI", tokenHost),
 					})
 					if err != nil {
 						return err
 					}
-				} else {
-					return err
 				}
 			}
 
@@ -309,19 +480,48 @@ func configSwitchCmd(rootConfig *RootConfig) *ffcli.Command {
 
 func printRepoSet(w io.Writer, rs *config.RepoSet) {
 	fmt.Fprintf(w, "Name\t%+v\n", rs.Name)
-	fmt.Fprintf(w, "Query\t%+v\n", rs.Query)
+	if len(rs.Tags) != 0 {
+		fmt.Fprintf(w, "Tags\t%s\n", strings.Join(rs.Tags, ", "))
+	}
+	if rs.SourceKind() == internal.SourceSearch {
+		for _, query := range rs.Query {
+			fmt.Fprintf(w, "Query\t%s\n", query)
+		}
+	} else {
+		fmt.Fprintf(w, "Source\t%+v\n", rs.Source)
+	}
 	fmt.Fprintf(w, "Repositories Directory\t%+v\n", rs.ReposDir)
+	if rs.Provider != "" {
+		fmt.Fprintf(w, "Provider\t%+v\n", rs.Provider)
+	}
+	if rs.Host != "" {
+		fmt.Fprintf(w, "Host\t%+v\n", rs.Host)
+	}
+	if rs.APIURL != "" {
+		fmt.Fprintf(w, "API URL\t%+v\n", rs.APIURL)
+	}
+	if rs.Store != "" {
+		fmt.Fprintf(w, "Store\t%+v\n", rs.Store)
+	}
+	if rs.Layout != "" {
+		fmt.Fprintf(w, "Layout\t%+v\n", rs.Layout)
+	}
+	if rs.CloneProtocol != "" {
+		fmt.Fprintf(w, "Clone Protocol\t%+v\n", rs.CloneProtocol)
+	}
+	if rs.SSHHost != "" {
+		fmt.Fprintf(w, "SSH Host\t%+v\n", rs.SSHHost)
+	}
+	if rs.RemovePolicy != "" {
+		fmt.Fprintf(w, "Remove Policy\t%+v\n", rs.RemovePolicy)
+	}
 
-	if rs.Filter != nil && (len(rs.Filter.Exclude) != 0 || len(rs.Filter.Include) != 0) {
-		fmt.Fprintln(w, "Filters:")
-
-		for i, repo := range rs.Filter.Include {
-			if i == 0 {
-				fmt.Fprintf(w, "\tInclude:\n")
-			}
-
-			fmt.Fprintf(w, "\t\t%s\n", repo)
+	for i, rule := range rs.Filter.All() {
+		if i == 0 {
+			fmt.Fprintln(w, "Filters:")
 		}
+
+		fmt.Fprintf(w, "\t%d\t%s\n", i+1, rule)
 	}
 	fmt.Fprintln(w, "")
 }
@@ -0,0 +1,459 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/config"
+	"github.com/fatih/starhook/internal/filter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// configFilterCmd creates a new ffcli.Command for the config filter
+// subcommand.
+func configFilterCmd(rootConfig *RootConfig) *ffcli.Command {
+	fs := flag.NewFlagSet("starhook config filter", flag.ExitOnError)
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "filter",
+		ShortUsage: "starhook config filter <subcommand> [flags]",
+		ShortHelp:  "Manage the filter rules of a reposet",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			return flag.ErrHelp
+		},
+		Subcommands: []*ffcli.Command{
+			configFilterAddCmd(rootConfig),
+			configFilterListCmd(rootConfig),
+			configFilterRemoveCmd(rootConfig),
+			configFilterTestCmd(rootConfig),
+		},
+	}
+}
+
+// ruleFlags are the flags that define the conditions of a filter rule.
+type ruleFlags struct {
+	name         string
+	regex        string
+	topic        string
+	language     string
+	fork         optionalBool
+	archived     optionalBool
+	minSize      int64
+	maxSize      int64
+	pushedWithin string
+	notPushedFor string
+}
+
+func (r *ruleFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&r.name, "name", "", "match the repositories with the given name glob, i.e: 'vim-*' or 'fatih/*'")
+	fs.StringVar(&r.regex, "regex", "", "match the repositories whose name with owner matches the given regular expression")
+	fs.StringVar(&r.topic, "topic", "", "match the repositories with the given topic")
+	fs.StringVar(&r.language, "language", "", "match the repositories with the given primary language")
+	fs.Var(&r.fork, "fork", "match forked repositories, or the others with --fork=false")
+	fs.Var(&r.archived, "archived", "match archived repositories, or the others with --archived=false")
+	fs.Int64Var(&r.minSize, "min-size", 0, "match the repositories with at least the given size in KB")
+	fs.Int64Var(&r.maxSize, "max-size", 0, "match the repositories with at most the given size in KB")
+	fs.StringVar(&r.pushedWithin, "pushed-within", "", "match the repositories pushed within the given age, i.e: '30d'")
+	fs.StringVar(&r.notPushedFor, "not-pushed-for", "", "match the repositories not pushed for the given age, i.e: '365d'")
+}
+
+func (r *ruleFlags) rule(action filter.Action) filter.Rule {
+	return filter.Rule{
+		Action:       action,
+		Name:         r.name,
+		Regex:        r.regex,
+		Topic:        r.topic,
+		Language:     r.language,
+		Fork:         r.fork.value,
+		Archived:     r.archived.value,
+		MinSize:      r.minSize,
+		MaxSize:      r.maxSize,
+		PushedWithin: r.pushedWithin,
+		NotPushedFor: r.notPushedFor,
+	}
+}
+
+func configFilterAddCmd(rootConfig *RootConfig) *ffcli.Command {
+	var (
+		reposet string
+		rf      ruleFlags
+		force   bool
+		dryRun  bool
+	)
+
+	fs := flag.NewFlagSet("starhook config filter add", flag.ExitOnError)
+	fs.StringVar(&reposet, "reposet", "", "name of the reposet (default: selected reposet)")
+	fs.BoolVar(&force, "force", false, "add the rule, even if it matches none of the fetched repositories")
+	fs.BoolVar(&dryRun, "dry-run", false, "show the effect of the rule without adding it")
+	rf.register(fs)
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "add",
+		ShortUsage: "starhook config filter add [flags] <include|exclude>",
+		ShortHelp:  "Add a filter rule to a reposet",
+		LongHelp:   "Add a rule, which includes or excludes the repositories matching all of the given flags. Rules are evaluated in order, the last matching rule wins.",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return flag.ErrHelp
+			}
+
+			rule := rf.rule(filter.Action(args[0]))
+			if err := rule.Validate(); err != nil {
+				return err
+			}
+
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+
+			rs, err := cfg.FindRepoSet(reposet)
+			if err != nil {
+				return err
+			}
+
+			fetched, err := loadFetchedRepos(rs)
+			if err != nil {
+				return err
+			}
+
+			if fetched == nil {
+				log.Printf("[WARN] reposet %q was never synced, the rule can't be validated", rs.Name)
+			} else if n := countMatches(rule, fetched.Repos); n == 0 && !force {
+				return fmt.Errorf("rule %q matches none of the %d repositories fetched %s, use --force to add it anyway",
+					rule, len(fetched.Repos), humanize.Time(fetched.FetchedAt))
+			}
+
+			before := rs.Filter.All()
+			if rs.Filter == nil {
+				rs.Filter = &config.FilterRules{}
+			}
+			rs.Filter.Rules = append(rs.Filter.Rules, rule)
+
+			if err := logFilterChanges(ctx, rs, before, fetched); err != nil {
+				return err
+			}
+
+			if dryRun {
+				log.Println("\nremove the '--dry-run' flag to add the rule")
+				return nil
+			}
+
+			if err := cfg.Save(); err != nil {
+				return err
+			}
+
+			log.Printf("added rule #%d to %q: %s\n", len(rs.Filter.All()), rs.Name, rule)
+			return nil
+		},
+	}
+}
+
+func configFilterRemoveCmd(rootConfig *RootConfig) *ffcli.Command {
+	var (
+		reposet string
+		dryRun  bool
+	)
+
+	fs := flag.NewFlagSet("starhook config filter remove", flag.ExitOnError)
+	fs.StringVar(&reposet, "reposet", "", "name of the reposet (default: selected reposet)")
+	fs.BoolVar(&dryRun, "dry-run", false, "show the effect of removing the rule without removing it")
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "remove",
+		ShortUsage: "starhook config filter remove [flags] <number>",
+		ShortHelp:  "Remove a filter rule from a reposet",
+		LongHelp:   "Remove the rule with the given number, as shown by 'starhook config filter list'.",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return flag.ErrHelp
+			}
+
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid rule number %q", args[0])
+			}
+
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+
+			rs, err := cfg.FindRepoSet(reposet)
+			if err != nil {
+				return err
+			}
+
+			fetched, err := loadFetchedRepos(rs)
+			if err != nil {
+				return err
+			}
+
+			before := rs.Filter.All()
+			if err := rs.Filter.Remove(n - 1); err != nil {
+				return err
+			}
+
+			if err := logFilterChanges(ctx, rs, before, fetched); err != nil {
+				return err
+			}
+
+			if dryRun {
+				log.Println("\nremove the '--dry-run' flag to remove the rule")
+				return nil
+			}
+
+			if err := cfg.Save(); err != nil {
+				return err
+			}
+
+			log.Printf("removed rule #%d from %q: %s\n", n, rs.Name, before[n-1])
+			return nil
+		},
+	}
+}
+
+func configFilterListCmd(rootConfig *RootConfig) *ffcli.Command {
+	var reposet string
+
+	fs := flag.NewFlagSet("starhook config filter list", flag.ExitOnError)
+	fs.StringVar(&reposet, "reposet", "", "name of the reposet (default: selected reposet)")
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "list",
+		ShortUsage: "starhook config filter list [flags]",
+		ShortHelp:  "List the filter rules of a reposet",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+
+			rs, err := cfg.FindRepoSet(reposet)
+			if err != nil {
+				return err
+			}
+
+			fetched, err := loadFetchedRepos(rs)
+			if err != nil {
+				return err
+			}
+
+			rules := rs.Filter.All()
+			f, err := filter.New(rules)
+			if err != nil {
+				return err
+			}
+
+			const padding = 3
+			w := tabwriter.NewWriter(rootConfig.out, 0, 0, padding, ' ', 0)
+			for i, rule := range rules {
+				if fetched == nil {
+					fmt.Fprintf(w, "%3d\t%s\n", i+1, rule)
+					continue
+				}
+
+				fmt.Fprintf(w, "%3d\t%s\tmatches %d\n", i+1, rule, countMatches(rule, fetched.Repos))
+			}
+			w.Flush()
+
+			if fetched != nil {
+				log.Printf("==> %d of %d repositories fetched %s are included\n",
+					len(f.Apply(fetched.Repos)), len(fetched.Repos), humanize.Time(fetched.FetchedAt))
+			}
+
+			return nil
+		},
+	}
+}
+
+func configFilterTestCmd(rootConfig *RootConfig) *ffcli.Command {
+	var (
+		reposet  string
+		excluded bool
+	)
+
+	fs := flag.NewFlagSet("starhook config filter test", flag.ExitOnError)
+	fs.StringVar(&reposet, "reposet", "", "name of the reposet (default: selected reposet)")
+	fs.BoolVar(&excluded, "excluded", false, "only show the excluded repositories")
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "test",
+		ShortUsage: "starhook config filter test [flags]",
+		ShortHelp:  "Show which filter rule matches each repository of a reposet",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+
+			rs, err := cfg.FindRepoSet(reposet)
+			if err != nil {
+				return err
+			}
+
+			rules := rs.Filter.All()
+			f, err := filter.New(rules)
+			if err != nil {
+				return err
+			}
+
+			token, err := loadRepoSetToken(rs)
+			if err != nil {
+				return err
+			}
+
+			provider, err := newProvider(ctx, rs, token)
+			if err != nil {
+				return err
+			}
+
+			log.Println("querying for latest repositories ...")
+			repos, err := provider.ListRepos(ctx, rs.RepoSource())
+			if err != nil {
+				return err
+			}
+
+			const padding = 3
+			w := tabwriter.NewWriter(rootConfig.out, 0, 0, padding, ' ', 0)
+
+			included := 0
+			for _, repo := range repos {
+				d := f.Decide(repo)
+				if d.Include {
+					included++
+				}
+
+				if excluded && d.Include {
+					continue
+				}
+
+				action, rule := "exclude", "default"
+				if d.Include {
+					action = "include"
+				}
+				if d.Rule != -1 {
+					rule = fmt.Sprintf("#%d %s", d.Rule+1, rules[d.Rule])
+				}
+
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", action, repo.Nwo, rule, d.Reason)
+			}
+			w.Flush()
+
+			log.Printf("==> %d of %d repositories are included\n", included, len(repos))
+			return nil
+		},
+	}
+}
+
+// countMatches returns the number of the given repositories the rule
+// matches.
+func countMatches(rule filter.Rule, repos []*internal.Repository) int {
+	f, err := filter.New([]filter.Rule{rule})
+	if err != nil {
+		return 0
+	}
+
+	n := 0
+	for _, repo := range repos {
+		if f.Decide(repo).Rule != -1 {
+			n++
+		}
+	}
+	return n
+}
+
+// logFilterChanges logs the local repositories the next sync removes, and
+// the repositories it adds, if the filter rules of the given reposet were
+// changed from before. Nothing is logged if the reposet was never synced.
+func logFilterChanges(ctx context.Context, rs *config.RepoSet, before []filter.Rule, fetched *fetchedRepos) error {
+	if fetched == nil {
+		return nil
+	}
+
+	from, err := filter.New(before)
+	if err != nil {
+		return err
+	}
+
+	to, err := filter.New(rs.Filter.All())
+	if err != nil {
+		return err
+	}
+
+	local, err := storedRepos(ctx, rs)
+	if err != nil {
+		return err
+	}
+
+	removed, added := filterChanges(from, to, fetched.Repos, local)
+
+	if len(removed) == 0 && len(added) == 0 {
+		log.Println("the next sync doesn't add or remove any repositories")
+		return nil
+	}
+
+	if len(removed) != 0 {
+		policy, err := internal.ParseRemovePolicy(rs.RemovePolicy)
+		if err != nil {
+			return err
+		}
+
+		if policy == internal.RemoveKeep {
+			log.Printf("the next sync stops syncing %d repositories, they're kept on disk (policy: %s):\n", len(removed), policy)
+		} else {
+			log.Printf("the next sync removes %d repositories from disk (policy: %s):\n", len(removed), policy)
+		}
+		for _, nwo := range removed {
+			log.Printf("  %s\n", nwo)
+		}
+	}
+
+	if len(added) != 0 {
+		log.Printf("the next sync clones %d repositories:\n", len(added))
+		for _, nwo := range added {
+			log.Printf("  %s\n", nwo)
+		}
+	}
+
+	return nil
+}
+
+// filterChanges returns the local repositories that are removed, and the
+// fetched repositories that are added, if the filter is changed from one
+// filter to the other.
+func filterChanges(from, to *filter.Filter, fetched, local []*internal.Repository) (removed, added []string) {
+	synced := make(map[string]bool, len(local))
+	for _, repo := range local {
+		synced[repo.Nwo] = true
+	}
+
+	for _, repo := range fetched {
+		was, is := from.Decide(repo).Include, to.Decide(repo).Include
+		switch {
+		case was && !is && synced[repo.Nwo]:
+			removed = append(removed, repo.Nwo)
+		case !was && is && !synced[repo.Nwo]:
+			added = append(added, repo.Nwo)
+		}
+	}
+
+	return removed, added
+}
@@ -0,0 +1,216 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/config"
+	"github.com/fatih/starhook/internal/filter"
+	"github.com/fatih/starhook/internal/jsonstore"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFilterChanges(t *testing.T) {
+	vimgo := &internal.Repository{Nwo: "fatih/vim-go", Metadata: internal.Metadata{Language: "Vim script"}}
+	color := &internal.Repository{Nwo: "fatih/color", Metadata: internal.Metadata{Language: "Go"}}
+	structs := &internal.Repository{Nwo: "fatih/structs", Metadata: internal.Metadata{Language: "Go"}}
+	fetched := []*internal.Repository{vimgo, color, structs}
+
+	excludeGo := filter.Rule{Action: filter.Exclude, Language: "Go"}
+	includeColor := filter.Rule{Action: filter.Include, Name: "fatih/color"}
+
+	tests := []struct {
+		name        string
+		from, to    []filter.Rule
+		local       []*internal.Repository
+		wantRemoved []string
+		wantAdded   []string
+	}{
+		{
+			name:  "unchanged",
+			local: fetched,
+		},
+		{
+			name:        "exclude synced repos",
+			to:          []filter.Rule{excludeGo},
+			local:       []*internal.Repository{vimgo, color},
+			wantRemoved: []string{"fatih/color"},
+		},
+		{
+			// an include rule excludes the other repositories
+			name:        "include repos",
+			from:        []filter.Rule{excludeGo},
+			to:          []filter.Rule{excludeGo, includeColor},
+			local:       []*internal.Repository{vimgo},
+			wantRemoved: []string{"fatih/vim-go"},
+			wantAdded:   []string{"fatih/color"},
+		},
+		{
+			name:      "never synced",
+			from:      []filter.Rule{excludeGo},
+			wantAdded: []string{"fatih/color", "fatih/structs"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			from, err := filter.New(tt.from)
+			c.Assert(err, qt.IsNil)
+			to, err := filter.New(tt.to)
+			c.Assert(err, qt.IsNil)
+
+			removed, added := filterChanges(from, to, fetched, tt.local)
+			c.Assert(removed, qt.DeepEquals, tt.wantRemoved)
+			c.Assert(added, qt.DeepEquals, tt.wantAdded)
+		})
+	}
+}
+
+func TestConfigFilterCmd_dryRun(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	rs := newTestRepoSet(c, &config.FilterRules{Include: []string{"fatih/vim-go"}})
+	c.Assert(saveFetchedRepos(rs, []*internal.Repository{
+		{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go"},
+		{Nwo: "fatih/color", Owner: "fatih", Name: "color"},
+	}), qt.IsNil)
+
+	out := captureLog(c)
+	rootConfig := &RootConfig{out: &bytes.Buffer{}}
+
+	// the reposet was never synced, the store isn't created
+	err := configFilterAddCmd(rootConfig).ParseAndRun(ctx,
+		[]string{"--reposet", rs.Name, "--dry-run", "--name", "fatih/color", "include"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(out.String(), qt.Equals, "the next sync clones 1 repositories:\n"+
+		"  fatih/color\n"+
+		"\nremove the '--dry-run' flag to add the rule\n")
+
+	_, err = os.Stat(filepath.Join(rs.ReposDir, jsonstore.DBFile))
+	c.Assert(os.IsNotExist(err), qt.IsTrue, qt.Commentf("store should not be created"))
+
+	store, err := jsonstore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.UpsertRepos(ctx, []*internal.Repository{
+		{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go"},
+	}), qt.IsNil)
+
+	out.Reset()
+	err = configFilterRemoveCmd(rootConfig).ParseAndRun(ctx, []string{"--reposet", rs.Name, "--dry-run", "1"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(out.String(), qt.Equals, "the next sync clones 1 repositories:\n"+
+		"  fatih/color\n"+
+		"\nremove the '--dry-run' flag to remove the rule\n")
+
+	out.Reset()
+	err = configFilterAddCmd(rootConfig).ParseAndRun(ctx,
+		[]string{"--reposet", rs.Name, "--dry-run", "--name", "fatih/vim-go", "exclude"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(out.String(), qt.Equals, "the next sync removes 1 repositories from disk (policy: archive):\n"+
+		"  fatih/vim-go\n"+
+		"\nremove the '--dry-run' flag to add the rule\n")
+
+	// the store isn't read while the reposet is synced
+	lock, err := lockRepoSet(rs)
+	c.Assert(err, qt.IsNil)
+	err = configFilterRemoveCmd(rootConfig).ParseAndRun(ctx, []string{"--reposet", rs.Name, "--dry-run", "1"})
+	c.Assert(err, qt.ErrorMatches, `reposet "oss" is in use by another starhook process.*`)
+	c.Assert(lock.Release(), qt.IsNil)
+
+	// dry-runs don't change the config
+	cfg, err := config.Load()
+	c.Assert(err, qt.IsNil)
+	got, err := cfg.FindRepoSet(rs.Name)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Filter.All(), qt.DeepEquals, rs.Filter.All())
+}
+
+func TestConfigFilterCmd(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	rs := newTestRepoSet(c, &config.FilterRules{
+		Include: []string{"fatih/vim-go"},
+		Exclude: []string{"fatih/color"},
+	})
+
+	captureLog(c)
+	var out bytes.Buffer
+	rootConfig := &RootConfig{out: &out}
+
+	err := configFilterAddCmd(rootConfig).ParseAndRun(ctx, []string{"--reposet", rs.Name, "--topic", "deprecated", "exclude"})
+	c.Assert(err, qt.IsNil)
+
+	err = configFilterListCmd(rootConfig).ParseAndRun(ctx, []string{"--reposet", rs.Name})
+	c.Assert(err, qt.IsNil)
+	c.Assert(out.String(), qt.Equals, ""+
+		"  1   include name=fatih/vim-go\n"+
+		"  2   exclude name=fatih/color\n"+
+		"  3   exclude topic=deprecated\n")
+
+	// the numbers of the list are removed, across include, exclude and rules
+	err = configFilterRemoveCmd(rootConfig).ParseAndRun(ctx, []string{"--reposet", rs.Name, "2"})
+	c.Assert(err, qt.IsNil)
+
+	err = configFilterRemoveCmd(rootConfig).ParseAndRun(ctx, []string{"--reposet", rs.Name, "3"})
+	c.Assert(err, qt.ErrorMatches, `there is no filter rule #3`)
+
+	cfg, err := config.Load()
+	c.Assert(err, qt.IsNil)
+	got, err := cfg.FindRepoSet(rs.Name)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Filter.All(), qt.DeepEquals, []filter.Rule{
+		{Action: filter.Include, Name: "fatih/vim-go"},
+		{Action: filter.Exclude, Topic: "deprecated"},
+	})
+}
+
+// newTestRepoSet saves a config with a single selected reposet with the
+// given filter rules into a temporary config directory.
+func newTestRepoSet(c *qt.C, rules *config.FilterRules) *config.RepoSet {
+	c.Helper()
+
+	home := c.Mkdir()
+	c.Setenv("HOME", home)
+	c.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+
+	cfg, err := config.New()
+	c.Assert(err, qt.IsNil)
+
+	rs := &config.RepoSet{
+		Name:     "oss",
+		Query:    internal.Queries{"user:fatih"},
+		ReposDir: c.Mkdir(),
+		Filter:   rules,
+	}
+	c.Assert(cfg.AddRepoSet(rs, false), qt.IsNil)
+	cfg.Selected = rs.Name
+	c.Assert(cfg.Save(), qt.IsNil)
+
+	return rs
+}
+
+// captureLog returns the log output, without timestamps, until the test
+// finishes.
+func captureLog(c *qt.C) *bytes.Buffer {
+	var out bytes.Buffer
+
+	w, flags := log.Writer(), log.Flags()
+	log.SetOutput(&out)
+	log.SetFlags(0)
+	c.Cleanup(func() {
+		log.SetOutput(w)
+		log.SetFlags(flags)
+	})
+
+	return &out
+}
@@ -5,8 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
+	"github.com/fatih/starhook/internal"
+
 	"github.com/dustin/go-humanize"
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
@@ -16,6 +21,24 @@ import (
 type List struct {
 	rootConfig      *RootConfig
 	withAccessTimes bool
+
+	owner        string
+	name         string
+	branch       string
+	state        string
+	syncedAfter  string
+	syncedBefore string
+	language     string
+	topic        string
+	visibility   string
+	archived     optionalBool
+	fork         optionalBool
+	long         bool
+
+	sortBy     string
+	descending bool
+	limit      int
+	offset     int
 }
 
 // New creates a new ffcli.Command for the list subcommand.
@@ -26,6 +49,22 @@ func listCmd(rootConfig *RootConfig) *ffcli.Command {
 
 	fs := flag.NewFlagSet("starhook list", flag.ExitOnError)
 	fs.BoolVar(&cfg.withAccessTimes, "a", false, "include last access time of each object")
+	fs.StringVar(&cfg.owner, "owner", "", "only list the repositories of the given owner")
+	fs.StringVar(&cfg.name, "name", "", "only list the repositories matching the given glob, i.e: 'vim-*' or 'fatih/*'")
+	fs.StringVar(&cfg.branch, "branch", "", "only list the repositories with the given default branch")
+	fs.StringVar(&cfg.state, "state", "", "only list the repositories with the given sync state: 'never', 'stale' or 'failed'")
+	fs.StringVar(&cfg.syncedAfter, "synced-after", "", "only list the repositories synced after the given date or duration, i.e: '2021-10-01' or '24h'")
+	fs.StringVar(&cfg.syncedBefore, "synced-before", "", "only list the repositories synced before the given date or duration, i.e: '2021-10-01' or '168h'")
+	fs.StringVar(&cfg.language, "language", "", "only list the repositories with the given primary language, i.e: 'go'")
+	fs.StringVar(&cfg.topic, "topic", "", "only list the repositories with the given topic")
+	fs.StringVar(&cfg.visibility, "visibility", "", "only list the repositories with the given visibility: 'public', 'private' or 'internal'")
+	fs.Var(&cfg.archived, "archived", "only list archived repositories, or the others with --archived=false")
+	fs.Var(&cfg.fork, "fork", "only list forked repositories, or the others with --fork=false")
+	fs.BoolVar(&cfg.long, "l", false, "show the metadata of the repositories")
+	fs.StringVar(&cfg.sortBy, "sort", internal.SortByID, "field to sort by: 'id', 'name', 'synced_at', 'branch_updated_at', 'created_at', 'stars' or 'pushed_at'")
+	fs.BoolVar(&cfg.descending, "desc", false, "sort in descending order")
+	fs.IntVar(&cfg.limit, "limit", 0, "maximum number of repositories to list (default: all)")
+	fs.IntVar(&cfg.offset, "offset", 0, "number of repositories to skip")
 
 	rootConfig.RegisterFlags(fs)
 
@@ -40,25 +79,152 @@ func listCmd(rootConfig *RootConfig) *ffcli.Command {
 
 // Exec function for this command.
 func (c *List) Exec(ctx context.Context, _ []string) error {
+	state, err := internal.ParseSyncState(c.state)
+	if err != nil {
+		return fmt.Errorf("--state: %w", err)
+	}
+
+	now := time.Now()
+	syncedAfter, err := parseTime(c.syncedAfter, now)
+	if err != nil {
+		return fmt.Errorf("--synced-after: %w", err)
+	}
+
+	syncedBefore, err := parseTime(c.syncedBefore, now)
+	if err != nil {
+		return fmt.Errorf("--synced-before: %w", err)
+	}
+
+	filter := internal.RepositoryFilter{
+		Owner:        c.owner,
+		Name:         c.name,
+		Branch:       c.branch,
+		State:        state,
+		SyncedAfter:  syncedAfter,
+		SyncedBefore: syncedBefore,
+		Language:     c.language,
+		Topic:        c.topic,
+		Visibility:   c.visibility,
+		Archived:     c.archived.value,
+		Fork:         c.fork.value,
+	}
+
+	opt := internal.FindOptions{
+		Offset:     c.offset,
+		Limit:      c.limit,
+		SortBy:     c.sortBy,
+		Descending: c.descending,
+	}
+
 	svc, err := newStarHookService()
 	if err != nil {
 		return err
 	}
+	defer svc.Close()
 
-	repos, err := svc.ListRepos(ctx)
+	repos, err := svc.FindRepos(ctx, filter, opt)
 	if err != nil {
 		return err
 	}
 
+	const padding = 2
+	w := tabwriter.NewWriter(c.rootConfig.out, 0, 0, padding, ' ', 0)
+
 	lastUpdated := time.Time{}
 	for _, repo := range repos {
 		if repo.UpdatedAt.After(lastUpdated) {
 			lastUpdated = repo.UpdatedAt
 		}
-		fmt.Printf("%3d %s\n", repo.ID, repo.Nwo)
+
+		if !c.long {
+			fmt.Fprintf(w, "%3d %s\n", repo.ID, repo.Nwo)
+			continue
+		}
+
+		fmt.Fprintf(w, "%3d %s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			repo.ID, repo.Nwo, orDash(repo.Language), orDash(repo.Visibility), repo.Stars,
+			pushed(repo.PushedAt), orDash(strings.Join(repoFlags(repo), ",")),
+			orDash(strings.Join(repo.Topics, ",")))
 	}
+	w.Flush()
 
 	log.Printf("==> local %d repositories (last synced: %s)\n", len(repos), humanize.Time(lastUpdated))
 
 	return nil
 }
+
+// repoFlags returns the flags of the given repository, i.e: "archived".
+func repoFlags(repo *internal.Repository) []string {
+	var flags []string
+	if repo.Archived {
+		flags = append(flags, "archived")
+	}
+	if repo.Fork {
+		flags = append(flags, "fork")
+	}
+	return flags
+}
+
+// pushed returns the given time of the last push relative to now.
+func pushed(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return humanize.Time(t)
+}
+
+// orDash returns "-" for an empty value, so the columns stay aligned.
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// optionalBool is a boolean flag, which is nil unless it's set.
+type optionalBool struct {
+	value *bool
+}
+
+func (b *optionalBool) String() string {
+	if b.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*b.value)
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+
+	b.value = &v
+	return nil
+}
+
+// IsBoolFlag allows setting the flag without a value, i.e: --archived.
+func (b *optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+// parseTime parses a date, such as "2021-10-01" or "2021-10-01T10:00:00Z",
+// or a duration, such as "24h", which is the time the duration before now.
+// An empty value returns the zero time.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, should be a date, i.e: '2021-10-01', or a duration, i.e: '24h'", value)
+}
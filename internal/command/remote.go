@@ -0,0 +1,91 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+
+	"github.com/fatih/starhook/internal"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// remoteCmd creates a new ffcli.Command for the remote subcommand.
+func remoteCmd(rootConfig *RootConfig) *ffcli.Command {
+	fs := flag.NewFlagSet("starhook remote", flag.ExitOnError)
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "remote",
+		ShortUsage: "starhook remote <subcommand> [flags]",
+		ShortHelp:  "Manage the remotes of the repositories",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			return flag.ErrHelp
+		},
+		Subcommands: []*ffcli.Command{
+			remoteRewriteCmd(rootConfig),
+		},
+	}
+}
+
+func remoteRewriteCmd(rootConfig *RootConfig) *ffcli.Command {
+	fs := flag.NewFlagSet("starhook remote rewrite", flag.ExitOnError)
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "rewrite",
+		ShortUsage: "starhook remote rewrite [flags]",
+		ShortHelp:  "Rewrite the origin of the repositories to match the clone protocol",
+		LongHelp: "Rewrite the origin URL of all repositories of the selected reposet, " +
+			"i.e: after its clone protocol or SSH host is changed.",
+		FlagSet: fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			rs, err := selectedRepoSet()
+			if err != nil {
+				return err
+			}
+
+			lock, err := lockRepoSet(rs)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			provider, err := newProvider(ctx, rs, "")
+			if err != nil {
+				return err
+			}
+
+			store, fsStore, err := openStores(rs, provider, "")
+			if err != nil {
+				return err
+			}
+			if c, ok := store.(io.Closer); ok {
+				defer c.Close()
+			}
+
+			repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+			if err != nil {
+				return err
+			}
+
+			var rewritten int
+			for _, repo := range repos {
+				ok, err := fsStore.RewriteRemote(ctx, repo)
+				if err != nil {
+					return err
+				}
+
+				if ok {
+					rewritten++
+					log.Printf("  %q is rewritten\n", repo.Nwo)
+				}
+			}
+
+			log.Printf("==> rewritten %d remotes\n", rewritten)
+			return nil
+		},
+	}
+}
@@ -2,15 +2,26 @@ package command
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/fatih/starhook/internal"
 	"github.com/fatih/starhook/internal/config"
+	"github.com/fatih/starhook/internal/file"
 	"github.com/fatih/starhook/internal/fsstore"
 	"github.com/fatih/starhook/internal/gh"
+	"github.com/fatih/starhook/internal/gitea"
+	"github.com/fatih/starhook/internal/gitlab"
 	"github.com/fatih/starhook/internal/jsonstore"
+	"github.com/fatih/starhook/internal/lockfile"
+	"github.com/fatih/starhook/internal/sqlitestore"
 	"github.com/fatih/starhook/internal/starhook"
 
 	"github.com/hashicorp/logutils"
@@ -34,8 +45,11 @@ func Run() error {
 	rootCommand, rootConfig := newRootCommand()
 
 	rootCommand.Subcommands = []*ffcli.Command{
+		archiveCmd(rootConfig),
 		configCmd(rootConfig),
 		listCmd(rootConfig),
+		remoteCmd(rootConfig),
+		storeCmd(rootConfig),
 		syncCmd(rootConfig),
 	}
 
@@ -101,6 +115,8 @@ func (c *RootConfig) Exec(context.Context, []string) error {
 	return flag.ErrHelp
 }
 
+// newStarHookService returns the service of the selected reposet. The caller
+// must close it, which closes its metadata store.
 func newStarHookService() (*starhook.Service, error) {
 	cfg, err := config.Load()
 	if err != nil {
@@ -114,27 +130,208 @@ func newStarHookService() (*starhook.Service, error) {
 
 	ctx := context.Background()
 
-	ring, err := openKeyring()
+	token, err := loadRepoSetToken(rs)
 	if err != nil {
 		return nil, err
 	}
 
-	i, err := ring.Get(keyringKey)
+	provider, err := newProvider(ctx, rs, token)
 	if err != nil {
 		return nil, err
 	}
 
-	ghClient := gh.NewClient(ctx, string(i.Data))
+	store, fsStore, err := openStores(rs, provider, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return starhook.NewService(provider, store, fsStore), nil
+}
+
+// newProvider returns the provider of the given reposet.
+func newProvider(ctx context.Context, rs *config.RepoSet, token string) (internal.Provider, error) {
+	apiURL := rs.ProviderAPIURL()
+
+	switch rs.ProviderName() {
+	case config.ProviderGitHub:
+		if apiURL == "" {
+			return gh.NewProvider(gh.NewClient(ctx, token), rs.ProviderHost()), nil
+		}
+
+		client, err := gh.NewEnterpriseClient(ctx, token, apiURL)
+		if err != nil {
+			return nil, err
+		}
+		return gh.NewProvider(client, rs.ProviderHost()), nil
+	case config.ProviderGitLab:
+		return gitlab.NewClient(apiURL, token)
+	case config.ProviderGitea:
+		return gitea.NewClient(apiURL, token)
+	case config.ProviderFile:
+		return file.NewProvider(rs.Source), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q, should be %q, %q, %q or %q",
+			rs.Provider, config.ProviderGitHub, config.ProviderGitLab, config.ProviderGitea, config.ProviderFile)
+	}
+}
+
+// openStores opens the metadata and repository stores of the given reposet.
+// The provider and token are used to clone and update the repositories,
+// they're optional for commands that don't fetch them.
+func openStores(rs *config.RepoSet, provider internal.Provider, token string) (internal.MetadataStore, *fsstore.RepositoryStore, error) {
+	store, err := openMetadataStore(rs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fsStore, err := newRepositoryStore(rs, provider, token)
+	if err != nil {
+		if c, ok := store.(io.Closer); ok {
+			c.Close()
+		}
+		return nil, nil, err
+	}
+
+	return store, fsStore, nil
+}
+
+// newRepositoryStore returns the repository store of the given reposet.
+// provider is optional, it's only needed to clone repositories.
+func newRepositoryStore(rs *config.RepoSet, provider internal.Provider, token string) (*fsstore.RepositoryStore, error) {
+	opts := fsstore.Options{
+		Layout: rs.Layout,
+		Token:  token,
+
+		CloneProtocol: rs.CloneProtocol,
+		SSHHost:       rs.SSHHost,
+		Host:          rs.ProviderHost(),
+	}
+
+	if provider != nil {
+		opts.CloneURL = provider.CloneURL
+	}
+
+	// GitLab only accepts tokens over HTTPS with this user name
+	if rs.ProviderName() == config.ProviderGitLab {
+		opts.TokenUser = "oauth2"
+	}
+
+	return fsstore.NewRepositoryStore(rs.ReposDir, opts)
+}
+
+// openMetadataStore opens the metadata store of the given reposet.
+func openMetadataStore(rs *config.RepoSet) (internal.MetadataStore, error) {
+	switch rs.StoreName() {
+	case config.StoreJSON:
+		return jsonstore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+	case config.StoreSQLite:
+		return sqlitestore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+	default:
+		return nil, fmt.Errorf("unknown store %q, should be %q or %q",
+			rs.Store, config.StoreJSON, config.StoreSQLite)
+	}
+}
+
+// storePath returns the path of the metadata store of the given reposet.
+func storePath(rs *config.RepoSet) string {
+	if rs.StoreName() == config.StoreSQLite {
+		return filepath.Join(rs.ReposDir, sqlitestore.DBFile)
+	}
+	return filepath.Join(rs.ReposDir, jsonstore.DBFile)
+}
+
+// storedRepos returns the repositories in the metadata store of the given
+// reposet. The store is read while the reposet is locked. It returns nil if
+// the store doesn't exist, it's not created.
+func storedRepos(ctx context.Context, rs *config.RepoSet) ([]*internal.Repository, error) {
+	if _, err := os.Stat(storePath(rs)); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	lock, err := lockRepoSet(rs)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
 
-	store, err := jsonstore.NewMetadataStore(rs.ReposDir, rs.Query)
+	store, err := openMetadataStore(rs)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := store.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	return store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+}
+
+// lockFile is the name of the lock file inside the directory of a reposet.
+const lockFile = "starhook.lock"
+
+// lockRepoSet locks the directory of the given reposet, so it's not modified
+// by multiple starhook processes at once. The directory is created if it
+// doesn't exist.
+func lockRepoSet(rs *config.RepoSet) (*lockfile.Lock, error) {
+	if err := os.MkdirAll(rs.ReposDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	lock, err := lockfile.Acquire(filepath.Join(rs.ReposDir, lockFile))
+	if errors.Is(err, lockfile.ErrLocked) {
+		return nil, fmt.Errorf("reposet %q is in use by another starhook process, i.e: 'starhook sync'", rs.Name)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	fsStore, err := fsstore.NewRepositoryStore(rs.ReposDir)
+	return lock, nil
+}
+
+// fetchedFile is the name of the file inside the directory of a reposet that
+// contains the repositories fetched on the last sync, before they're
+// filtered.
+const fetchedFile = "starhook.fetched.json"
+
+// fetchedRepos are the repositories fetched on the last sync of a reposet.
+type fetchedRepos struct {
+	FetchedAt time.Time              `json:"fetched_at"`
+	Repos     []*internal.Repository `json:"repos"`
+}
+
+// saveFetchedRepos saves the given repositories as the last fetched
+// repositories of the given reposet.
+func saveFetchedRepos(rs *config.RepoSet, repos []*internal.Repository) error {
+	out, err := json.Marshal(&fetchedRepos{
+		FetchedAt: time.Now().UTC(),
+		Repos:     repos,
+	})
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(rs.ReposDir, fetchedFile)
+	if err := os.WriteFile(path+".tmp", out, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// loadFetchedRepos loads the last fetched repositories of the given reposet.
+// It returns nil if the reposet was never synced.
+func loadFetchedRepos(rs *config.RepoSet) (*fetchedRepos, error) {
+	out, err := os.ReadFile(filepath.Join(rs.ReposDir, fetchedFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return starhook.NewService(ghClient, store, fsStore), nil
+	var fetched *fetchedRepos
+	if err := json.Unmarshal(out, &fetched); err != nil {
+		return nil, fmt.Errorf("%s: %w", fetchedFile, err)
+	}
+
+	return fetched, nil
 }
@@ -0,0 +1,373 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/config"
+	"github.com/fatih/starhook/internal/filter"
+	"github.com/fatih/starhook/internal/fsstore"
+	"github.com/fatih/starhook/internal/jsonstore"
+	"github.com/fatih/starhook/internal/sqlitestore"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+const keysHelp = "Keys are the names of the reposet's settings in the config file, nested settings are separated with a dot, i.e: 'query', 'repos_dir' or 'source.org'. Lists are separated with commas, i.e: 'filter.exclude' is 'fatih/color,fatih/structs'. Run 'starhook config get' to list all keys."
+
+func configGetCmd(rootConfig *RootConfig) *ffcli.Command {
+	var reposet string
+
+	fs := flag.NewFlagSet("starhook config get", flag.ExitOnError)
+	fs.StringVar(&reposet, "reposet", "", "name of the reposet (default: selected reposet)")
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "get",
+		ShortUsage: "starhook config get [flags] [<key>]",
+		ShortHelp:  "Show a setting of a reposet, or all settings",
+		LongHelp:   keysHelp,
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) > 1 {
+				return flag.ErrHelp
+			}
+
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+
+			rs, err := cfg.FindRepoSet(reposet)
+			if err != nil {
+				return err
+			}
+
+			if len(args) == 1 {
+				value, err := rs.Get(args[0])
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintln(rootConfig.out, value)
+				return nil
+			}
+
+			const padding = 3
+			w := tabwriter.NewWriter(rootConfig.out, 0, 0, padding, ' ', 0)
+			for _, key := range config.Keys() {
+				value, err := rs.Get(key)
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(w, "%s\t%s\n", key, value)
+			}
+			w.Flush()
+
+			return nil
+		},
+	}
+}
+
+func configSetCmd(rootConfig *RootConfig) *ffcli.Command {
+	var (
+		reposet string
+		move    bool
+	)
+
+	fs := flag.NewFlagSet("starhook config set", flag.ExitOnError)
+	fs.StringVar(&reposet, "reposet", "", "name of the reposet (default: selected reposet)")
+	fs.BoolVar(&move, "move", false, "move the existing repositories if 'repos_dir' is changed")
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "set",
+		ShortUsage: "starhook config set [flags] <key> <value>",
+		ShortHelp:  "Change a setting of a reposet",
+		LongHelp:   keysHelp,
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 2 {
+				return flag.ErrHelp
+			}
+
+			return updateRepoSet(ctx, reposet, move, func(rs *config.RepoSet) error {
+				return rs.Set(args[0], args[1])
+			})
+		},
+	}
+}
+
+func configUnsetCmd(rootConfig *RootConfig) *ffcli.Command {
+	var reposet string
+
+	fs := flag.NewFlagSet("starhook config unset", flag.ExitOnError)
+	fs.StringVar(&reposet, "reposet", "", "name of the reposet (default: selected reposet)")
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "unset",
+		ShortUsage: "starhook config unset [flags] <key>",
+		ShortHelp:  "Reset a setting of a reposet to its default",
+		LongHelp:   keysHelp,
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return flag.ErrHelp
+			}
+
+			return updateRepoSet(ctx, reposet, false, func(rs *config.RepoSet) error {
+				return rs.Unset(args[0])
+			})
+		},
+	}
+}
+
+// updateRepoSet changes the reposet with the given name with fn, and saves
+// it if it's still valid. The metadata store and the directory of the
+// reposet are updated to the new query and directory.
+func updateRepoSet(ctx context.Context, name string, move bool, fn func(rs *config.RepoSet) error) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	rs, err := cfg.FindRepoSet(name)
+	if err != nil {
+		return err
+	}
+
+	oldName, oldQuery, oldDir, oldStore := rs.Name, rs.SourceQuery(), rs.ReposDir, rs.StoreName()
+
+	if err := fn(rs); err != nil {
+		return err
+	}
+
+	if err := validateRepoSet(rs); err != nil {
+		return err
+	}
+
+	if rs.StoreName() != oldStore {
+		return fmt.Errorf("the store can't be changed, use 'starhook store migrate --to %s' instead", rs.StoreName())
+	}
+
+	if rs.Name != oldName {
+		for _, other := range cfg.RepoSets {
+			if other != rs && other.Name == rs.Name {
+				return fmt.Errorf("repo set with name %q already exists", rs.Name)
+			}
+		}
+
+		if cfg.Selected == oldName {
+			cfg.Selected = rs.Name
+		}
+	}
+
+	dirChanged := rs.ReposDir != oldDir
+	queryChanged := !rs.SourceQuery().Equal(oldQuery)
+
+	if _, err := os.Stat(oldDir); errors.Is(err, fs.ErrNotExist) || (!dirChanged && !queryChanged) {
+		return saveRepoSet(cfg, rs)
+	}
+
+	// make sure the reposet isn't synced while its store and directory are
+	// changed. The lock file is moved with the directory.
+	old := &config.RepoSet{Name: oldName, ReposDir: oldDir, Store: rs.Store}
+	lock, err := lockRepoSet(old)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if queryChanged {
+		if err := setStoreQuery(ctx, old, oldQuery, rs.SourceQuery()); err != nil {
+			return err
+		}
+	}
+
+	// undo reverts the changes of the store and the directory, if the
+	// config can't be saved
+	undo := func(moved bool) {
+		if moved {
+			if err := os.Rename(rs.ReposDir, oldDir); err != nil {
+				log.Printf("[ERROR] couldn't move the repositories back, move %q to %q manually: %s\n", rs.ReposDir, oldDir, err)
+				return
+			}
+		}
+
+		if queryChanged {
+			if err := setStoreQuery(ctx, old, rs.SourceQuery(), oldQuery); err != nil {
+				log.Printf("[ERROR] couldn't reset the query of the metadata store: %s\n", err)
+			}
+		}
+	}
+
+	var moved bool
+	if dirChanged {
+		moved, err = moveRepoSetDir(rs, oldDir, move)
+		if err != nil {
+			undo(false)
+			return err
+		}
+	}
+
+	if err := saveRepoSet(cfg, rs); err != nil {
+		undo(moved)
+		return err
+	}
+
+	return nil
+}
+
+// saveRepoSet saves the config with the given changed reposet.
+func saveRepoSet(cfg *config.Config, rs *config.RepoSet) error {
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+
+	log.Printf("updated %q\n", rs.Name)
+	return nil
+}
+
+// moveRepoSetDir moves the repositories of the given reposet from the old
+// directory to its current directory, if move is true. It reports whether
+// the repositories are moved. The reposet must be locked.
+func moveRepoSetDir(rs *config.RepoSet, oldDir string, move bool) (bool, error) {
+	if !move {
+		log.Printf("the repositories are still in %q, they're cloned again on the next sync. Use --move to move them to %q\n",
+			oldDir, rs.ReposDir)
+		return false, nil
+	}
+
+	if entries, err := os.ReadDir(rs.ReposDir); err == nil && len(entries) != 0 {
+		return false, fmt.Errorf("%q is not empty, the repositories can't be moved", rs.ReposDir)
+	}
+
+	os.Remove(rs.ReposDir) // empty, if it exists
+	if err := os.MkdirAll(filepath.Dir(rs.ReposDir), 0o700); err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(oldDir, rs.ReposDir); err != nil {
+		return false, fmt.Errorf("couldn't move the repositories, move %q to %q manually: %w", oldDir, rs.ReposDir, err)
+	}
+
+	log.Printf("moved the repositories from %q to %q\n", oldDir, rs.ReposDir)
+	return true, nil
+}
+
+// setStoreQuery changes the query of the given reposet's metadata store from
+// one query to another. It does nothing if the store doesn't exist. The
+// reposet must be locked.
+func setStoreQuery(ctx context.Context, rs *config.RepoSet, from, to internal.Queries) error {
+	if _, err := os.Stat(storePath(rs)); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	switch rs.StoreName() {
+	case config.StoreJSON:
+		store, err := jsonstore.NewMetadataStore(rs.ReposDir, from...)
+		if err != nil {
+			return err
+		}
+		return store.SetQuery(ctx, to...)
+	default:
+		store, err := sqlitestore.NewMetadataStore(rs.ReposDir, from...)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		return store.SetQuery(ctx, to...)
+	}
+}
+
+// validateRepoSet checks whether the settings of the given reposet are
+// valid.
+func validateRepoSet(rs *config.RepoSet) error {
+	if rs.Name == "" {
+		return errors.New("name should be set")
+	}
+
+	if rs.SourceKind() == internal.SourceSearch {
+		if len(rs.Query) == 0 {
+			return errors.New("query should be set")
+		}
+
+		for _, query := range rs.Query {
+			if strings.TrimSpace(query) == "" {
+				return errors.New("query should not be empty")
+			}
+		}
+	}
+
+	if rs.Source != nil && rs.Source.Kind != internal.SourceSearch {
+		if err := rs.Source.Validate(); err != nil {
+			return fmt.Errorf("source: %w", err)
+		}
+
+		if rs.Source.Path != "" && !filepath.IsAbs(rs.Source.Path) {
+			return fmt.Errorf("source.path %q should be an absolute path", rs.Source.Path)
+		}
+	}
+
+	if !filepath.IsAbs(rs.ReposDir) {
+		return fmt.Errorf("repos_dir %q should be an absolute path", rs.ReposDir)
+	}
+
+	switch rs.ProviderName() {
+	case config.ProviderGitHub, config.ProviderGitLab:
+	case config.ProviderGitea:
+		if rs.Host == "" {
+			return errors.New("host should be set for the 'gitea' provider")
+		}
+	case config.ProviderFile:
+		if kind := rs.SourceKind(); kind != internal.SourceDir && kind != internal.SourceList {
+			return fmt.Errorf("source %q is not supported by the 'file' provider, should be 'dir' or 'list'", kind)
+		}
+	default:
+		return fmt.Errorf("provider %q should be 'github', 'gitlab', 'gitea' or 'file'", rs.Provider)
+	}
+
+	if strings.Contains(rs.Host, "/") {
+		return fmt.Errorf("host %q should be a host name, i.e: github.example.com", rs.Host)
+	}
+
+	if rs.APIURL != "" {
+		if u, err := url.Parse(rs.APIURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("api_url %q should be an absolute URL", rs.APIURL)
+		}
+	}
+
+	if store := rs.StoreName(); store != config.StoreJSON && store != config.StoreSQLite {
+		return fmt.Errorf("store %q should be 'json' or 'sqlite'", rs.Store)
+	}
+
+	if _, err := fsstore.ParseLayout(rs.Layout); err != nil {
+		return fmt.Errorf("layout: %w", err)
+	}
+
+	if _, err := fsstore.ParseCloneProtocol(rs.CloneProtocol); err != nil {
+		return fmt.Errorf("clone_protocol: %w", err)
+	}
+
+	if _, err := internal.ParseRemovePolicy(rs.RemovePolicy); err != nil {
+		return fmt.Errorf("remove_policy: %w", err)
+	}
+
+	if _, err := filter.New(rs.Filter.All()); err != nil {
+		return fmt.Errorf("filter: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package command
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/config"
+	"github.com/fatih/starhook/internal/jsonstore"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestUpdateRepoSet_move(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	rs := newTestRepoSet(c, &config.FilterRules{})
+	captureLog(c)
+
+	_, err := jsonstore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+	c.Assert(err, qt.IsNil)
+
+	newDir := filepath.Join(c.Mkdir(), "repos")
+	err = updateRepoSet(ctx, rs.Name, true, func(rs *config.RepoSet) error {
+		rs.ReposDir = newDir
+		rs.Query = internal.Queries{"org:acme"}
+		return nil
+	})
+	c.Assert(err, qt.IsNil)
+
+	_, err = os.Stat(rs.ReposDir)
+	c.Assert(os.IsNotExist(err), qt.IsTrue, qt.Commentf("old directory should be moved"))
+
+	_, err = jsonstore.NewMetadataStore(newDir, "org:acme")
+	c.Assert(err, qt.IsNil)
+
+	cfg, err := config.Load()
+	c.Assert(err, qt.IsNil)
+	got, err := cfg.FindRepoSet(rs.Name)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.ReposDir, qt.Equals, newDir)
+}
+
+func TestUpdateRepoSet_locked(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	rs := newTestRepoSet(c, &config.FilterRules{})
+	captureLog(c)
+
+	_, err := jsonstore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+	c.Assert(err, qt.IsNil)
+
+	lock, err := lockRepoSet(rs)
+	c.Assert(err, qt.IsNil)
+	defer lock.Release()
+
+	newDir := filepath.Join(c.Mkdir(), "repos")
+	err = updateRepoSet(ctx, rs.Name, true, func(rs *config.RepoSet) error {
+		rs.ReposDir = newDir
+		rs.Query = internal.Queries{"org:acme"}
+		return nil
+	})
+	c.Assert(err, qt.ErrorMatches, `reposet "oss" is in use by another starhook process.*`)
+
+	// neither the store nor the directory are changed
+	_, err = jsonstore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+	c.Assert(err, qt.IsNil)
+	_, err = os.Stat(newDir)
+	c.Assert(os.IsNotExist(err), qt.IsTrue)
+}
+
+func TestUpdateRepoSet_saveFails(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	rs := newTestRepoSet(c, &config.FilterRules{})
+	captureLog(c)
+
+	_, err := jsonstore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+	c.Assert(err, qt.IsNil)
+
+	newDir := filepath.Join(c.Mkdir(), "repos")
+	err = updateRepoSet(ctx, rs.Name, true, func(rs *config.RepoSet) error {
+		rs.ReposDir = newDir
+		rs.Query = internal.Queries{"org:acme"}
+
+		// the config directory can't be created inside a file
+		file := filepath.Join(c.Mkdir(), "file")
+		c.Assert(os.WriteFile(file, nil, 0o600), qt.IsNil)
+		c.Setenv("HOME", file)
+		c.Setenv("XDG_CONFIG_HOME", file)
+		return nil
+	})
+	c.Assert(err, qt.Not(qt.IsNil))
+
+	// the directory is moved back and the store has its old query
+	_, err = os.Stat(newDir)
+	c.Assert(os.IsNotExist(err), qt.IsTrue)
+	_, err = jsonstore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+	c.Assert(err, qt.IsNil)
+}
@@ -0,0 +1,124 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/config"
+	"github.com/fatih/starhook/internal/jsonstore"
+	"github.com/fatih/starhook/internal/sqlitestore"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+// storeCmd creates a new ffcli.Command for the store subcommand.
+func storeCmd(rootConfig *RootConfig) *ffcli.Command {
+	fs := flag.NewFlagSet("starhook store", flag.ExitOnError)
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "store",
+		ShortUsage: "starhook store <subcommand> [flags]",
+		ShortHelp:  "Manage the metadata store of the repositories",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			return flag.ErrHelp
+		},
+		Subcommands: []*ffcli.Command{
+			storeMigrateCmd(rootConfig),
+		},
+	}
+}
+
+func storeMigrateCmd(rootConfig *RootConfig) *ffcli.Command {
+	var to string
+
+	fs := flag.NewFlagSet("starhook store migrate", flag.ExitOnError)
+	fs.StringVar(&to, "to", config.StoreSQLite, "store to migrate to, only 'sqlite' is supported")
+	rootConfig.RegisterFlags(fs)
+
+	return &ffcli.Command{
+		Name:       "migrate",
+		ShortUsage: "starhook store migrate [flags]",
+		ShortHelp:  "Migrate the metadata store of the selected reposet",
+		LongHelp: "Migrate the metadata store of the selected reposet from JSON to SQLite. " +
+			"The JSON file is kept, it can be removed once the migration succeeded.",
+		FlagSet: fs,
+		Exec: func(ctx context.Context, _ []string) error {
+			if to != config.StoreSQLite {
+				return fmt.Errorf("--to %q is not supported, should be %q", to, config.StoreSQLite)
+			}
+
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+
+			rs, err := cfg.SelectedRepoSet()
+			if err != nil {
+				return err
+			}
+
+			lock, err := lockRepoSet(rs)
+			if err != nil {
+				return err
+			}
+			defer lock.Release()
+
+			if rs.StoreName() == to {
+				return fmt.Errorf("reposet %q already uses the %q store", rs.Name, to)
+			}
+
+			dbPath := filepath.Join(rs.ReposDir, sqlitestore.DBFile)
+			if _, err := os.Stat(dbPath); err == nil {
+				return fmt.Errorf("%q already exists, remove it to migrate again", dbPath)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+
+			from, err := jsonstore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+			if err != nil {
+				return err
+			}
+
+			repos, err := from.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+			if err != nil {
+				return err
+			}
+
+			nextID, err := from.NextID(ctx)
+			if err != nil {
+				return err
+			}
+
+			store, err := sqlitestore.NewMetadataStore(rs.ReposDir, rs.SourceQuery()...)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+
+			if err := store.ImportRepos(ctx, repos, nextID); err != nil {
+				// don't leave a partial database behind
+				store.Close()
+				for _, suffix := range []string{"", "-wal", "-shm"} {
+					os.Remove(dbPath + suffix)
+				}
+				return err
+			}
+
+			rs.Store = to
+			if err := cfg.Save(); err != nil {
+				return err
+			}
+
+			log.Printf("==> migrated %d repositories to %q\n", len(repos), dbPath)
+			return nil
+		},
+	}
+}
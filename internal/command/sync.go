@@ -2,22 +2,23 @@ package command
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"os"
-	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/fatih/starhook/internal"
 	"github.com/fatih/starhook/internal/config"
+	"github.com/fatih/starhook/internal/filter"
 	"github.com/fatih/starhook/internal/fsstore"
-	"github.com/fatih/starhook/internal/gh"
-	"github.com/fatih/starhook/internal/jsonstore"
 	"github.com/fatih/starhook/internal/starhook"
 
 	"github.com/dustin/go-humanize"
-	"github.com/google/go-github/v39/github"
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
 
@@ -28,6 +29,11 @@ type Sync struct {
 
 	dryRun bool
 	force  bool
+
+	all         bool
+	reposets    string
+	tags        string
+	concurrency int
 }
 
 func syncCmd(rootConfig *RootConfig) *ffcli.Command {
@@ -38,6 +44,10 @@ func syncCmd(rootConfig *RootConfig) *ffcli.Command {
 	fs := flag.NewFlagSet("starhook sync", flag.ExitOnError)
 	fs.BoolVar(&cfg.dryRun, "dry-run", false, "dry-run the given action")
 	fs.BoolVar(&cfg.force, "force", false, "override existing repository directory")
+	fs.BoolVar(&cfg.all, "all", false, "sync all reposets")
+	fs.StringVar(&cfg.reposets, "reposet", "", "comma separated names of the reposets to sync (default: selected reposet)")
+	fs.StringVar(&cfg.tags, "tag", "", "comma separated tags, sync the reposets with any of the tags")
+	fs.IntVar(&cfg.concurrency, "concurrency", 10, "maximum number of repositories synced at once, across all reposets")
 
 	rootConfig.RegisterFlags(fs)
 
@@ -45,11 +55,29 @@ func syncCmd(rootConfig *RootConfig) *ffcli.Command {
 		Name:       "sync",
 		ShortUsage: "starhook sync [flags] [<prefix>]",
 		ShortHelp:  "Sync available repositories",
+		LongHelp:   "Sync the selected reposet, or multiple reposets at once with --all, --reposet or --tag. The reposets are synced concurrently, they share the API clients and the --concurrency limit.",
 		FlagSet:    fs,
 		Exec:       cfg.Exec,
 	}
 }
 
+// repoSetSync is the outcome of the sync of a single reposet.
+type repoSetSync struct {
+	name    string
+	plan    *starhook.SyncRepos // nil if the sync failed before
+	results starhook.Results
+	err     error // the reposet couldn't be synced
+}
+
+// pending returns the number of changes found by the sync.
+func (s *repoSetSync) pending() int {
+	if s.plan == nil {
+		return 0
+	}
+	return len(s.plan.Clone) + len(s.plan.Update) + len(s.plan.Delete) +
+		len(s.plan.Rename) + len(s.plan.Branch)
+}
+
 // Exec function for this command.
 func (c *Sync) Exec(ctx context.Context, _ []string) error {
 	log.Println("[DEBUG] loading the configuration")
@@ -59,54 +87,175 @@ func (c *Sync) Exec(ctx context.Context, _ []string) error {
 		return err
 	}
 
-	rs, err := cfg.SelectedRepoSet()
+	sets, err := c.repoSets(cfg)
 	if err != nil {
 		return err
 	}
 
-	ring, err := openKeyring()
+	if c.concurrency < 1 {
+		return fmt.Errorf("--concurrency %d should be at least 1", c.concurrency)
+	}
+
+	providers := newProviderCache()
+	defer func() {
+		if calls, ok := providers.Calls(); ok {
+			log.Printf("api calls: %d\n", calls)
+		}
+	}()
+
+	workers := starhook.NewWorkers(c.concurrency)
+
+	if len(sets) == 1 {
+		res := c.syncRepoSet(ctx, sets[0], providers, workers, &syncLogger{})
+		if res.err != nil {
+			return res.err
+		}
+
+		if c.dryRun && res.pending() != 0 {
+			log.Println("\nremove the '--dry-run' flag to sync the repositories")
+		}
+		return c.summary(res.results)
+	}
+
+	names := make([]string, 0, len(sets))
+	for _, rs := range sets {
+		names = append(names, rs.Name)
+	}
+	log.Printf("syncing %d reposets: %s\n", len(sets), strings.Join(names, ", "))
+
+	syncs := make([]*repoSetSync, len(sets))
+
+	var wg sync.WaitGroup
+	for i, rs := range sets {
+		i, rs := i, rs
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			syncs[i] = c.syncRepoSet(ctx, rs, providers, workers, &syncLogger{prefix: rs.Name + ": "})
+		}()
+	}
+	wg.Wait()
+
+	pending := 0
+	for _, s := range syncs {
+		pending += s.pending()
+	}
+
+	if c.dryRun && pending != 0 {
+		log.Println("\nremove the '--dry-run' flag to sync the repositories")
+	}
+
+	return c.groupedSummary(syncs)
+}
+
+// repoSets returns the reposets to sync, the selected reposet by default.
+func (c *Sync) repoSets(cfg *config.Config) ([]*config.RepoSet, error) {
+	names, tags := splitList(c.reposets), splitList(c.tags)
+
+	if c.all {
+		if len(names) != 0 || len(tags) != 0 {
+			return nil, errors.New("--all can't be used with --reposet or --tag")
+		}
+		if len(cfg.RepoSets) == 0 {
+			return nil, errors.New("there are no reposets. Use 'starhook config init' to create one")
+		}
+		return cfg.RepoSets, nil
+	}
+
+	if len(names) == 0 && len(tags) == 0 {
+		rs, err := cfg.SelectedRepoSet()
+		if err != nil {
+			return nil, err
+		}
+		return []*config.RepoSet{rs}, nil
+	}
+
+	return cfg.MatchRepoSets(names, tags)
+}
+
+// syncRepoSet syncs a single reposet. The providers and workers are shared
+// with the other reposets synced at the same time.
+func (c *Sync) syncRepoSet(ctx context.Context, rs *config.RepoSet, providers *providerCache, workers starhook.Workers, l *syncLogger) *repoSetSync {
+	res := &repoSetSync{name: rs.Name}
+
+	token, err := loadRepoSetToken(rs)
 	if err != nil {
-		return err
+		res.err = err
+		return res
 	}
 
-	i, err := ring.Get(keyringKey)
+	removePolicy, err := internal.ParseRemovePolicy(rs.RemovePolicy)
 	if err != nil {
-		return err
+		res.err = err
+		return res
 	}
 
-	log.Printf("[DEBUG] selected reposet: %s query: %s filters: %s\n", rs.Name, rs.Query, rs.Filter)
-	ghClient := gh.NewClient(ctx, string(i.Data))
+	repoFilter, err := filter.New(rs.Filter.All())
+	if err != nil {
+		res.err = fmt.Errorf("filter: %w", err)
+		return res
+	}
 
-	if err := os.MkdirAll(filepath.Dir(rs.ReposDir), 0o700); err != nil {
-		return err
+	l.Printf("[DEBUG] selected reposet: %s source: %s filters: %d\n", rs.Name, rs.SourceQuery(), len(rs.Filter.All()))
+	provider, err := providers.Provider(ctx, rs, token)
+	if err != nil {
+		res.err = err
+		return res
 	}
 
-	log.Printf("[DEBUG] using repo dir: %s\n", rs.ReposDir)
-	store, err := jsonstore.NewMetadataStore(rs.ReposDir, rs.Query)
+	// the lock is held until the sync is finished, another sync of the
+	// same reposet fails immediately.
+	lock, err := lockRepoSet(rs)
 	if err != nil {
-		return err
+		res.err = err
+		return res
 	}
+	defer lock.Release()
 
-	fsStore, err := fsstore.NewRepositoryStore(rs.ReposDir)
+	l.Printf("[DEBUG] using repo dir: %s\n", rs.ReposDir)
+	store, fsStore, err := openStores(rs, provider, token)
 	if err != nil {
-		return err
+		res.err = err
+		return res
+	}
+	if closer, ok := store.(io.Closer); ok {
+		defer closer.Close()
 	}
 
-	svc := starhook.NewService(ghClient, store, fsStore)
+	svc := starhook.NewService(provider, store, fsStore)
+	svc.SetWorkers(workers)
 
-	log.Println("querying for latest repositories ...")
-	ghRepos, err := ghClient.FetchRepos(ctx, rs.Query)
+	l.Printf("querying for latest repositories ...")
+	remoteRepos, err := provider.ListRepos(ctx, rs.RepoSource())
 	if err != nil {
-		return err
+		res.err = err
+		return res
+	}
+
+	if err := saveFetchedRepos(rs, remoteRepos); err != nil {
+		l.Printf("[WARN] couldn't save the fetched repositories: %s", err)
 	}
 
-	log.Printf("[DEBUG] before filtering %d repos from GitHub\n", len(ghRepos))
-	fetchedRepos := filterRepos(ghRepos, rs.Filter)
-	log.Printf("[DEBUG] after filtering %d repos from GitHub\n", len(fetchedRepos))
+	l.Printf("[DEBUG] before filtering %d repos from %s\n", len(remoteRepos), rs.ProviderHost())
+	fetchedRepos := repoFilter.Apply(remoteRepos)
+	l.Printf("[DEBUG] after filtering %d repos from %s\n", len(fetchedRepos), rs.ProviderHost())
 
 	currentRepos, err := svc.ListRepos(ctx)
 	if err != nil {
-		return err
+		res.err = err
+		return res
+	}
+
+	if err := fsStore.MigrateLayout(ctx, currentRepos); err != nil {
+		res.err = err
+		return res
+	}
+
+	currentRepos, fetchedRepos, err = dropCollisions(fsStore, currentRepos, fetchedRepos)
+	if err != nil {
+		res.err = err
+		return res
 	}
 
 	lastSynced := time.Time{}
@@ -116,118 +265,395 @@ func (c *Sync) Exec(ctx context.Context, _ []string) error {
 		}
 	}
 
-	log.Printf("last synced: %s\n", humanize.Time(lastSynced))
+	l.Printf("last synced: %s\n", humanize.Time(lastSynced))
 
-	log.Println("[DEBUG] syncing remote repos to local directory")
+	l.Printf("[DEBUG] syncing remote repos to local directory")
 	syncRepos, err := svc.SyncRepos(ctx, currentRepos, fetchedRepos)
 	if err != nil {
-		return err
+		res.err = err
+		return res
 	}
 
-	total := len(syncRepos.Clone) + len(syncRepos.Update) + len(syncRepos.Delete)
-	if total == 0 {
-		log.Printf("everything is up-to-date")
-		return nil
+	res.plan = syncRepos
+	res.results = syncRepos.Results
+
+	if res.pending() == 0 {
+		if len(res.results.Failed()) == 0 {
+			l.Printf("everything is up-to-date")
+		}
+		return res
 	}
 
 	for _, r := range syncRepos.Clone {
-		log.Printf("[DEBUG]  cloning: %q", r.Nwo)
+		l.Printf("[DEBUG]  cloning: %q", r.Nwo)
 	}
 	for _, r := range syncRepos.Update {
-		log.Printf("[DEBUG] updating: %q", r.Nwo)
+		l.Printf("[DEBUG] updating: %q", r.Nwo)
 	}
 	for _, r := range syncRepos.Delete {
-		log.Printf("[DEBUG] Deleting: %q", r.Nwo)
+		l.Printf("[DEBUG] Deleting: %q", r.Nwo)
+	}
+	for _, r := range syncRepos.Rename {
+		l.Printf("[DEBUG] renaming: %q -> %q", r.From.Nwo, r.To.Nwo)
+	}
+	for _, r := range syncRepos.Branch {
+		l.Printf("[DEBUG] switching branch: %q (%s -> %s)", r.Repo.Nwo, r.From, r.To)
 	}
 
-	log.Printf("updates found:  \n")
-	log.Printf("  clone  : %3d\n", len(syncRepos.Clone))
-	log.Printf("  update : %3d\n", len(syncRepos.Update))
-	log.Printf("  delete : %3d\n", len(syncRepos.Delete))
+	l.Printf("updates found:  \n")
+	l.Printf("  clone  : %3d\n", len(syncRepos.Clone))
+	l.Printf("  update : %3d\n", len(syncRepos.Update))
+	l.Printf("  delete : %3d (policy: %s)\n", len(syncRepos.Delete), removePolicy)
+	l.Printf("  rename : %3d\n", len(syncRepos.Rename))
+	l.Printf("  branch : %3d\n", len(syncRepos.Branch))
 
 	if c.dryRun {
-		log.Println("\nremove the '--dry-run' flag to sync the repositories")
-		return nil
+		return res
 	}
 
+	// every phase runs to completion, even if some repositories fail. A
+	// repository that couldn't be renamed or switched to its new branch is
+	// excluded from the following phases. Deletes run first, a repository
+	// might be renamed to the path of a removed one.
 	start := time.Now()
-	if err := svc.CloneRepos(ctx, syncRepos.Clone); err != nil {
-		return err
-	}
-	log.Printf("cloned: %d repositories (elapsed time: %s)\n",
-		len(syncRepos.Clone), time.Since(start).String())
+	deleted := svc.DeleteRepos(ctx, internal.DeleteOptions{Policy: removePolicy}, syncRepos.Delete)
+	res.results = append(res.results, deleted...)
+	l.Printf("deleted: %d repositories (elapsed time: %s)\n",
+		deleted.Count(starhook.StatusSuccess), time.Since(start).String())
 
 	start = time.Now()
-	if err := svc.UpdateRepos(ctx, syncRepos.Update); err != nil {
-		return err
-	}
-	log.Printf("updated: %d repositories (elapsed time: %s)\n",
-		len(syncRepos.Update), time.Since(start).String())
+	renamed := svc.RenameRepos(ctx, syncRepos.Rename)
+	res.results = append(res.results, renamed...)
+	l.Printf("renamed: %d repositories (elapsed time: %s)\n",
+		renamed.Count(starhook.StatusSuccess), time.Since(start).String())
 
 	start = time.Now()
-	if err := svc.DeleteRepos(ctx, syncRepos.Delete); err != nil {
-		return err
+	switched := svc.SwitchBranches(ctx, syncRepos.Branch)
+	res.results = append(res.results, switched...)
+	l.Printf("switched: %d default branches (elapsed time: %s)\n",
+		switched.Count(starhook.StatusSuccess), time.Since(start).String())
+
+	start = time.Now()
+	cloned := svc.CloneRepos(ctx, withoutFailed(syncRepos.Clone, res.results))
+	res.results = append(res.results, cloned...)
+	l.Printf("cloned: %d repositories (elapsed time: %s)\n",
+		cloned.Count(starhook.StatusSuccess), time.Since(start).String())
+
+	start = time.Now()
+	updated := svc.UpdateRepos(ctx, withoutFailed(syncRepos.Update, res.results))
+	res.results = append(res.results, updated...)
+	l.Printf("updated: %d repositories (elapsed time: %s)\n",
+		updated.Count(starhook.StatusSuccess), time.Since(start).String())
+
+	for _, r := range renamed {
+		if r.Status == starhook.StatusSuccess {
+			l.Printf("  %q is renamed\n", r.Repo.Nwo)
+		}
 	}
-	log.Printf("deleted: %d repositories (elapsed time: %s)\n",
-		len(syncRepos.Delete), time.Since(start).String())
 
-	for _, repo := range syncRepos.Update {
-		log.Printf("  %q is updated (last updated: %s)\n",
-			repo.Name, humanize.Time(repo.SyncedAt))
+	for _, change := range syncRepos.Branch {
+		l.Printf("  %q default branch changed from %q to %q\n",
+			change.Repo.Nwo, change.From, change.To)
+	}
+
+	for _, r := range updated {
+		if r.Status == starhook.StatusSuccess {
+			l.Printf("  %q is updated (last updated: %s)\n",
+				r.Repo.Name, humanize.Time(r.Repo.SyncedAt))
+		}
+	}
+
+	return res
+}
+
+// summary prints a summary table of the given results, listing the
+// repositories that were skipped or failed. It returns an error if any of
+// the results failed.
+func (c *Sync) summary(results starhook.Results) error {
+	const padding = 3
+	w := tabwriter.NewWriter(c.rootConfig.out, 0, 0, padding, ' ', 0)
+
+	failed := results.Failed()
+	if len(results) != results.Count(starhook.StatusSuccess) {
+		fmt.Fprintln(w, "\nREPOSITORY\tOPERATION\tSTATUS\tREASON")
+		for _, res := range results {
+			if res.Status == starhook.StatusSuccess {
+				continue
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", res.Repo.Nwo, res.Op, res.Status, res.Message())
+		}
+	}
+
+	fmt.Fprintf(w, "\nsucceeded: %d, skipped: %d, failed: %d\n",
+		results.Count(starhook.StatusSuccess), results.Count(starhook.StatusSkipped), len(failed))
+	w.Flush()
+
+	if len(failed) != 0 {
+		return fmt.Errorf("sync failed for %d repositories, they are retried first on the next sync", len(failed))
 	}
 
 	return nil
 }
 
-func filterRepos(rps []*github.Repository, rules *config.FilterRules) []*internal.Repository {
-	repos := make([]*internal.Repository, 0, len(rps))
+// groupedSummary prints a summary table of the given reposets, followed by
+// the repositories that were skipped or failed. It returns an error if any
+// reposet or repository failed.
+func (c *Sync) groupedSummary(syncs []*repoSetSync) error {
+	const padding = 3
+	w := tabwriter.NewWriter(c.rootConfig.out, 0, 0, padding, ' ', 0)
+
+	// a dry-run shows the changes that would be applied
+	count := func(s *repoSetSync, op starhook.Op) int {
+		if c.dryRun {
+			if s.plan == nil {
+				return 0
+			}
+
+			switch op {
+			case starhook.OpRename:
+				return len(s.plan.Rename)
+			case starhook.OpBranch:
+				return len(s.plan.Branch)
+			case starhook.OpClone:
+				return len(s.plan.Clone)
+			case starhook.OpUpdate:
+				return len(s.plan.Update)
+			case starhook.OpDelete:
+				return len(s.plan.Delete)
+			}
+		}
+
+		n := 0
+		for _, res := range s.results {
+			if res.Op == op && res.Status == starhook.StatusSuccess {
+				n++
+			}
+		}
+		return n
+	}
+
+	failedSets, failedRepos, skippedRepos := 0, 0, 0
+
+	fmt.Fprintln(w, "\nREPOSET\tCLONED\tUPDATED\tDELETED\tRENAMED\tBRANCH\tSKIPPED\tFAILED\tERROR")
+	for _, s := range syncs {
+		errMsg := ""
+		if s.err != nil {
+			errMsg = s.err.Error()
+			failedSets++
+		}
+		failedRepos += len(s.results.Failed())
+		skippedRepos += s.results.Count(starhook.StatusSkipped)
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n", s.name,
+			count(s, starhook.OpClone), count(s, starhook.OpUpdate), count(s, starhook.OpDelete),
+			count(s, starhook.OpRename), count(s, starhook.OpBranch),
+			s.results.Count(starhook.StatusSkipped), len(s.results.Failed()), errMsg)
+	}
+
+	if failedRepos != 0 || skippedRepos != 0 {
+		fmt.Fprintln(w, "\nREPOSET\tREPOSITORY\tOPERATION\tSTATUS\tREASON")
+		for _, s := range syncs {
+			for _, res := range s.results {
+				if res.Status == starhook.StatusSuccess {
+					continue
+				}
+
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.name, res.Repo.Nwo, res.Op, res.Status, res.Message())
+			}
+		}
+	}
+	w.Flush()
+
+	switch {
+	case failedSets != 0:
+		return fmt.Errorf("sync failed for %d of %d reposets", failedSets, len(syncs))
+	case failedRepos != 0:
+		return fmt.Errorf("sync failed for %d repositories, they are retried first on the next sync", failedRepos)
+	}
+
+	return nil
+}
+
+// syncLogger logs the progress of a reposet's sync. The messages are
+// prefixed, so the reposets synced at the same time can be told apart.
+type syncLogger struct {
+	prefix string
+}
+
+func (l *syncLogger) Printf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+
+	// the level has to stay in front to be filtered
+	level := ""
+	if strings.HasPrefix(msg, "[") {
+		if i := strings.Index(msg, "] "); i != -1 {
+			level, msg = msg[:i+2], msg[i+2:]
+		}
+	}
+
+	log.Print(level + l.prefix + msg)
+}
+
+// providerCache shares the providers between reposets with the same
+// provider, host and token, so they share the API client and its rate
+// limits.
+type providerCache struct {
+	mu        sync.Mutex
+	providers map[string]internal.Provider
+}
+
+func newProviderCache() *providerCache {
+	return &providerCache{providers: make(map[string]internal.Provider)}
+}
+
+// Provider returns the provider of the given reposet, it's created on the
+// first call.
+func (p *providerCache) Provider(ctx context.Context, rs *config.RepoSet, token string) (internal.Provider, error) {
+	// the file provider is bound to the reposet's source
+	if rs.ProviderName() == config.ProviderFile {
+		return newProvider(ctx, rs, token)
+	}
+
+	key := strings.Join([]string{rs.ProviderName(), rs.ProviderHost(), rs.ProviderAPIURL(), token}, "\x00")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if provider, ok := p.providers[key]; ok {
+		return provider, nil
+	}
 
-	includedRepos := make(map[string]bool)
-	excludedRepos := make(map[string]bool)
-	if rules != nil {
-		for _, nwo := range rules.Include {
-			includedRepos[nwo] = true
+	provider, err := newProvider(ctx, rs, token)
+	if err != nil {
+		return nil, err
+	}
+
+	p.providers[key] = provider
+	return provider, nil
+}
+
+// Calls returns the number of API requests made by all providers. It
+// returns false if none of the providers count their requests.
+func (p *providerCache) Calls() (int64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		calls int64
+		ok    bool
+	)
+	for _, provider := range p.providers {
+		if c, isCounter := provider.(interface{ Calls() int64 }); isCounter {
+			calls += c.Calls()
+			ok = true
 		}
+	}
+
+	return calls, ok
+}
 
-		for _, nwo := range rules.Exclude {
-			excludedRepos[nwo] = true
+// splitList splits the given comma separated list, empty values are
+// skipped.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
 		}
 	}
+	return out
+}
 
-	log.Printf("[DEBUG] rules: %+v included repos %+v, excluded repos %+v\n", rules, includedRepos, excludedRepos)
+// withoutFailed returns the repositories that didn't fail in any of the
+// given results.
+func withoutFailed(repos []*internal.Repository, results starhook.Results) []*internal.Repository {
+	failed := make(map[int64]bool)
+	for _, res := range results.Failed() {
+		failed[res.Repo.ID] = true
+	}
 
-	var include func(string) bool
-	if rules == nil {
-		// if there are no rules, it means that we include all repositories
-		include = func(nwo string) bool { return true }
+	out := make([]*internal.Repository, 0, len(repos))
+	for _, repo := range repos {
+		if !failed[repo.ID] {
+			out = append(out, repo)
+		}
 	}
 
-	if len(excludedRepos) != 0 {
-		// we include all repos, expect the ones in the excluded list
-		include = func(nwo string) bool { return !excludedRepos[nwo] }
+	return out
+}
+
+// dropCollisions removes repositories that would be placed into the same
+// directory as another repository with the layout of the given store. Local
+// repositories take precedence over fetched ones. Colliding repositories are
+// removed from both lists, so they are neither cloned nor deleted.
+func dropCollisions(fsStore *fsstore.RepositoryStore, local, fetched []*internal.Repository) ([]*internal.Repository, []*internal.Repository, error) {
+	dirs := make(map[string]*internal.Repository)
+	dropped := make(map[string]bool)
+
+	// renamed repositories might be placed on the same directory as before
+	sameRepo := func(a, b *internal.Repository) bool {
+		if a.RemoteID != 0 && a.RemoteID == b.RemoteID {
+			return true
+		}
+		return a.Nwo == b.Nwo
 	}
 
-	if len(includedRepos) != 0 {
-		// if included repos is set, it takes precedent over exclusion. In this
-		// case, we only include repos that are in the list and exclude
-		// everything else.
-		include = func(nwo string) bool { return includedRepos[nwo] }
+	// local repositories that aren't fetched anymore are deleted before
+	// the renames, their directories can be reused
+	fetchedIDs := make(map[int64]bool, len(fetched))
+	fetchedNwos := make(map[string]bool, len(fetched))
+	for _, repo := range fetched {
+		if repo.RemoteID != 0 {
+			fetchedIDs[repo.RemoteID] = true
+		}
+		fetchedNwos[repo.Nwo] = true
+	}
+
+	removed := make(map[*internal.Repository]bool)
+	for _, repo := range local {
+		if !fetchedIDs[repo.RemoteID] && !fetchedNwos[repo.Nwo] {
+			removed[repo] = true
+		}
 	}
 
-	for _, repo := range rps {
-		owner := repo.GetOwner().GetLogin()
-		name := repo.GetName()
-		nwo := fmt.Sprintf("%s/%s", owner, name)
+	// a new slice, appending to local might overwrite the caller's repos
+	all := make([]*internal.Repository, 0, len(local)+len(fetched))
+	all = append(all, local...)
+	all = append(all, fetched...)
+
+	for _, repo := range all {
+		if removed[repo] {
+			continue
+		}
+
+		dir, err := fsStore.RepoDir(repo)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		other, ok := dirs[dir]
+		if !ok {
+			dirs[dir] = repo
+			continue
+		}
+
+		if !sameRepo(other, repo) && !dropped[repo.Nwo] {
+			log.Printf("[WARN] skipping %q, it collides with %q on %q. Use a different layout, such as %q",
+				repo.Nwo, other.Nwo, dir, fsstore.LayoutOwnerName)
+			dropped[repo.Nwo] = true
+		}
+	}
 
-		if include(nwo) {
-			repos = append(repos, &internal.Repository{
-				Nwo:    nwo,
-				Owner:  owner,
-				Name:   name,
-				Branch: repo.GetDefaultBranch(),
-			})
+	keep := func(repos []*internal.Repository) []*internal.Repository {
+		out := make([]*internal.Repository, 0, len(repos))
+		for _, repo := range repos {
+			if !dropped[repo.Nwo] {
+				out = append(out, repo)
+			}
 		}
+		return out
 	}
 
-	return repos
+	return keep(local), keep(fetched), nil
 }
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/fsstore"
+	"github.com/fatih/starhook/internal/starhook"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestDropCollisions(t *testing.T) {
+	c := qt.New(t)
+
+	fsStore, err := fsstore.NewRepositoryStore(c.Mkdir(), fsstore.Options{Layout: fsstore.LayoutFlat})
+	c.Assert(err, qt.IsNil)
+
+	local := []*internal.Repository{
+		{ID: 1, RemoteID: 100, Nwo: "acme/utils", Owner: "acme", Name: "utils"},
+		{ID: 2, RemoteID: 200, Nwo: "contoso/tools", Owner: "contoso", Name: "tools"},
+		{ID: 3, RemoteID: 300, Nwo: "acme/api", Owner: "acme", Name: "api"},
+	}
+
+	fetched := []*internal.Repository{
+		// removed acme/utils, renamed onto its directory
+		{RemoteID: 200, Nwo: "contoso/utils", Owner: "contoso", Name: "utils"},
+		{RemoteID: 300, Nwo: "acme/api", Owner: "acme", Name: "api"},
+		// collides with the local acme/api
+		{RemoteID: 400, Nwo: "contoso/api", Owner: "contoso", Name: "api"},
+	}
+
+	// the spare capacity of local isn't written to
+	spare := &internal.Repository{Nwo: "spare/repo"}
+	local = append(local, spare)[:len(local)]
+
+	gotLocal, gotFetched, err := dropCollisions(fsStore, local, fetched)
+	c.Assert(err, qt.IsNil)
+	c.Assert(local[:cap(local)][len(local)], qt.Equals, spare)
+
+	nwos := func(repos []*internal.Repository) []string {
+		var out []string
+		for _, repo := range repos {
+			out = append(out, repo.Nwo)
+		}
+		return out
+	}
+
+	c.Assert(nwos(gotLocal), qt.DeepEquals, []string{"acme/utils", "contoso/tools", "acme/api"})
+	c.Assert(nwos(gotFetched), qt.DeepEquals, []string{"contoso/utils", "acme/api"})
+}
+
+func TestSync_summary(t *testing.T) {
+	vimgo := &internal.Repository{Nwo: "fatih/vim-go"}
+	color := &internal.Repository{Nwo: "fatih/color"}
+
+	tests := []struct {
+		name    string
+		results starhook.Results
+		want    string
+		wantErr string
+	}{
+		{
+			name: "success",
+			results: starhook.Results{
+				{Repo: vimgo, Op: starhook.OpUpdate, Status: starhook.StatusSuccess},
+			},
+			want: "\nsucceeded: 1, skipped: 0, failed: 0\n",
+		},
+		{
+			name: "skipped",
+			results: starhook.Results{
+				{Repo: vimgo, Op: starhook.OpUpdate, Status: starhook.StatusSuccess},
+				{Repo: color, Op: starhook.OpFetch, Status: starhook.StatusSkipped, Err: errors.New("empty repository")},
+			},
+			want: "\n" +
+				"REPOSITORY    OPERATION   STATUS    REASON\n" +
+				"fatih/color   fetch       skipped   empty repository\n" +
+				"\n" +
+				"succeeded: 1, skipped: 1, failed: 0\n",
+		},
+		{
+			name: "failed",
+			results: starhook.Results{
+				{Repo: color, Op: starhook.OpClone, Status: starhook.StatusFailed, Err: errors.New("boom")},
+			},
+			want: "\n" +
+				"REPOSITORY    OPERATION   STATUS   REASON\n" +
+				"fatih/color   clone       failed   boom\n" +
+				"\n" +
+				"succeeded: 0, skipped: 0, failed: 1\n",
+			wantErr: "sync failed for 1 repositories.*",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			var out bytes.Buffer
+			cmd := &Sync{rootConfig: &RootConfig{out: &out}}
+
+			err := cmd.summary(tt.results)
+			if tt.wantErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.wantErr)
+			} else {
+				c.Assert(err, qt.IsNil)
+			}
+			c.Assert(out.String(), qt.Equals, tt.want)
+		})
+	}
+}
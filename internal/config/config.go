@@ -7,6 +7,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/filter"
 )
 
 const (
@@ -14,6 +17,24 @@ const (
 	configDir  = "starhook"
 )
 
+// DefaultHost is the host of reposets that don't define a host.
+const DefaultHost = "github.com"
+
+// Providers are the code hosting services repositories are synced from.
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+	ProviderGitea  = "gitea"
+	ProviderFile   = "file"
+)
+
+// Stores are the formats the metadata of a reposet's repositories is stored
+// in.
+const (
+	StoreJSON   = "json"
+	StoreSQLite = "sqlite"
+)
+
 // Config defines a physical configuration file on the host.
 type Config struct {
 	// Selected defines the name of the selected config.
@@ -30,25 +51,210 @@ type RepoSet struct {
 	// Name is a logical name to represent this config.
 	Name string `json:"name"`
 
-	// Query defines the GitHub query to fetch the repositories.
-	Query string `json:"query"`
+	// Tags group reposets, so they can be synced together, i.e: "work".
+	Tags []string `json:"tags,omitempty"`
+
+	// Query defines the search queries to fetch the repositories. The
+	// repositories matching any of the queries are fetched. It's only used
+	// if the reposet's source is a search.
+	Query internal.Queries `json:"query"`
+
+	// Source defines where the repositories are fetched from. If it's not
+	// set, the repositories are fetched with Query.
+	Source *internal.Source `json:"source,omitempty"`
+
+	// Provider is the code hosting service of the repositories. It's
+	// either "github" (default), "gitlab", "gitea" or "file" for bare
+	// repositories on the local filesystem.
+	Provider string `json:"provider,omitempty"`
+
+	// Host is the host of the provider, i.e: the host of a GitHub
+	// Enterprise Server instance. Defaults to "github.com" for GitHub and
+	// "gitlab.com" for GitLab, Gitea has no default.
+	Host string `json:"host,omitempty"`
+
+	// APIURL is the URL of the provider's API. It's only needed if the API
+	// isn't served from its default path on the host, i.e:
+	// "https://<host>/api/v3/" for GitHub Enterprise Server.
+	APIURL string `json:"api_url,omitempty"`
 
 	// ReposDir represents the directory to sync and manage repositories
 	ReposDir string `json:"repos_dir"`
 
+	// Store defines how the metadata of the repositories is stored inside
+	// ReposDir. It's either "json" (default) or "sqlite".
+	Store string `json:"store,omitempty"`
+
+	// Layout defines how repositories are placed inside ReposDir. It's
+	// either "flat" (default), "owner/name" or a custom template, such as
+	// "{{.Owner}}-{{.Name}}".
+	Layout string `json:"layout,omitempty"`
+
+	// CloneProtocol defines how repositories are cloned. It's either
+	// "https" (default) or "ssh".
+	CloneProtocol string `json:"clone_protocol,omitempty"`
+
+	// SSHHost is the host used to clone repositories via SSH. It can be an
+	// alias from ~/.ssh/config. Defaults to "github.com".
+	SSHHost string `json:"ssh_host,omitempty"`
+
+	// RemovePolicy defines what happens to local repositories that are no
+	// longer part of the reposet. It's either "archive" (default), "delete"
+	// or "keep".
+	RemovePolicy string `json:"remove_policy,omitempty"`
+
 	// Filter contains a set of filters that apply to this given reposet
 	Filter *FilterRules `json:"filter,omitempty"`
 }
 
+// ProviderName returns the name of the reposet's provider.
+func (rs *RepoSet) ProviderName() string {
+	if rs.Provider == "" {
+		return ProviderGitHub
+	}
+	return rs.Provider
+}
+
+// HasTag reports whether the reposet has the given tag.
+func (rs *RepoSet) HasTag(tag string) bool {
+	for _, t := range rs.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// StoreName returns the name of the reposet's metadata store.
+func (rs *RepoSet) StoreName() string {
+	if rs.Store == "" {
+		return StoreJSON
+	}
+	return rs.Store
+}
+
+// ProviderHost returns the host of the reposet's provider.
+func (rs *RepoSet) ProviderHost() string {
+	if rs.Host != "" {
+		return rs.Host
+	}
+
+	switch rs.ProviderName() {
+	case ProviderGitLab:
+		return "gitlab.com"
+	case ProviderFile:
+		return "localhost"
+	}
+	return DefaultHost
+}
+
+// NeedsToken reports whether the reposet's provider requires an API token.
+func (rs *RepoSet) NeedsToken() bool {
+	return rs.ProviderName() != ProviderFile
+}
+
+// ProviderAPIURL returns the API URL of the reposet's provider. It's empty
+// for github.com and the file provider.
+func (rs *RepoSet) ProviderAPIURL() string {
+	if rs.APIURL != "" {
+		return rs.APIURL
+	}
+
+	switch rs.ProviderName() {
+	case ProviderGitLab:
+		return "https://" + rs.ProviderHost() + "/api/v4/"
+	case ProviderGitea:
+		return "https://" + rs.ProviderHost() + "/api/v1/"
+	case ProviderFile:
+		return ""
+	}
+
+	if rs.ProviderHost() == DefaultHost {
+		return ""
+	}
+
+	return "https://" + rs.ProviderHost() + "/api/v3/"
+}
+
+// RepoSource returns the source of the reposet's repositories.
+func (rs *RepoSet) RepoSource() *internal.Source {
+	if rs.SourceKind() == internal.SourceSearch {
+		return &internal.Source{
+			Kind:  internal.SourceSearch,
+			Query: rs.Query,
+		}
+	}
+	return rs.Source
+}
+
+// SourceKind returns the kind of the reposet's source.
+func (rs *RepoSet) SourceKind() string {
+	if rs.Source == nil || rs.Source.Kind == "" {
+		return internal.SourceSearch
+	}
+	return rs.Source.Kind
+}
+
+// SourceQuery returns the queries that identify the repositories of the
+// reposet. They're the search queries for search sources, and the
+// description of the source for all other kinds.
+func (rs *RepoSet) SourceQuery() internal.Queries {
+	if rs.SourceKind() == internal.SourceSearch {
+		return rs.Query
+	}
+	return internal.Queries{rs.Source.String()}
+}
+
 // FilterRules defines a set of rules to include or exclude repositories based
 // on certain criterias.
 type FilterRules struct {
-	// Include includes the repositories to sync.
+	// Include includes the given repositories, i.e: "fatih/vim-go". If it's
+	// set, all other repositories are excluded.
 	Include []string `json:"include"`
 
-	// Exlude excludes the repositories to sync. If Include is set, Exclude
-	// doesn't have an affect.
+	// Exclude excludes the given repositories. It's evaluated after
+	// Include, hence it takes precedence.
 	Exclude []string `json:"exclude"`
+
+	// Rules are evaluated after Include and Exclude, in order. The last
+	// matching rule wins.
+	Rules []filter.Rule `json:"rules,omitempty"`
+}
+
+// All returns all rules in the order they're evaluated. Include and Exclude
+// are converted to rules matching the exact name with owner.
+func (f *FilterRules) All() []filter.Rule {
+	if f == nil {
+		return nil
+	}
+
+	rules := make([]filter.Rule, 0, len(f.Include)+len(f.Exclude)+len(f.Rules))
+	for _, nwo := range f.Include {
+		rules = append(rules, filter.Rule{Action: filter.Include, Name: nwo})
+	}
+	for _, nwo := range f.Exclude {
+		rules = append(rules, filter.Rule{Action: filter.Exclude, Name: nwo})
+	}
+
+	return append(rules, f.Rules...)
+}
+
+// Remove removes the rule with the given index of All.
+func (f *FilterRules) Remove(i int) error {
+	switch {
+	case i < 0 || i >= len(f.All()):
+		return fmt.Errorf("there is no filter rule #%d", i+1)
+	case i < len(f.Include):
+		f.Include = append(f.Include[:i], f.Include[i+1:]...)
+	case i < len(f.Include)+len(f.Exclude):
+		i -= len(f.Include)
+		f.Exclude = append(f.Exclude[:i], f.Exclude[i+1:]...)
+	default:
+		i -= len(f.Include) + len(f.Exclude)
+		f.Rules = append(f.Rules[:i], f.Rules[i+1:]...)
+	}
+
+	return nil
 }
 
 // New creates a new, empty configuration file. The user should populate the
@@ -116,6 +322,64 @@ func (c *Config) SelectedRepoSet() (*RepoSet, error) {
 	return rs, nil
 }
 
+// FindRepoSet returns the reposet with the given name. If the name is empty,
+// it returns the selected reposet.
+func (c *Config) FindRepoSet(name string) (*RepoSet, error) {
+	if name == "" {
+		return c.SelectedRepoSet()
+	}
+
+	for _, rs := range c.RepoSets {
+		if rs.Name == name {
+			return rs, nil
+		}
+	}
+
+	return nil, fmt.Errorf("repo set with name %q doesn't exists", name)
+}
+
+// MatchRepoSets returns the reposets with any of the given names or tags, in
+// the order of the config. It returns an error if a name or tag doesn't match
+// any reposet.
+func (c *Config) MatchRepoSets(names, tags []string) ([]*RepoSet, error) {
+	matched := make(map[string]bool)
+
+	var out []*RepoSet
+	for _, rs := range c.RepoSets {
+		match := false
+		for _, name := range names {
+			if rs.Name == name {
+				match = true
+				matched["name:"+name] = true
+			}
+		}
+		for _, tag := range tags {
+			if rs.HasTag(tag) {
+				match = true
+				matched["tag:"+tag] = true
+			}
+		}
+
+		if match {
+			out = append(out, rs)
+		}
+	}
+
+	for _, name := range names {
+		if !matched["name:"+name] {
+			return nil, fmt.Errorf("repo set with name %q doesn't exists", name)
+		}
+	}
+
+	for _, tag := range tags {
+		if !matched["tag:"+tag] {
+			return nil, fmt.Errorf("no repo set has the tag %q", tag)
+		}
+	}
+
+	return out, nil
+}
+
 func (c *Config) AddRepoSet(rs *RepoSet, force bool) error {
 	hasRepoSet := false
 
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/filter"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFilterRules_Remove(t *testing.T) {
+	newRules := func() *FilterRules {
+		return &FilterRules{
+			Include: []string{"fatih/vim-go", "fatih/color"},
+			Exclude: []string{"fatih/structs"},
+			Rules:   []filter.Rule{{Action: filter.Exclude, Topic: "deprecated"}, {Action: filter.Include, Language: "Go"}},
+		}
+	}
+
+	vimgo := filter.Rule{Action: filter.Include, Name: "fatih/vim-go"}
+	color := filter.Rule{Action: filter.Include, Name: "fatih/color"}
+	structs := filter.Rule{Action: filter.Exclude, Name: "fatih/structs"}
+	deprecated := filter.Rule{Action: filter.Exclude, Topic: "deprecated"}
+	golang := filter.Rule{Action: filter.Include, Language: "Go"}
+
+	c := qt.New(t)
+	c.Assert(newRules().All(), qt.DeepEquals, []filter.Rule{vimgo, color, structs, deprecated, golang})
+
+	tests := []struct {
+		name    string
+		rules   *FilterRules
+		index   int
+		want    []filter.Rule
+		wantErr string
+	}{
+		{name: "first include", rules: newRules(), index: 0, want: []filter.Rule{color, structs, deprecated, golang}},
+		{name: "last include", rules: newRules(), index: 1, want: []filter.Rule{vimgo, structs, deprecated, golang}},
+		{name: "exclude", rules: newRules(), index: 2, want: []filter.Rule{vimgo, color, deprecated, golang}},
+		{name: "first rule", rules: newRules(), index: 3, want: []filter.Rule{vimgo, color, structs, golang}},
+		{name: "last rule", rules: newRules(), index: 4, want: []filter.Rule{vimgo, color, structs, deprecated}},
+		{name: "out of range", rules: newRules(), index: 5, wantErr: `there is no filter rule #6`},
+		{name: "negative", rules: newRules(), index: -1, wantErr: `there is no filter rule #0`},
+		{name: "only rules", rules: &FilterRules{Rules: []filter.Rule{deprecated, golang}}, index: 0, want: []filter.Rule{golang}},
+		{name: "nil", rules: nil, index: 0, wantErr: `there is no filter rule #1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			err := tt.rules.Remove(tt.index)
+			if tt.wantErr != "" {
+				c.Assert(err, qt.ErrorMatches, tt.wantErr)
+				return
+			}
+
+			c.Assert(err, qt.IsNil)
+			c.Assert(tt.rules.All(), qt.DeepEquals, tt.want)
+		})
+	}
+}
+
+func TestRepoSet_Set(t *testing.T) {
+	c := qt.New(t)
+
+	rs := &RepoSet{Name: "test", Query: internal.Queries{"user:fatih"}, ReposDir: "/tmp/repos"}
+
+	c.Assert(rs.Set("query", "org:github"), qt.IsNil)
+	c.Assert(rs.Query, qt.DeepEquals, internal.Queries{"org:github"})
+
+	c.Assert(rs.Set("query", `["org:github language:go", "topic:a,b"]`), qt.IsNil)
+	c.Assert(rs.Query, qt.DeepEquals, internal.Queries{"org:github language:go", "topic:a,b"})
+
+	query, err := rs.Get("query")
+	c.Assert(err, qt.IsNil)
+	c.Assert(query, qt.Equals, `["org:github language:go","topic:a,b"]`)
+
+	c.Assert(rs.Set("source.kind", "list"), qt.IsNil)
+	c.Assert(rs.Set("source.repos", "fatih/color, fatih/structs"), qt.IsNil)
+	c.Assert(rs.Source, qt.DeepEquals, &internal.Source{
+		Kind:  "list",
+		Repos: []string{"fatih/color", "fatih/structs"},
+	})
+
+	c.Assert(rs.Set("filter.rules", `[{"action": "exclude", "archived": true}]`), qt.IsNil)
+	archived := true
+	c.Assert(rs.Filter.Rules, qt.DeepEquals, []filter.Rule{{Action: filter.Exclude, Archived: &archived}})
+
+	c.Assert(rs.Set("filter.rules", `[`), qt.ErrorMatches, `filter.rules: invalid JSON value: .*`)
+	c.Assert(rs.Set("repos", "fatih/color"), qt.ErrorMatches, `unknown key "repos"`)
+	c.Assert(rs.Set("query.kind", "org"), qt.ErrorMatches, `unknown key "query.kind"`)
+}
+
+func TestRepoSet_Get(t *testing.T) {
+	c := qt.New(t)
+
+	rs := &RepoSet{
+		Name:     "test",
+		ReposDir: "/tmp/repos",
+		Source:   &internal.Source{Kind: "list", Repos: []string{"fatih/color", "fatih/structs"}},
+	}
+
+	for key, want := range map[string]string{
+		"repos_dir":      "/tmp/repos",
+		"source.repos":   "fatih/color,fatih/structs",
+		"source":         `{"kind":"list","repos":["fatih/color","fatih/structs"]}`,
+		"filter.include": "",
+		"filter":         "",
+	} {
+		got, err := rs.Get(key)
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.Equals, want, qt.Commentf("key: %s", key))
+	}
+
+	_, err := rs.Get("filter.unknown")
+	c.Assert(err, qt.ErrorMatches, `unknown key "filter.unknown"`)
+
+	for _, key := range Keys() {
+		_, err := rs.Get(key)
+		c.Assert(err, qt.IsNil, qt.Commentf("key: %s", key))
+	}
+}
+
+func TestRepoSet_Unset(t *testing.T) {
+	c := qt.New(t)
+
+	rs := &RepoSet{
+		Name:   "test",
+		Layout: "owner/name",
+		Source: &internal.Source{Kind: "org", Org: "github"},
+	}
+
+	c.Assert(rs.Unset("layout"), qt.IsNil)
+	c.Assert(rs.Layout, qt.Equals, "")
+
+	c.Assert(rs.Unset("filter.exclude"), qt.IsNil)
+	c.Assert(rs.Filter, qt.IsNil, qt.Commentf("unset filter shouldn't be allocated"))
+
+	c.Assert(rs.Unset("source"), qt.IsNil)
+	c.Assert(rs.Source, qt.IsNil)
+}
+
+func TestConfig_MatchRepoSets(t *testing.T) {
+	c := qt.New(t)
+
+	cfg := &Config{
+		RepoSets: []*RepoSet{
+			{Name: "vim", Tags: []string{"oss"}},
+			{Name: "work", Tags: []string{"work"}},
+			{Name: "platform", Tags: []string{"work", "oss"}},
+			{Name: "personal"},
+		},
+	}
+
+	names := func(names, tags []string) []string {
+		sets, err := cfg.MatchRepoSets(names, tags)
+		c.Assert(err, qt.IsNil)
+
+		var out []string
+		for _, rs := range sets {
+			out = append(out, rs.Name)
+		}
+		return out
+	}
+
+	c.Assert(names([]string{"personal", "vim"}, nil), qt.DeepEquals, []string{"vim", "personal"})
+	c.Assert(names(nil, []string{"work"}), qt.DeepEquals, []string{"work", "platform"})
+	c.Assert(names([]string{"work"}, []string{"oss"}), qt.DeepEquals, []string{"vim", "work", "platform"})
+
+	_, err := cfg.MatchRepoSets([]string{"unknown"}, nil)
+	c.Assert(err, qt.ErrorMatches, `repo set with name "unknown" doesn't exists`)
+
+	_, err = cfg.MatchRepoSets(nil, []string{"home"})
+	c.Assert(err, qt.ErrorMatches, `no repo set has the tag "home"`)
+}
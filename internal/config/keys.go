@@ -0,0 +1,223 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Keys returns the keys of all settings of a reposet, i.e: "query" or
+// "source.org". The keys are the JSON names of the fields of RepoSet, nested
+// fields are separated with a dot.
+func Keys() []string {
+	return keys(reflect.TypeOf(RepoSet{}), "")
+}
+
+func keys(t reflect.Type, prefix string) []string {
+	var out []string
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := jsonName(t.Field(i))
+		if !ok {
+			continue
+		}
+
+		ft := t.Field(i).Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if ft.Kind() == reflect.Struct {
+			out = append(out, keys(ft, prefix+name+".")...)
+			continue
+		}
+
+		out = append(out, prefix+name)
+	}
+
+	return out
+}
+
+// jsonName returns the JSON name of the given field. It returns false if the
+// field isn't encoded.
+func jsonName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = f.Name
+	}
+
+	return name, true
+}
+
+// field returns the field of the reposet with the given key. If alloc is
+// true, nil structs on the way to the field are allocated, otherwise an
+// invalid value is returned for them.
+func (rs *RepoSet) field(key string, alloc bool) (reflect.Value, error) {
+	v := reflect.ValueOf(rs).Elem()
+	missing := false
+
+	for _, name := range strings.Split(key, ".") {
+		if v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Struct {
+			if v.IsNil() {
+				if !alloc {
+					// the rest of the key still needs to be checked
+					missing = true
+					v = reflect.New(v.Type().Elem())
+				} else {
+					v.Set(reflect.New(v.Type().Elem()))
+				}
+			}
+			v = v.Elem()
+		}
+
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("unknown key %q", key)
+		}
+
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if n, ok := jsonName(v.Type().Field(i)); ok && n == name {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return reflect.Value{}, fmt.Errorf("unknown key %q", key)
+		}
+	}
+
+	if missing {
+		return reflect.Value{}, nil
+	}
+
+	return v, nil
+}
+
+// Get returns the value of the setting with the given key. Lists are
+// separated by commas, and all other values, which are not a string, a
+// number or a boolean, are encoded as JSON. Lists with a comma in their
+// values are encoded as JSON as well. Unset values are empty.
+func (rs *RepoSet) Get(key string) (string, error) {
+	v, err := rs.field(key, false)
+	if err != nil || !v.IsValid() {
+		return "", err
+	}
+
+	return format(v)
+}
+
+// Set changes the setting with the given key to the given value, in the
+// format returned by Get.
+func (rs *RepoSet) Set(key, value string) error {
+	v, err := rs.field(key, true)
+	if err != nil {
+		return err
+	}
+
+	if err := parse(v, value); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Unset changes the setting with the given key back to its zero value.
+func (rs *RepoSet) Unset(key string) error {
+	v, err := rs.field(key, false)
+	if err != nil || !v.IsValid() {
+		return err
+	}
+
+	v.Set(reflect.Zero(v.Type()))
+	return nil
+}
+
+func format(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			list := v.Convert(reflect.TypeOf([]string{})).Interface().([]string)
+			if !strings.Contains(strings.Join(list, ""), ",") {
+				return strings.Join(list, ","), nil
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "", nil
+		}
+		if v.Elem().Kind() != reflect.Struct {
+			return format(v.Elem())
+		}
+	}
+
+	out, err := json.Marshal(v.Interface())
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func parse(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(value, "[") {
+			var list []string
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					list = append(list, s)
+				}
+			}
+			v.Set(reflect.ValueOf(list).Convert(v.Type()))
+			return nil
+		}
+	case reflect.Ptr:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			p := reflect.New(v.Type().Elem())
+			if err := parse(p.Elem(), value); err != nil {
+				return err
+			}
+			v.Set(p)
+			return nil
+		}
+	}
+
+	p := reflect.New(v.Type())
+	if err := json.Unmarshal([]byte(value), p.Interface()); err != nil {
+		return fmt.Errorf("invalid JSON value: %w", err)
+	}
+	v.Set(p.Elem())
+	return nil
+}
@@ -0,0 +1,300 @@
+// Package file fetches repositories from bare git repositories on the local
+// filesystem, i.e: mirrors on air-gapped hosts.
+package file
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/git"
+)
+
+var _ internal.Provider = (*Provider)(nil)
+
+// Provider treats bare git repositories on the local filesystem as the
+// remote. It supports the "dir" and "list" sources.
+type Provider struct {
+	source *internal.Source
+
+	mu   sync.Mutex
+	urls map[string]string // clone URLs by Nwo
+}
+
+// NewProvider returns a provider for the given source. The source is used to
+// find the clone URLs of repositories that were not listed with ListRepos.
+func NewProvider(source *internal.Source) *Provider {
+	return &Provider{
+		source: source,
+		urls:   make(map[string]string),
+	}
+}
+
+// remote is a bare repository.
+type remote struct {
+	repo *internal.Repository
+	url  string
+}
+
+// ListRepos lists the bare repositories of the given source. Repositories
+// of a directory are named after their path relative to the directory, all
+// other repositories after their parent directory and their own directory,
+// without the ".git" suffix.
+func (p *Provider) ListRepos(ctx context.Context, source *internal.Source) ([]*internal.Repository, error) {
+	remotes, err := discover(source)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]*internal.Repository, 0, len(remotes))
+	for _, r := range remotes {
+		branch, _, err := head(r.url)
+		if err != nil {
+			return nil, err
+		}
+
+		r.repo.Branch = branch
+		repos = append(repos, r.repo)
+	}
+
+	p.mu.Lock()
+	for _, r := range remotes {
+		p.urls[r.repo.Nwo] = r.url
+	}
+	p.mu.Unlock()
+
+	return repos, nil
+}
+
+// DefaultBranches returns the heads of the default branches of the given
+// repositories. Repositories that can't be read are left out, hence they're
+// resolved with Branch, which reports the error.
+func (p *Provider) DefaultBranches(ctx context.Context, repos []*internal.Repository) (map[string]*internal.Branch, error) {
+	heads := make(map[string]*internal.Branch, len(repos))
+	for _, repo := range repos {
+		url := p.CloneURL(repo)
+		if url == "" {
+			continue
+		}
+
+		name, sha, err := head(url)
+		if err != nil {
+			continue
+		}
+
+		// empty repository
+		if sha == "" {
+			heads[repo.Nwo] = nil
+			continue
+		}
+
+		updatedAt, err := commitDate(url, sha)
+		if err != nil {
+			continue
+		}
+
+		heads[repo.Nwo] = &internal.Branch{
+			Name:      name,
+			SHA:       sha,
+			UpdatedAt: updatedAt,
+		}
+	}
+
+	return heads, nil
+}
+
+// Branch returns the head of the given branch of a repository.
+func (p *Provider) Branch(ctx context.Context, repo *internal.Repository, branch string) (*internal.Branch, error) {
+	url := p.CloneURL(repo)
+	if url == "" {
+		return nil, fmt.Errorf("repository %q not found", repo.Nwo)
+	}
+
+	g := &git.Client{}
+	out, err := g.Run("ls-remote", url, "refs/heads/"+branch)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return nil, internal.ErrBranchNotFound
+	}
+
+	updatedAt, err := commitDate(url, fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &internal.Branch{
+		Name:      branch,
+		SHA:       fields[0],
+		UpdatedAt: updatedAt,
+	}, nil
+}
+
+// CloneURL returns the file:// URL to clone the given repository. It's
+// empty if the repository isn't part of the provider's source.
+func (p *Provider) CloneURL(repo *internal.Repository) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if url, ok := p.urls[repo.Nwo]; ok {
+		return url
+	}
+
+	if p.source == nil {
+		return ""
+	}
+
+	remotes, err := discover(p.source)
+	if err != nil {
+		return ""
+	}
+
+	for _, r := range remotes {
+		p.urls[r.repo.Nwo] = r.url
+	}
+
+	return p.urls[repo.Nwo]
+}
+
+// discover finds the bare repositories of the given source.
+func discover(source *internal.Source) ([]*remote, error) {
+	switch source.Kind {
+	case internal.SourceDir:
+		return discoverDir(source.Path)
+	case internal.SourceList:
+		remotes := make([]*remote, 0, len(source.Repos))
+		for _, entry := range source.Repos {
+			path, err := filepath.Abs(strings.TrimPrefix(entry, "file://"))
+			if err != nil {
+				return nil, err
+			}
+
+			if !isBareRepo(path) {
+				return nil, fmt.Errorf("%q is not a bare git repository", entry)
+			}
+
+			owner := filepath.Base(filepath.Dir(path))
+			remotes = append(remotes, newRemote(owner, path))
+		}
+		return remotes, nil
+	default:
+		return nil, fmt.Errorf("source kind %q is not supported by the file provider", source.Kind)
+	}
+}
+
+// discoverDir finds the bare repositories inside the given directory.
+func discoverDir(root string) ([]*remote, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var remotes []*remote
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() || !isBareRepo(path) {
+			return nil
+		}
+
+		// repositories directly inside root are owned by root
+		owner := filepath.Base(root)
+		if dir := filepath.Dir(path); dir != root {
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return err
+			}
+			owner = filepath.ToSlash(rel)
+		}
+
+		remotes = append(remotes, newRemote(owner, path))
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return remotes, nil
+}
+
+func newRemote(owner, path string) *remote {
+	name := strings.TrimSuffix(filepath.Base(path), ".git")
+	return &remote{
+		repo: &internal.Repository{
+			Nwo:   owner + "/" + name,
+			Owner: owner,
+			Name:  name,
+		},
+		url: "file://" + filepath.ToSlash(path),
+	}
+}
+
+// isBareRepo reports whether the given directory is a bare git repository.
+func isBareRepo(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+
+	// the directory of a non-bare repository
+	if filepath.Base(dir) == ".git" {
+		return false
+	}
+
+	return true
+}
+
+// head returns the default branch of the given repository and the SHA of its
+// head. The SHA is empty if the repository is empty.
+func head(url string) (branch, sha string, err error) {
+	g := &git.Client{}
+	out, err := g.Run("ls-remote", "--symref", url, "HEAD")
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 3 && fields[0] == "ref:":
+			branch = strings.TrimPrefix(fields[1], "refs/heads/")
+		case len(fields) == 2 && fields[1] == "HEAD":
+			sha = fields[0]
+		}
+	}
+
+	// ls-remote doesn't list the HEAD of empty repositories
+	if branch == "" {
+		g := &git.Client{Dir: strings.TrimPrefix(url, "file://")}
+		out, err := g.Run("symbolic-ref", "--short", "HEAD")
+		if err != nil {
+			return "", "", err
+		}
+		branch = strings.TrimSpace(string(out))
+	}
+
+	return branch, sha, nil
+}
+
+// commitDate returns the committer date of the given commit.
+func commitDate(url, sha string) (time.Time, error) {
+	g := &git.Client{Dir: strings.TrimPrefix(url, "file://")}
+	out, err := g.Run("show", "-s", "--format=%cI", sha)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,149 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/git"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestProvider_ListRepos_dir(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	root := filepath.Join(c.Mkdir(), "mirrors")
+	initBare(c, filepath.Join(root, "fatih", "vim-go.git"), "master")
+	initBare(c, filepath.Join(root, "fatih", "color.git"), "main")
+	initBare(c, filepath.Join(root, "structs.git"), "main")
+
+	// non-bare repositories are ignored
+	runGit(c, "", "init", filepath.Join(root, "work"))
+
+	p := NewProvider(nil)
+	repos, err := p.ListRepos(ctx, &internal.Source{Kind: internal.SourceDir, Path: root})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.DeepEquals, []*internal.Repository{
+		{Nwo: "fatih/color", Owner: "fatih", Name: "color", Branch: "main"},
+		{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go", Branch: "master"},
+		{Nwo: "mirrors/structs", Owner: "mirrors", Name: "structs", Branch: "main"},
+	})
+
+	c.Assert(p.CloneURL(repos[1]), qt.Equals, "file://"+filepath.Join(root, "fatih", "vim-go.git"))
+}
+
+func TestProvider_ListRepos_list(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.Mkdir()
+	path := filepath.Join(dir, "fatih", "vim-go.git")
+	initBare(c, path, "master")
+
+	p := NewProvider(nil)
+	repos, err := p.ListRepos(ctx, &internal.Source{
+		Kind:  internal.SourceList,
+		Repos: []string{"file://" + path},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.DeepEquals, []*internal.Repository{
+		{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go", Branch: "master"},
+	})
+
+	_, err = p.ListRepos(ctx, &internal.Source{
+		Kind:  internal.SourceList,
+		Repos: []string{filepath.Join(dir, "fatih")},
+	})
+	c.Assert(err, qt.ErrorMatches, `".*fatih" is not a bare git repository`)
+
+	_, err = p.ListRepos(ctx, &internal.Source{Kind: internal.SourceOrg, Org: "fatih"})
+	c.Assert(err, qt.ErrorMatches, `source kind "org" is not supported by the file provider`)
+}
+
+func TestProvider_DefaultBranches(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	root := c.Mkdir()
+	initBare(c, filepath.Join(root, "fatih", "empty.git"), "main")
+	sha := commit(c, initBare(c, filepath.Join(root, "fatih", "vim-go.git"), "master"), "master")
+
+	source := &internal.Source{Kind: internal.SourceDir, Path: root}
+
+	// the clone URLs are resolved with the provider's source
+	p := NewProvider(source)
+	heads, err := p.DefaultBranches(ctx, []*internal.Repository{
+		{Nwo: "fatih/empty"},
+		{Nwo: "fatih/vim-go"},
+		{Nwo: "fatih/missing"},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(heads, qt.HasLen, 2)
+	c.Assert(heads["fatih/empty"], qt.IsNil)
+
+	head := heads["fatih/vim-go"]
+	c.Assert(head, qt.Not(qt.IsNil))
+	c.Assert(head.Name, qt.Equals, "master")
+	c.Assert(head.SHA, qt.Equals, sha)
+	c.Assert(head.UpdatedAt.IsZero(), qt.IsFalse)
+}
+
+func TestProvider_Branch(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	root := c.Mkdir()
+	sha := commit(c, initBare(c, filepath.Join(root, "fatih", "vim-go.git"), "master"), "master")
+
+	p := NewProvider(&internal.Source{Kind: internal.SourceDir, Path: root})
+	repo := &internal.Repository{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go"}
+
+	branch, err := p.Branch(ctx, repo, "master")
+	c.Assert(err, qt.IsNil)
+	c.Assert(branch.Name, qt.Equals, "master")
+	c.Assert(branch.SHA, qt.Equals, sha)
+
+	_, err = p.Branch(ctx, repo, "main")
+	c.Assert(errors.Is(err, internal.ErrBranchNotFound), qt.IsTrue)
+
+	_, err = p.Branch(ctx, &internal.Repository{Nwo: "fatih/missing"}, "master")
+	c.Assert(err, qt.ErrorMatches, `repository "fatih/missing" not found`)
+}
+
+// initBare creates an empty bare repository with the given default branch
+// and returns its path.
+func initBare(c *qt.C, path, branch string) string {
+	c.Helper()
+
+	c.Assert(os.MkdirAll(filepath.Dir(path), 0o755), qt.IsNil)
+	runGit(c, "", "init", "--bare", "--initial-branch="+branch, path)
+	return path
+}
+
+// commit pushes an empty commit to the given branch of the bare repository
+// and returns its SHA.
+func commit(c *qt.C, remote, branch string) string {
+	c.Helper()
+
+	work := c.Mkdir()
+	runGit(c, work, "init", "--initial-branch="+branch)
+	runGit(c, work, "-c", "user.name=test", "-c", "user.email=test@example.com",
+		"commit", "--allow-empty", "-m", "initial")
+	runGit(c, work, "push", remote, branch)
+	return runGit(c, work, "rev-parse", "HEAD")
+}
+
+func runGit(c *qt.C, dir string, args ...string) string {
+	c.Helper()
+
+	g := &git.Client{Dir: dir}
+	out, err := g.Run(args...)
+	c.Assert(err, qt.IsNil)
+	return strings.TrimSpace(string(out))
+}
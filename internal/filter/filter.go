@@ -0,0 +1,359 @@
+// Package filter selects the repositories of a reposet with ordered include
+// and exclude rules.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+)
+
+// Action defines what happens to the repositories a rule matches.
+type Action string
+
+const (
+	// Include includes the matching repositories.
+	Include Action = "include"
+
+	// Exclude excludes the matching repositories.
+	Exclude Action = "exclude"
+)
+
+// Rule includes or excludes the repositories that match all of its
+// conditions. A rule without any conditions matches all repositories.
+type Rule struct {
+	Action Action `json:"action"`
+
+	// Name is a glob pattern, such as "vim-*", matched against the name of
+	// the repositories. If it contains a "/", it's matched against the
+	// name with owner instead.
+	Name string `json:"name,omitempty"`
+
+	// Regex is a regular expression matched against the name with owner.
+	Regex string `json:"regex,omitempty"`
+
+	// Topic matches the repositories with the given topic.
+	Topic string `json:"topic,omitempty"`
+
+	// Language matches the repositories with the given primary language,
+	// case-insensitively.
+	Language string `json:"language,omitempty"`
+
+	// Fork and Archived match forked or archived repositories if they're
+	// true, and the other repositories if they're false.
+	Fork     *bool `json:"fork,omitempty"`
+	Archived *bool `json:"archived,omitempty"`
+
+	// MinSize and MaxSize match the repositories by their size in
+	// kilobytes.
+	MinSize int64 `json:"min_size,omitempty"`
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// PushedWithin matches the repositories that were pushed within the
+	// given age, i.e: "720h" or "30d". NotPushedFor matches the ones that
+	// weren't. Repositories without a push time match neither.
+	PushedWithin string `json:"pushed_within,omitempty"`
+	NotPushedFor string `json:"not_pushed_for,omitempty"`
+}
+
+// Validate checks whether the rule is valid.
+func (r Rule) Validate() error {
+	_, err := compile(r)
+	return err
+}
+
+// String returns the rule in a human readable form, i.e:
+// "exclude name=fatih/* archived=true".
+func (r Rule) String() string {
+	parts := []string{string(r.Action)}
+	add := func(key, value string) {
+		parts = append(parts, key+"="+value)
+	}
+
+	if r.Name != "" {
+		add("name", r.Name)
+	}
+	if r.Regex != "" {
+		add("regex", r.Regex)
+	}
+	if r.Topic != "" {
+		add("topic", r.Topic)
+	}
+	if r.Language != "" {
+		add("language", r.Language)
+	}
+	if r.Fork != nil {
+		add("fork", strconv.FormatBool(*r.Fork))
+	}
+	if r.Archived != nil {
+		add("archived", strconv.FormatBool(*r.Archived))
+	}
+	if r.MinSize != 0 {
+		add("min_size", strconv.FormatInt(r.MinSize, 10))
+	}
+	if r.MaxSize != 0 {
+		add("max_size", strconv.FormatInt(r.MaxSize, 10))
+	}
+	if r.PushedWithin != "" {
+		add("pushed_within", r.PushedWithin)
+	}
+	if r.NotPushedFor != "" {
+		add("not_pushed_for", r.NotPushedFor)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// ParseAge parses a duration, such as "720h". In addition to the units of
+// time.ParseDuration, a number of days, such as "30d", is supported.
+func ParseAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q, should be a duration, i.e: '720h' or '30d'", s)
+	}
+	return d, nil
+}
+
+// rule is a validated rule.
+type rule struct {
+	Rule
+
+	regex        *regexp.Regexp
+	pushedWithin time.Duration
+	notPushedFor time.Duration
+}
+
+func compile(r Rule) (*rule, error) {
+	if r.Action != Include && r.Action != Exclude {
+		return nil, fmt.Errorf("unknown action %q, should be %q or %q", r.Action, Include, Exclude)
+	}
+
+	if _, err := path.Match(r.Name, ""); err != nil {
+		return nil, fmt.Errorf("invalid name pattern %q: %w", r.Name, err)
+	}
+
+	c := &rule{Rule: r}
+
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", r.Regex, err)
+		}
+		c.regex = re
+	}
+
+	if r.MinSize < 0 || r.MaxSize < 0 {
+		return nil, errors.New("sizes should not be negative")
+	}
+
+	if r.MaxSize != 0 && r.MinSize > r.MaxSize {
+		return nil, fmt.Errorf("min size %d is larger than the max size %d", r.MinSize, r.MaxSize)
+	}
+
+	var err error
+	if r.PushedWithin != "" {
+		if c.pushedWithin, err = ParseAge(r.PushedWithin); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.NotPushedFor != "" {
+		if c.notPushedFor, err = ParseAge(r.NotPushedFor); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// match reports whether the rule matches the given repository, and the
+// reasons why it matches.
+func (r *rule) match(repo *internal.Repository, now time.Time) ([]string, bool) {
+	var reasons []string
+
+	if r.Name != "" {
+		name := repo.Name
+		if strings.Contains(r.Name, "/") {
+			name = repo.Nwo
+		}
+
+		if ok, _ := path.Match(r.Name, name); !ok {
+			return nil, false
+		}
+		reasons = append(reasons, fmt.Sprintf("%q matches %q", name, r.Name))
+	}
+
+	if r.regex != nil {
+		if !r.regex.MatchString(repo.Nwo) {
+			return nil, false
+		}
+		reasons = append(reasons, fmt.Sprintf("%q matches /%s/", repo.Nwo, r.Regex))
+	}
+
+	if r.Topic != "" {
+		if !repo.HasTopic(r.Topic) {
+			return nil, false
+		}
+		reasons = append(reasons, fmt.Sprintf("has topic %q", r.Topic))
+	}
+
+	if r.Language != "" {
+		if !strings.EqualFold(r.Language, repo.Language) {
+			return nil, false
+		}
+		reasons = append(reasons, fmt.Sprintf("language is %q", repo.Language))
+	}
+
+	if r.Fork != nil {
+		if *r.Fork != repo.Fork {
+			return nil, false
+		}
+		if repo.Fork {
+			reasons = append(reasons, "is a fork")
+		} else {
+			reasons = append(reasons, "is not a fork")
+		}
+	}
+
+	if r.Archived != nil {
+		if *r.Archived != repo.Archived {
+			return nil, false
+		}
+		if repo.Archived {
+			reasons = append(reasons, "is archived")
+		} else {
+			reasons = append(reasons, "is not archived")
+		}
+	}
+
+	if r.MinSize != 0 {
+		if repo.Size < r.MinSize {
+			return nil, false
+		}
+		reasons = append(reasons, fmt.Sprintf("size %d KB is at least %d KB", repo.Size, r.MinSize))
+	}
+
+	if r.MaxSize != 0 {
+		if repo.Size > r.MaxSize {
+			return nil, false
+		}
+		reasons = append(reasons, fmt.Sprintf("size %d KB is at most %d KB", repo.Size, r.MaxSize))
+	}
+
+	if r.PushedWithin != "" {
+		if repo.PushedAt.IsZero() || now.Sub(repo.PushedAt) > r.pushedWithin {
+			return nil, false
+		}
+		reasons = append(reasons, fmt.Sprintf("pushed within %s", r.PushedWithin))
+	}
+
+	if r.NotPushedFor != "" {
+		if repo.PushedAt.IsZero() || now.Sub(repo.PushedAt) <= r.notPushedFor {
+			return nil, false
+		}
+		reasons = append(reasons, fmt.Sprintf("not pushed for %s", r.NotPushedFor))
+	}
+
+	if len(reasons) == 0 {
+		reasons = append(reasons, "matches all repositories")
+	}
+
+	return reasons, true
+}
+
+// Filter selects repositories with a list of rules. The rules are evaluated
+// in order and the last matching rule wins. Repositories no rule matches
+// are included, unless there's an include rule; then only the repositories
+// matching an include rule are included.
+type Filter struct {
+	rules []*rule
+	now   time.Time
+
+	// include is the decision for repositories no rule matches.
+	include bool
+}
+
+// New returns a filter with the given rules. Ages are relative to the time
+// the filter is created.
+func New(rules []Rule) (*Filter, error) {
+	f := &Filter{
+		now:     time.Now(),
+		include: true,
+	}
+
+	for i, r := range rules {
+		c, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i+1, r, err)
+		}
+
+		if r.Action == Include {
+			f.include = false
+		}
+		f.rules = append(f.rules, c)
+	}
+
+	return f, nil
+}
+
+// Decision is the result of filtering a single repository.
+type Decision struct {
+	Include bool
+
+	// Rule is the index of the rule that made the decision, or -1 if no
+	// rule matched.
+	Rule int
+
+	// Reason explains why the rule matched.
+	Reason string
+}
+
+// Decide returns whether the given repository is included.
+func (f *Filter) Decide(repo *internal.Repository) Decision {
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		reasons, ok := f.rules[i].match(repo, f.now)
+		if !ok {
+			continue
+		}
+
+		return Decision{
+			Include: f.rules[i].Action == Include,
+			Rule:    i,
+			Reason:  strings.Join(reasons, ", "),
+		}
+	}
+
+	d := Decision{Include: f.include, Rule: -1}
+	if f.include {
+		d.Reason = "no rule matches, repositories are included by default"
+	} else {
+		d.Reason = "no include rule matches"
+	}
+	return d
+}
+
+// Apply returns the included repositories.
+func (f *Filter) Apply(repos []*internal.Repository) []*internal.Repository {
+	out := make([]*internal.Repository, 0, len(repos))
+	for _, repo := range repos {
+		if f.Decide(repo).Include {
+			out = append(out, repo)
+		}
+	}
+
+	return out
+}
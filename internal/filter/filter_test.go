@@ -0,0 +1,208 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+func testRepos(now time.Time) []*internal.Repository {
+	return []*internal.Repository{
+		{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go", Metadata: internal.Metadata{
+			Language: "Vim script", Topics: []string{"vim", "go"}, Size: 12000, PushedAt: now.Add(-24 * time.Hour),
+		}},
+		{Nwo: "fatih/color", Owner: "fatih", Name: "color", Metadata: internal.Metadata{
+			Language: "Go", Topics: []string{"cli"}, Size: 100, PushedAt: now.Add(-400 * 24 * time.Hour),
+		}},
+		{Nwo: "fatih/structs", Owner: "fatih", Name: "structs", Metadata: internal.Metadata{
+			Language: "Go", Archived: true, Size: 200, PushedAt: now.Add(-1000 * 24 * time.Hour),
+		}},
+		{Nwo: "acme/vim-go", Owner: "acme", Name: "vim-go", Metadata: internal.Metadata{
+			Language: "Vim script", Fork: true, Size: 11000,
+		}},
+	}
+}
+
+func TestFilter_Apply(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		rules []Rule
+		want  []string
+	}{
+		{
+			name: "no rules",
+			want: []string{"fatih/vim-go", "fatih/color", "fatih/structs", "acme/vim-go"},
+		},
+		{
+			name:  "name glob",
+			rules: []Rule{{Action: Include, Name: "vim-*"}},
+			want:  []string{"fatih/vim-go", "acme/vim-go"},
+		},
+		{
+			name:  "name glob with owner",
+			rules: []Rule{{Action: Exclude, Name: "fatih/*"}},
+			want:  []string{"acme/vim-go"},
+		},
+		{
+			name:  "regex",
+			rules: []Rule{{Action: Include, Regex: `^fatih/(color|structs)$`}},
+			want:  []string{"fatih/color", "fatih/structs"},
+		},
+		{
+			name:  "topic",
+			rules: []Rule{{Action: Include, Topic: "cli"}},
+			want:  []string{"fatih/color"},
+		},
+		{
+			name:  "language",
+			rules: []Rule{{Action: Exclude, Language: "vim SCRIPT"}},
+			want:  []string{"fatih/color", "fatih/structs"},
+		},
+		{
+			name:  "fork and archived",
+			rules: []Rule{{Action: Exclude, Fork: boolPtr(true)}, {Action: Exclude, Archived: boolPtr(true)}},
+			want:  []string{"fatih/vim-go", "fatih/color"},
+		},
+		{
+			name:  "size",
+			rules: []Rule{{Action: Include, MinSize: 150, MaxSize: 11500}},
+			want:  []string{"fatih/structs", "acme/vim-go"},
+		},
+		{
+			name:  "pushed within",
+			rules: []Rule{{Action: Include, PushedWithin: "30d"}},
+			want:  []string{"fatih/vim-go"},
+		},
+		{
+			name:  "not pushed for",
+			rules: []Rule{{Action: Exclude, NotPushedFor: "365d"}},
+			want:  []string{"fatih/vim-go", "acme/vim-go"},
+		},
+		{
+			name:  "all conditions of a rule match",
+			rules: []Rule{{Action: Exclude, Name: "vim-go", Fork: boolPtr(true)}},
+			want:  []string{"fatih/vim-go", "fatih/color", "fatih/structs"},
+		},
+		{
+			name: "last match wins",
+			rules: []Rule{
+				{Action: Include, Name: "fatih/*"},
+				{Action: Exclude, Language: "go"},
+				{Action: Include, Name: "color"},
+			},
+			want: []string{"fatih/vim-go", "fatih/color"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			f, err := New(tt.rules)
+			c.Assert(err, qt.IsNil)
+			f.now = now
+
+			var got []string
+			for _, repo := range f.Apply(testRepos(now)) {
+				got = append(got, repo.Nwo)
+			}
+			c.Assert(got, qt.DeepEquals, tt.want)
+		})
+	}
+}
+
+func TestFilter_Decide(t *testing.T) {
+	c := qt.New(t)
+	now := time.Now()
+	repos := testRepos(now)
+
+	f, err := New([]Rule{
+		{Action: Include, Name: "fatih/*"},
+		{Action: Exclude, Archived: boolPtr(true)},
+	})
+	c.Assert(err, qt.IsNil)
+	f.now = now
+
+	c.Assert(f.Decide(repos[0]), qt.DeepEquals, Decision{
+		Include: true,
+		Rule:    0,
+		Reason:  `"fatih/vim-go" matches "fatih/*"`,
+	})
+	c.Assert(f.Decide(repos[2]), qt.DeepEquals, Decision{
+		Include: false,
+		Rule:    1,
+		Reason:  "is archived",
+	})
+	c.Assert(f.Decide(repos[3]), qt.DeepEquals, Decision{
+		Include: false,
+		Rule:    -1,
+		Reason:  "no include rule matches",
+	})
+}
+
+func TestNew_invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		err  string
+	}{
+		{
+			name: "action",
+			rule: Rule{Action: "skip"},
+			err:  `rule 1 \(skip\): unknown action "skip", should be "include" or "exclude"`,
+		},
+		{
+			name: "name",
+			rule: Rule{Action: Include, Name: "vim-["},
+			err:  `rule 1 \(include name=vim-\[\): invalid name pattern "vim-\[": syntax error in pattern`,
+		},
+		{
+			name: "regex",
+			rule: Rule{Action: Include, Regex: "("},
+			err:  `rule 1 \(include regex=\(\): invalid regex "\(": .*`,
+		},
+		{
+			name: "size",
+			rule: Rule{Action: Exclude, MinSize: 10, MaxSize: 5},
+			err:  `rule 1 \(exclude min_size=10 max_size=5\): min size 10 is larger than the max size 5`,
+		},
+		{
+			name: "age",
+			rule: Rule{Action: Exclude, NotPushedFor: "1y"},
+			err:  `rule 1 \(exclude not_pushed_for=1y\): invalid age "1y", should be a duration, i.e: '720h' or '30d'`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			c := qt.New(t)
+
+			_, err := New([]Rule{tt.rule})
+			c.Assert(err, qt.ErrorMatches, tt.err)
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	c := qt.New(t)
+
+	d, err := ParseAge("30d")
+	c.Assert(err, qt.IsNil)
+	c.Assert(d, qt.Equals, 30*24*time.Hour)
+
+	d, err = ParseAge("1h30m")
+	c.Assert(err, qt.IsNil)
+	c.Assert(d, qt.Equals, 90*time.Minute)
+
+	_, err = ParseAge("-1d")
+	c.Assert(err, qt.ErrorMatches, `invalid age "-1d"`)
+}
@@ -0,0 +1,220 @@
+package fsstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+)
+
+const (
+	// archiveDir is the directory, relative to the repositories directory,
+	// that contains the archived repositories.
+	archiveDir = ".starhook-archive"
+
+	archiveTimeFormat = "20060102T150405Z"
+)
+
+// ArchivedRepo is a repository that was moved into the archive.
+type ArchivedRepo struct {
+	Repo       *internal.Repository
+	Path       string // absolute path of the archived repository
+	ArchivedAt time.Time
+}
+
+// ArchiveRepo moves a single repository into the archive and returns its new
+// location, i.e: <dir>/.starhook-archive/<owner>/<name>-<timestamp>. The
+// repository's metadata is stored next to it, so it can be restored later.
+func (r *RepositoryStore) ArchiveRepo(ctx context.Context, repo *internal.Repository) (string, error) {
+	repoDir, err := r.RepoDir(repo)
+	if err != nil {
+		return "", err
+	}
+
+	// nothing to archive if the repo wasn't cloned yet
+	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	archivedAt := time.Now().UTC()
+	path := filepath.Join(r.dir, archiveDir, filepath.FromSlash(repo.Nwo)+"-"+archivedAt.Format(archiveTimeFormat))
+
+	log.Printf("[DEBUG] archiving repo, name: %q, path: %q", repo.Nwo, path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	out, err := json.MarshalIndent(repo, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(path+".json", out, 0o644); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(repoDir, path); err != nil {
+		os.Remove(path + ".json")
+		return "", err
+	}
+
+	r.removeEmptyParents(repoDir)
+	return path, nil
+}
+
+// ArchivedRepos returns all archived repositories, the most recently archived
+// first.
+func (r *RepositoryStore) ArchivedRepos() ([]*ArchivedRepo, error) {
+	root := filepath.Join(r.dir, archiveDir)
+
+	var archived []*ArchivedRepo
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			return err
+		}
+
+		// the metadata of an archived repository is next to it, its working
+		// tree isn't walked. GitLab namespaces can be nested, hence the
+		// owner level isn't fixed.
+		if info.IsDir() {
+			if _, err := os.Stat(path + ".json"); err == nil && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		a, err := readArchivedRepo(strings.TrimSuffix(path, ".json"))
+		if err != nil {
+			return err
+		}
+
+		archived = append(archived, a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(archived, func(i, j int) bool {
+		return archived[i].ArchivedAt.After(archived[j].ArchivedAt)
+	})
+
+	return archived, nil
+}
+
+// RestoreRepo moves the archived repository back to its location in the
+// repositories directory.
+func (r *RepositoryStore) RestoreRepo(ctx context.Context, archived *ArchivedRepo) error {
+	repoDir, err := r.RepoDir(archived.Repo)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(repoDir); err == nil {
+		return fmt.Errorf("can't restore %q: %q already exists", archived.Repo.Nwo, repoDir)
+	}
+
+	log.Printf("[DEBUG] restoring repo, name: %q, path: %q", archived.Repo.Nwo, repoDir)
+
+	if err := os.MkdirAll(filepath.Dir(repoDir), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(archived.Path, repoDir); err != nil {
+		return err
+	}
+
+	if err := os.Remove(archived.Path + ".json"); err != nil {
+		return err
+	}
+
+	r.removeEmptyParents(archived.Path)
+	return nil
+}
+
+// LocalChanges returns the local changes of a single repository that would
+// be lost if it's deleted.
+func (r *RepositoryStore) LocalChanges(ctx context.Context, repo *internal.Repository) ([]string, error) {
+	repoDir, err := r.RepoDir(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	g := r.git(repoDir)
+
+	// UpdateRepo pulls the recorded SHA, which doesn't move the
+	// remote-tracking branches. Commits reachable from it are upstream
+	// commits and not unpushed ones.
+	unpushed := []string{"log", "--branches", "--oneline", "--not", "--remotes"}
+	if repo.SHA != "" {
+		if _, err := g.Run("cat-file", "-e", repo.SHA+"^{commit}"); err == nil {
+			unpushed = append(unpushed, repo.SHA)
+		}
+	}
+
+	checks := []struct {
+		args   []string
+		change string
+	}{
+		{args: []string{"status", "--porcelain"}, change: "uncommitted changes"},
+		{args: []string{"stash", "list"}, change: "stashed changes"},
+		{args: unpushed, change: "unpushed commits"},
+	}
+
+	var changes []string
+	for _, check := range checks {
+		out, err := g.Run(check.args...)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(strings.TrimSpace(string(out))) != 0 {
+			changes = append(changes, check.change)
+		}
+	}
+
+	return changes, nil
+}
+
+func readArchivedRepo(path string) (*ArchivedRepo, error) {
+	in, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	var repo *internal.Repository
+	if err := json.Unmarshal(in, &repo); err != nil {
+		return nil, fmt.Errorf("reading archived repo %q: %w", path, err)
+	}
+
+	base := filepath.Base(path)
+	archivedAt, err := time.Parse(archiveTimeFormat, base[strings.LastIndex(base, "-")+1:])
+	if err != nil {
+		return nil, fmt.Errorf("reading archived repo %q: %w", path, err)
+	}
+
+	return &ArchivedRepo{
+		Repo:       repo,
+		Path:       path,
+		ArchivedAt: archivedAt,
+	}, nil
+}
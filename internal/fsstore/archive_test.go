@@ -0,0 +1,151 @@
+package fsstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/fatih/starhook/internal"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRepositoryStore_ArchiveRepo(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	dir := c.Mkdir()
+
+	store, err := NewRepositoryStore(dir, Options{Layout: LayoutOwnerName})
+	c.Assert(err, qt.IsNil)
+
+	repo := &internal.Repository{
+		ID:    1,
+		Nwo:   "fatih/vim-go",
+		Owner: "fatih",
+		Name:  "vim-go",
+	}
+
+	repoDir, err := store.RepoDir(repo)
+	c.Assert(err, qt.IsNil)
+	runGit(c, "", "init", repoDir)
+
+	path, err := store.ArchiveRepo(ctx, repo)
+	c.Assert(err, qt.IsNil)
+	c.Assert(path, qt.Not(qt.Equals), "")
+
+	_, err = os.Stat(repoDir)
+	c.Assert(os.IsNotExist(err), qt.IsTrue, qt.Commentf("repo should be moved into the archive"))
+
+	_, err = os.Stat(filepath.Join(dir, "fatih"))
+	c.Assert(os.IsNotExist(err), qt.IsTrue, qt.Commentf("empty owner directory should be removed"))
+
+	archived, err := store.ArchivedRepos()
+	c.Assert(err, qt.IsNil)
+	c.Assert(archived, qt.HasLen, 1)
+	c.Assert(archived[0].Path, qt.Equals, path)
+	c.Assert(archived[0].Repo.Nwo, qt.Equals, repo.Nwo)
+
+	err = store.RestoreRepo(ctx, archived[0])
+	c.Assert(err, qt.IsNil)
+
+	_, err = os.Stat(filepath.Join(repoDir, ".git"))
+	c.Assert(err, qt.IsNil)
+
+	archived, err = store.ArchivedRepos()
+	c.Assert(err, qt.IsNil)
+	c.Assert(archived, qt.HasLen, 0)
+}
+
+func TestRepositoryStore_ArchivedRepos(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	store, err := NewRepositoryStore(c.Mkdir(), Options{Layout: LayoutOwnerName})
+	c.Assert(err, qt.IsNil)
+
+	repos := []*internal.Repository{
+		{ID: 1, Nwo: "fatih/web", Owner: "fatih", Name: "web"},
+		{ID: 2, Nwo: "group/sub/app", Owner: "group/sub", Name: "app"},
+	}
+
+	for _, repo := range repos {
+		repoDir, err := store.RepoDir(repo)
+		c.Assert(err, qt.IsNil)
+		runGit(c, "", "init", repoDir)
+
+		// JSON files of the working tree are not archive metadata
+		err = os.WriteFile(filepath.Join(repoDir, "package.json"), []byte(`{"name": "web"}`), 0o644)
+		c.Assert(err, qt.IsNil)
+
+		_, err = store.ArchiveRepo(ctx, repo)
+		c.Assert(err, qt.IsNil)
+	}
+
+	archived, err := store.ArchivedRepos()
+	c.Assert(err, qt.IsNil)
+	c.Assert(archived, qt.HasLen, 2)
+
+	var nwos []string
+	for _, a := range archived {
+		nwos = append(nwos, a.Repo.Nwo)
+	}
+	sort.Strings(nwos)
+	c.Assert(nwos, qt.DeepEquals, []string{"fatih/web", "group/sub/app"})
+}
+
+func TestRepositoryStore_LocalChanges(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	dir := c.Mkdir()
+
+	store, err := NewRepositoryStore(dir, Options{})
+	c.Assert(err, qt.IsNil)
+
+	repo := &internal.Repository{
+		Nwo:   "fatih/vim-go",
+		Owner: "fatih",
+		Name:  "vim-go",
+	}
+
+	// not cloned repositories have no changes
+	changes, err := store.LocalChanges(ctx, repo)
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.HasLen, 0)
+
+	repoDir, err := store.RepoDir(repo)
+	c.Assert(err, qt.IsNil)
+	runGit(c, "", "init", repoDir)
+
+	err = os.WriteFile(filepath.Join(repoDir, "main.go"), []byte("package main"), 0o644)
+	c.Assert(err, qt.IsNil)
+
+	changes, err = store.LocalChanges(ctx, repo)
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.DeepEquals, []string{"uncommitted changes"})
+
+	commit := func() string {
+		runGit(c, repoDir, "add", "-A")
+		runGit(c, repoDir, "-c", "user.name=test", "-c", "user.email=test@example.com",
+			"commit", "--allow-empty", "-m", "commit")
+		return runGit(c, repoDir, "rev-parse", "HEAD")
+	}
+
+	// commits reachable from the pulled SHA are not unpushed
+	repo.SHA = commit()
+	changes, err = store.LocalChanges(ctx, repo)
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.HasLen, 0)
+
+	commit()
+	changes, err = store.LocalChanges(ctx, repo)
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.DeepEquals, []string{"unpushed commits"})
+
+	// an unknown SHA, i.e: not fetched yet, is ignored
+	repo.SHA = "0123456789abcdef0123456789abcdef01234567"
+	changes, err = store.LocalChanges(ctx, repo)
+	c.Assert(err, qt.IsNil)
+	c.Assert(changes, qt.DeepEquals, []string{"unpushed commits"})
+}
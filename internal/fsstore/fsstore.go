@@ -2,29 +2,184 @@ package fsstore
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/fatih/starhook/internal"
 	"github.com/fatih/starhook/internal/git"
 )
 
+const (
+	// layoutFile stores the layout the repositories on disk are placed with.
+	layoutFile = ".starhook-layout"
+
+	// migrateDir is used to stage repositories while migrating them to a
+	// different layout.
+	migrateDir = ".starhook-migrate"
+)
+
+// defaultHost is the host repositories are cloned from.
+const defaultHost = "github.com"
+
+// Clone protocols define how repositories are cloned.
+const (
+	ProtocolHTTPS = "https"
+	ProtocolSSH   = "ssh"
+)
+
 type RepositoryStore struct {
-	dir string
+	dir    string
+	layout *Layout
+
+	baseURL  string
+	protocol string
+	sshHost  string
+	env      []string // passed to git, contains the credentials
+
+	// httpsURL returns the HTTPS URL to clone a repository, if set
+	httpsURL func(repo *internal.Repository) string
+}
+
+// Options defines the options of a RepositoryStore.
+type Options struct {
+	// Layout defines how repositories are placed inside the repositories
+	// directory. See ParseLayout for the supported values.
+	Layout string
+
+	// Token is used to authenticate git operations, such as cloning
+	// private repositories. It's optional and only used with HTTPS.
+	Token string
+
+	// CloneProtocol is either "https" (default) or "ssh".
+	CloneProtocol string
+
+	// SSHHost is the host used to clone via SSH. It can be a host alias
+	// from ~/.ssh/config, i.e: to use a specific key. Defaults to Host.
+	SSHHost string
+
+	// Host is the host to clone the repositories from, i.e: the host of a
+	// GitHub Enterprise Server instance. Defaults to "github.com".
+	Host string
+
+	// CloneURL returns the HTTPS URL to clone the given repository, i.e:
+	// internal.Provider's CloneURL. Defaults to
+	// "https://<Host>/<owner>/<name>.git".
+	CloneURL func(repo *internal.Repository) string
+
+	// TokenUser is the user name passed with Token. Defaults to
+	// "x-access-token", which is accepted by GitHub and Gitea.
+	TokenUser string
 }
 
-func NewRepositoryStore(dir string) (*RepositoryStore, error) {
-	return &RepositoryStore{
-		dir: dir,
-	}, nil
+// ParseCloneProtocol checks whether the given clone protocol is valid. An
+// empty protocol defaults to ProtocolHTTPS.
+func ParseCloneProtocol(protocol string) (string, error) {
+	switch protocol {
+	case "":
+		return ProtocolHTTPS, nil
+	case ProtocolHTTPS, ProtocolSSH:
+		return protocol, nil
+	default:
+		return "", fmt.Errorf("unknown clone protocol %q, should be %q or %q",
+			protocol, ProtocolHTTPS, ProtocolSSH)
+	}
+}
+
+func NewRepositoryStore(dir string, opts Options) (*RepositoryStore, error) {
+	layout, err := ParseLayout(opts.Layout)
+	if err != nil {
+		return nil, err
+	}
+
+	protocol, err := ParseCloneProtocol(opts.CloneProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	host := opts.Host
+	if host == "" {
+		host = defaultHost
+	}
+
+	sshHost := opts.SSHHost
+	if sshHost == "" {
+		sshHost = host
+	}
+
+	r := &RepositoryStore{
+		dir:      dir,
+		layout:   layout,
+		baseURL:  "https://" + host,
+		protocol: protocol,
+		sshHost:  sshHost,
+		httpsURL: opts.CloneURL,
+	}
+	r.setToken(opts.TokenUser, opts.Token)
+
+	return r, nil
+}
+
+// setToken sets the environment of git to authenticate with the given
+// token. The token is passed as an HTTP header via the environment, so it's
+// neither stored in the repository's config nor part of git's arguments
+// and errors. The header is only sent to the store's base URL. Passing
+// config via the environment requires git 2.31 or later. Config passed by
+// the user the same way is kept.
+func (r *RepositoryStore) setToken(user, token string) {
+	// never prompt for credentials, the sync would hang otherwise
+	r.env = []string{"GIT_TERMINAL_PROMPT=0"}
+	if token == "" {
+		return
+	}
+
+	if user == "" {
+		user = "x-access-token"
+	}
+
+	// the header is appended to the config of the environment, if any
+	n, err := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	if err != nil || n < 0 {
+		n = 0
+	}
+
+	auth := base64.StdEncoding.EncodeToString([]byte(user + ":" + token))
+	r.env = append(r.env,
+		fmt.Sprintf("GIT_CONFIG_COUNT=%d", n+1),
+		fmt.Sprintf("GIT_CONFIG_KEY_%d=http.%s/.extraheader", n, r.baseURL),
+		fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic %s", n, auth),
+	)
+}
+
+// git returns a git client that runs in the given directory.
+func (r *RepositoryStore) git(dir string) *git.Client {
+	return &git.Client{Dir: dir, Env: r.env}
+}
+
+// RepoDir returns the absolute path of the given repository.
+func (r *RepositoryStore) RepoDir(repo *internal.Repository) (string, error) {
+	return r.repoDir(r.layout, repo)
+}
+
+func (r *RepositoryStore) repoDir(layout *Layout, repo *internal.Repository) (string, error) {
+	path, err := layout.Path(repo)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(r.dir, path), nil
 }
 
 // CreateRepo creates a single repository.
 func (r *RepositoryStore) CreateRepo(ctx context.Context, repo *internal.Repository) error {
-	repoDir := filepath.Join(r.dir, repo.Name)
+	repoDir, err := r.RepoDir(repo)
+	if err != nil {
+		return err
+	}
 
 	// do not clone if it exists
 	if _, err := os.Stat(repoDir); err == nil {
@@ -34,10 +189,13 @@ func (r *RepositoryStore) CreateRepo(ctx context.Context, repo *internal.Reposit
 	log.Printf("[DEBUG] cloning repo, owner: %q, name: %q, branch: %q",
 		repo.Owner, repo.Name, repo.Branch)
 
-	g := &git.Client{}
+	if err := os.MkdirAll(filepath.Dir(repoDir), 0o755); err != nil {
+		return err
+	}
 
-	cloneURL := fmt.Sprintf("https://github.com/%s/%s.git", repo.Owner, repo.Name)
-	_, err := g.Run("clone", cloneURL, "--depth=1", repoDir)
+	g := r.git("")
+
+	_, err = g.Run("clone", r.cloneURL(repo), "--depth=1", repoDir)
 	if err != nil {
 		return err
 	}
@@ -47,15 +205,18 @@ func (r *RepositoryStore) CreateRepo(ctx context.Context, repo *internal.Reposit
 
 // UpdateRepo updates a single repository.
 func (r *RepositoryStore) UpdateRepo(ctx context.Context, opts internal.UpdateOptions, repo *internal.Repository) error {
-	repoDir := filepath.Join(r.dir, repo.Name)
+	repoDir, err := r.RepoDir(repo)
+	if err != nil {
+		return err
+	}
 
 	// don't continue if the repo was removed or doesn't exist.
-	_, err := os.Stat(repoDir)
+	_, err = os.Stat(repoDir)
 	if err != nil {
 		return err
 	}
 
-	g := &git.Client{Dir: repoDir}
+	g := r.git(repoDir)
 
 	log.Printf("[DEBUG] updating repo, name: %q, branch: %q, sha: %q (opts: %v)",
 		repo.Nwo, repo.Branch, repo.SHA, opts)
@@ -82,7 +243,7 @@ func (r *RepositoryStore) UpdateRepo(ctx context.Context, opts internal.UpdateOp
 		}
 
 		// TODO(fatih) make sure remote name is indeed 'origin'.
-		if string(branch) == repo.Branch {
+		if strings.TrimSpace(string(branch)) == repo.Branch {
 			if _, err := g.Run("pull", "--rebase", "origin", repo.SHA); err != nil {
 				return err
 			}
@@ -127,6 +288,273 @@ func (r *RepositoryStore) DeleteRepo(ctx context.Context, repo *internal.Reposit
 	log.Printf("[DEBUG]  deleting repo, owner: %q, name: %q, branch: %q",
 		repo.Owner, repo.Name, repo.Branch)
 
-	repoDir := filepath.Join(r.dir, repo.Name)
-	return os.RemoveAll(repoDir)
+	repoDir, err := r.RepoDir(repo)
+	if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(repoDir); err != nil {
+		return err
+	}
+
+	r.removeEmptyParents(repoDir)
+	return nil
+}
+
+// MoveRepo moves a single repository to the location of the renamed
+// repository and points its remote to the new name.
+func (r *RepositoryStore) MoveRepo(ctx context.Context, from, to *internal.Repository) error {
+	fromDir, err := r.RepoDir(from)
+	if err != nil {
+		return err
+	}
+
+	toDir, err := r.RepoDir(to)
+	if err != nil {
+		return err
+	}
+
+	// nothing to move if the repo wasn't cloned yet
+	if _, err := os.Stat(fromDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	log.Printf("[DEBUG] moving repo, from: %q, to: %q", from.Nwo, to.Nwo)
+
+	if fromDir != toDir {
+		if _, err := os.Stat(toDir); err == nil {
+			return fmt.Errorf("can't move %q to %q: %q already exists", from.Nwo, to.Nwo, toDir)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(toDir), 0o755); err != nil {
+			return err
+		}
+
+		if err := os.Rename(fromDir, toDir); err != nil {
+			return err
+		}
+
+		r.removeEmptyParents(fromDir)
+	}
+
+	g := r.git(toDir)
+	_, err = g.Run("remote", "set-url", "origin", r.cloneURL(to))
+	return err
+}
+
+// SwitchBranch fetches the new default branch of a single repository and
+// creates a local branch for it. If the previous default branch is checked
+// out, it switches to the new default branch.
+func (r *RepositoryStore) SwitchBranch(ctx context.Context, repo *internal.Repository, from string) error {
+	repoDir, err := r.RepoDir(repo)
+	if err != nil {
+		return err
+	}
+
+	// nothing to switch if the repo wasn't cloned yet
+	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	log.Printf("[DEBUG] switching default branch, name: %q, from: %q, to: %q",
+		repo.Nwo, from, repo.Branch)
+
+	g := r.git(repoDir)
+
+	// repositories are cloned with --depth=1, which only tracks the default
+	// branch at the time of the clone.
+	if _, err := g.Run("remote", "set-branches", "--add", "origin", repo.Branch); err != nil {
+		return err
+	}
+
+	refspec := fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", repo.Branch, repo.Branch)
+	if _, err := g.Run("fetch", "--depth=1", "origin", refspec); err != nil {
+		return err
+	}
+
+	if _, err := g.Run("remote", "set-head", "origin", repo.Branch); err != nil {
+		return err
+	}
+
+	if _, err := g.Run("rev-parse", "--verify", "--quiet", "refs/heads/"+repo.Branch); err != nil {
+		if _, err := g.Run("branch", "--track", repo.Branch, "origin/"+repo.Branch); err != nil {
+			return err
+		}
+	}
+
+	current, err := g.Run("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(string(current)) != from {
+		return nil
+	}
+
+	_, err = g.Run("checkout", repo.Branch)
+	return err
+}
+
+// MigrateLayout moves the given repositories from the layout they were
+// placed with to the layout of the store. Repositories that were placed
+// before layouts were introduced are assumed to use LayoutFlat.
+func (r *RepositoryStore) MigrateLayout(ctx context.Context, repos []*internal.Repository) error {
+	current := LayoutFlat
+	out, err := os.ReadFile(filepath.Join(r.dir, layoutFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		current = strings.TrimSpace(string(out))
+	}
+
+	if current == r.layout.String() {
+		return nil
+	}
+
+	from, err := ParseLayout(current)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] migrating repositories from layout %q to %q", from, r.layout)
+
+	// repositories are first moved into a staging directory and then to
+	// their final location. This prevents collisions between the old and
+	// new paths, i.e: "fatih" -> "fatih/fatih".
+	staging := filepath.Join(r.dir, migrateDir)
+	if err := os.MkdirAll(staging, 0o755); err != nil {
+		return err
+	}
+
+	staged := make(map[*internal.Repository]string, len(repos))
+	for _, repo := range repos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		oldDir, err := r.repoDir(from, repo)
+		if err != nil {
+			return err
+		}
+
+		newDir, err := r.RepoDir(repo)
+		if err != nil {
+			return err
+		}
+
+		stageDir := filepath.Join(staging, strconv.FormatInt(repo.ID, 10))
+
+		// a previous migration might have been interrupted after staging
+		if _, err := os.Stat(stageDir); err == nil {
+			staged[repo] = stageDir
+			continue
+		}
+
+		if oldDir == newDir {
+			continue
+		}
+
+		if _, err := os.Stat(oldDir); os.IsNotExist(err) {
+			continue // not cloned yet
+		}
+
+		log.Printf("[DEBUG] staging repo for migration, nwo: %q, dir: %q", repo.Nwo, oldDir)
+		if err := os.Rename(oldDir, stageDir); err != nil {
+			return err
+		}
+
+		r.removeEmptyParents(oldDir)
+		staged[repo] = stageDir
+	}
+
+	for repo, stageDir := range staged {
+		newDir, err := r.RepoDir(repo)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(newDir); err == nil {
+			return fmt.Errorf("migrating %q failed: %q already exists (staged copy: %q)",
+				repo.Nwo, newDir, stageDir)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(newDir), 0o755); err != nil {
+			return err
+		}
+
+		log.Printf("[DEBUG] moving repo to new layout, nwo: %q, dir: %q", repo.Nwo, newDir)
+		if err := os.Rename(stageDir, newDir); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(staging); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(r.dir, layoutFile), []byte(r.layout.String()+"\n"), 0o644)
+}
+
+// removeEmptyParents removes the empty parent directories of the given
+// repository directory, up to the repositories directory.
+func (r *RepositoryStore) removeEmptyParents(repoDir string) {
+	for dir := filepath.Dir(repoDir); dir != r.dir && strings.HasPrefix(dir, r.dir); dir = filepath.Dir(dir) {
+		// os.Remove fails for non-empty directories
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+// cloneURL returns the URL to clone the given repository.
+func (r *RepositoryStore) cloneURL(repo *internal.Repository) string {
+	if r.protocol == ProtocolSSH {
+		host := r.sshHost
+		if !strings.Contains(host, "@") {
+			host = "git@" + host
+		}
+		return fmt.Sprintf("%s:%s/%s.git", host, repo.Owner, repo.Name)
+	}
+
+	if r.httpsURL != nil {
+		return r.httpsURL(repo)
+	}
+
+	return fmt.Sprintf("%s/%s/%s.git", r.baseURL, repo.Owner, repo.Name)
+}
+
+// RewriteRemote sets the origin URL of a single repository to the clone URL
+// of the store, i.e: after the clone protocol is changed. It reports whether
+// the URL was changed.
+func (r *RepositoryStore) RewriteRemote(ctx context.Context, repo *internal.Repository) (bool, error) {
+	repoDir, err := r.RepoDir(repo)
+	if err != nil {
+		return false, err
+	}
+
+	// nothing to rewrite if the repo wasn't cloned yet
+	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	g := r.git(repoDir)
+
+	current, err := g.Run("remote", "get-url", "origin")
+	if err != nil {
+		return false, err
+	}
+
+	url := r.cloneURL(repo)
+	if strings.TrimSpace(string(current)) == url {
+		return false, nil
+	}
+
+	log.Printf("[DEBUG] rewriting remote, name: %q, url: %q", repo.Nwo, url)
+
+	if _, err := g.Run("remote", "set-url", "origin", url); err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
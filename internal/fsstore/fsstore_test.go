@@ -0,0 +1,213 @@
+package fsstore
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/git"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRepositoryStore_SwitchBranch(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// remote repository, which renames its default branch later
+	remote := filepath.Join(c.Mkdir(), "vim-go.git")
+	runGit(c, "", "init", "--bare", "--initial-branch=master", remote)
+
+	work := c.Mkdir()
+	runGit(c, work, "clone", remote, ".")
+	runGit(c, work, "-c", "user.name=test", "-c", "user.email=test@example.com",
+		"commit", "--allow-empty", "-m", "initial")
+	runGit(c, work, "push", "origin", "master")
+
+	dir := c.Mkdir()
+	runGit(c, "", "clone", "--depth=1", "file://"+remote, filepath.Join(dir, "vim-go"))
+
+	runGit(c, work, "push", "origin", "master:main")
+	runGit(c, remote, "symbolic-ref", "HEAD", "refs/heads/main")
+
+	store, err := NewRepositoryStore(dir, Options{})
+	c.Assert(err, qt.IsNil)
+
+	repo := &internal.Repository{
+		Nwo:    "fatih/vim-go",
+		Owner:  "fatih",
+		Name:   "vim-go",
+		Branch: "main",
+	}
+
+	err = store.SwitchBranch(ctx, repo, "master")
+	c.Assert(err, qt.IsNil)
+
+	out := runGit(c, filepath.Join(dir, "vim-go"), "rev-parse", "--abbrev-ref", "HEAD")
+	c.Assert(out, qt.Equals, "main")
+
+	out = runGit(c, filepath.Join(dir, "vim-go"), "rev-parse", "--abbrev-ref", "main@{upstream}")
+	c.Assert(out, qt.Equals, "origin/main")
+}
+
+func TestRepositoryStore_CreateRepo_token(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// private remote repository, served via git's smart HTTP protocol
+	root := c.Mkdir()
+	remote := filepath.Join(root, "fatih", "secret.git")
+	runGit(c, "", "init", "--bare", "--initial-branch=main", remote)
+
+	work := c.Mkdir()
+	runGit(c, work, "clone", remote, ".")
+	runGit(c, work, "-c", "user.name=test", "-c", "user.email=test@example.com",
+		"commit", "--allow-empty", "-m", "initial")
+	runGit(c, work, "push", "origin", "main")
+
+	gitPath, err := exec.LookPath("git")
+	c.Assert(err, qt.IsNil)
+
+	const token = "s3cr3t"
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("x-access-token:"+token))
+
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+
+		Stderr: io.Discard,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != wantAuth {
+			w.Header().Set("WWW-Authenticate", `Basic realm="GitHub"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	c.Cleanup(server.Close)
+
+	repo := &internal.Repository{
+		Nwo:    "fatih/secret",
+		Owner:  "fatih",
+		Name:   "secret",
+		Branch: "main",
+	}
+
+	newStore := func(token string) *RepositoryStore {
+		store, err := NewRepositoryStore(c.Mkdir(), Options{})
+		c.Assert(err, qt.IsNil)
+		store.baseURL = server.URL
+		store.setToken("", token)
+		return store
+	}
+
+	// without a token, cloning fails instead of prompting for credentials
+	err = newStore("").CreateRepo(ctx, repo)
+	c.Assert(err, qt.Not(qt.IsNil))
+
+	store := newStore(token)
+	err = store.CreateRepo(ctx, repo)
+	c.Assert(err, qt.IsNil)
+
+	repoDir, err := store.RepoDir(repo)
+	c.Assert(err, qt.IsNil)
+
+	config, err := os.ReadFile(filepath.Join(repoDir, ".git", "config"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(config), qt.Not(qt.Contains), token)
+	c.Assert(string(config), qt.Not(qt.Contains), wantAuth)
+
+	// errors don't leak the token
+	err = store.CreateRepo(ctx, &internal.Repository{Owner: "fatih", Name: "missing"})
+	c.Assert(err, qt.Not(qt.IsNil))
+	c.Assert(err.Error(), qt.Not(qt.Contains), token)
+	c.Assert(err.Error(), qt.Not(qt.Contains), wantAuth)
+
+	// config passed via the environment by the user is kept
+	c.Setenv("GIT_CONFIG_COUNT", "1")
+	c.Setenv("GIT_CONFIG_KEY_0", "user.name")
+	c.Setenv("GIT_CONFIG_VALUE_0", "gopher")
+
+	store = newStore(token)
+	err = store.CreateRepo(ctx, repo)
+	c.Assert(err, qt.IsNil)
+
+	repoDir, err = store.RepoDir(repo)
+	c.Assert(err, qt.IsNil)
+	out, err := store.git(repoDir).Run("config", "user.name")
+	c.Assert(err, qt.IsNil)
+	c.Assert(strings.TrimSpace(string(out)), qt.Equals, "gopher")
+}
+
+func TestRepositoryStore_RewriteRemote(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	remote := filepath.Join(c.Mkdir(), "vim-go.git")
+	runGit(c, "", "init", "--bare", remote)
+
+	dir := c.Mkdir()
+	runGit(c, "", "clone", remote, filepath.Join(dir, "vim-go"))
+
+	repo := &internal.Repository{
+		Nwo:   "fatih/vim-go",
+		Owner: "fatih",
+		Name:  "vim-go",
+	}
+
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{opts: Options{CloneProtocol: ProtocolSSH}, want: "git@github.com:fatih/vim-go.git"},
+		{opts: Options{CloneProtocol: ProtocolSSH, SSHHost: "github-work"}, want: "git@github-work:fatih/vim-go.git"},
+		{opts: Options{Host: "github.example.com"}, want: "https://github.example.com/fatih/vim-go.git"},
+		{opts: Options{Host: "github.example.com", CloneProtocol: ProtocolSSH}, want: "git@github.example.com:fatih/vim-go.git"},
+		{opts: Options{}, want: "https://github.com/fatih/vim-go.git"},
+	}
+
+	for _, tt := range tests {
+		store, err := NewRepositoryStore(dir, tt.opts)
+		c.Assert(err, qt.IsNil)
+
+		ok, err := store.RewriteRemote(ctx, repo)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ok, qt.IsTrue)
+
+		out := runGit(c, filepath.Join(dir, "vim-go"), "remote", "get-url", "origin")
+		c.Assert(out, qt.Equals, tt.want)
+
+		// the remote is already up to date
+		ok, err = store.RewriteRemote(ctx, repo)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ok, qt.IsFalse)
+	}
+
+	_, err := NewRepositoryStore(dir, Options{CloneProtocol: "ftp"})
+	c.Assert(err, qt.ErrorMatches, `unknown clone protocol "ftp".*`)
+}
+
+func runGit(c *qt.C, dir string, args ...string) string {
+	c.Helper()
+
+	if dir != "" {
+		_, err := os.Stat(dir)
+		c.Assert(err, qt.IsNil)
+	}
+
+	g := &git.Client{Dir: dir}
+	out, err := g.Run(args...)
+	c.Assert(err, qt.IsNil)
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,102 @@
+package fsstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/starhook/internal"
+)
+
+const (
+	// LayoutFlat places all repositories directly inside the repositories
+	// directory, i.e: <dir>/vim-go. Repositories with the same name but
+	// different owners collide with this layout.
+	LayoutFlat = "flat"
+
+	// LayoutOwnerName places repositories inside a directory named after
+	// their owner, i.e: <dir>/fatih/vim-go
+	LayoutOwnerName = "owner/name"
+)
+
+// Layout defines how repositories are placed inside the repositories
+// directory.
+type Layout struct {
+	name string
+	tmpl *template.Template
+}
+
+// ParseLayout parses the given layout. It's either one of the predefined
+// layouts (LayoutFlat, LayoutOwnerName) or a custom template which is executed
+// with an *internal.Repository, such as "{{.Owner}}-{{.Name}}". An empty
+// layout defaults to LayoutFlat.
+func ParseLayout(layout string) (*Layout, error) {
+	var text string
+	switch layout {
+	case "", LayoutFlat:
+		layout = LayoutFlat
+		text = "{{.Name}}"
+	case LayoutOwnerName:
+		text = "{{.Owner}}/{{.Name}}"
+	default:
+		text = layout
+	}
+
+	tmpl, err := template.New("layout").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layout %q: %w", layout, err)
+	}
+
+	l := &Layout{
+		name: layout,
+		tmpl: tmpl,
+	}
+
+	// validate the template with a dummy repository, so errors are catched
+	// early and not during a sync
+	_, err = l.Path(&internal.Repository{
+		Nwo:    "owner/name",
+		Owner:  "owner",
+		Name:   "name",
+		Branch: "main",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid layout %q: %w", layout, err)
+	}
+
+	return l, nil
+}
+
+// String returns the layout as it was defined.
+func (l *Layout) String() string {
+	return l.name
+}
+
+// Path returns the path of the given repository, relative to the
+// repositories directory.
+func (l *Layout) Path(repo *internal.Repository) (string, error) {
+	var buf bytes.Buffer
+	if err := l.tmpl.Execute(&buf, repo); err != nil {
+		return "", err
+	}
+
+	path := strings.TrimSpace(buf.String())
+	if path == "" {
+		return "", errors.New("layout results in an empty path")
+	}
+
+	path = filepath.Clean(filepath.FromSlash(path))
+	if filepath.IsAbs(path) || path == "." || path == ".." ||
+		strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("layout path %q should be relative to the repositories directory", path)
+	}
+
+	if strings.HasPrefix(path, ".starhook") {
+		return "", fmt.Errorf("layout path %q uses a reserved name", path)
+	}
+
+	return path, nil
+}
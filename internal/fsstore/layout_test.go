@@ -0,0 +1,98 @@
+package fsstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fatih/starhook/internal"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestLayout_Path(t *testing.T) {
+	repo := &internal.Repository{
+		Nwo:   "fatih/vim-go",
+		Owner: "fatih",
+		Name:  "vim-go",
+	}
+
+	tests := []struct {
+		layout string
+		want   string
+	}{
+		{layout: "", want: "vim-go"},
+		{layout: LayoutFlat, want: "vim-go"},
+		{layout: LayoutOwnerName, want: filepath.Join("fatih", "vim-go")},
+		{layout: "{{.Owner}}-{{.Name}}", want: "fatih-vim-go"},
+	}
+
+	for _, tt := range tests {
+		c := qt.New(t)
+		l, err := ParseLayout(tt.layout)
+		c.Assert(err, qt.IsNil)
+
+		path, err := l.Path(repo)
+		c.Assert(err, qt.IsNil)
+		c.Assert(path, qt.Equals, tt.want)
+	}
+}
+
+func TestParseLayout_invalid(t *testing.T) {
+	for _, layout := range []string{
+		"{{.Owner",
+		"{{.Unknown}}",
+		"/abs/{{.Name}}",
+		"../{{.Name}}",
+		"   ",
+	} {
+		c := qt.New(t)
+		_, err := ParseLayout(layout)
+		c.Assert(err, qt.Not(qt.IsNil), qt.Commentf("layout: %q", layout))
+	}
+}
+
+func TestRepositoryStore_MigrateLayout(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+	ctx := context.Background()
+
+	repos := []*internal.Repository{
+		{ID: 1, Nwo: "fatih/fatih", Owner: "fatih", Name: "fatih"},
+		{ID: 2, Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go"},
+		{ID: 3, Nwo: "fatih/color", Owner: "fatih", Name: "color"}, // not cloned
+	}
+
+	// repositories cloned with the flat layout
+	for _, name := range []string{"fatih", "vim-go"} {
+		err := os.MkdirAll(filepath.Join(dir, name, ".git"), 0o755)
+		c.Assert(err, qt.IsNil)
+	}
+
+	store, err := NewRepositoryStore(dir, Options{Layout: LayoutOwnerName})
+	c.Assert(err, qt.IsNil)
+
+	err = store.MigrateLayout(ctx, repos)
+	c.Assert(err, qt.IsNil)
+
+	for _, path := range []string{"fatih/fatih/.git", "fatih/vim-go/.git"} {
+		_, err := os.Stat(filepath.Join(dir, path))
+		c.Assert(err, qt.IsNil, qt.Commentf("path: %q", path))
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "fatih", "color"))
+	c.Assert(os.IsNotExist(err), qt.IsTrue)
+
+	// migrating back to flat should restore the previous layout
+	store, err = NewRepositoryStore(dir, Options{Layout: LayoutFlat})
+	c.Assert(err, qt.IsNil)
+
+	err = store.MigrateLayout(ctx, repos)
+	c.Assert(err, qt.IsNil)
+
+	for _, path := range []string{"fatih/.git", "vim-go/.git"} {
+		_, err := os.Stat(filepath.Join(dir, path))
+		c.Assert(err, qt.IsNil, qt.Commentf("path: %q", path))
+	}
+}
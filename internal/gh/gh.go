@@ -3,18 +3,28 @@ package gh
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/fatih/starhook/internal"
+
 	"github.com/google/go-github/v39/github"
 	"golang.org/x/oauth2"
 )
 
-var ErrBranchNotFound = errors.New("branch not found")
+var ErrBranchNotFound = internal.ErrBranchNotFound
+
+type Branch = internal.Branch
 
-type Branch struct {
-	SHA       string
-	UpdatedAt time.Time
+// RepoRef references a repository and its default branch.
+type RepoRef struct {
+	Owner  string
+	Name   string
+	Branch string
 }
 
 type searchService interface {
@@ -25,12 +35,44 @@ type searchService interface {
 type repositoryService interface {
 	// GetBranch gets the specified branch for a repository.
 	GetBranch(ctx context.Context, owner, repo, branch string, followRedirects bool) (*github.Branch, *github.Response, error)
+
+	// Get fetches a repository.
+	Get(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error)
+
+	// List lists the repositories of a user. Passing the empty string lists
+	// the repositories of the authenticated user.
+	List(ctx context.Context, user string, opts *github.RepositoryListOptions) ([]*github.Repository, *github.Response, error)
+
+	// ListByOrg lists the repositories of an organization.
+	ListByOrg(ctx context.Context, org string, opts *github.RepositoryListByOrgOptions) ([]*github.Repository, *github.Response, error)
+}
+
+type activityService interface {
+	// ListStarred lists the repositories starred by a user. Passing the
+	// empty string lists the starred repositories of the authenticated user.
+	ListStarred(ctx context.Context, user string, opts *github.ActivityListStarredOptions) ([]*github.StarredRepository, *github.Response, error)
+}
+
+type teamService interface {
+	// ListTeamReposBySlug lists the repositories of a team.
+	ListTeamReposBySlug(ctx context.Context, org, slug string, opts *github.ListOptions) ([]*github.Repository, *github.Response, error)
 }
 
 // Client is responsible of searching and cloning the repositories
 type Client struct {
 	Search       searchService
 	Repositories repositoryService
+	Activity     activityService
+	Teams        teamService
+	GraphQL      graphqlService
+
+	calls int64 // number of API requests, accessed atomically
+
+	limitsMu sync.Mutex
+	limits   map[string]*rateLimiter // rate limiters by category
+
+	// sleep is used to wait for rate limits, replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
 }
 
 func NewClient(ctx context.Context, token string) *Client {
@@ -39,40 +81,86 @@ func NewClient(ctx context.Context, token string) *Client {
 	)
 
 	tc := oauth2.NewClient(ctx, ts)
-	ghClient := github.NewClient(tc)
+	return newClient(github.NewClient(tc))
+}
+
+// NewEnterpriseClient returns a client for a GitHub Enterprise Server
+// instance with the given API URL, i.e: "https://github.example.com/api/v3/".
+func NewEnterpriseClient(ctx context.Context, token, apiURL string) (*Client, error) {
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)
+
+	tc := oauth2.NewClient(ctx, ts)
+	ghClient, err := github.NewEnterpriseClient(apiURL, apiURL, tc)
+	if err != nil {
+		return nil, err
+	}
+
+	// the GraphQL API is served from "/api/graphql" instead of
+	// "/api/v3/graphql".
+	graphqlURL := *ghClient.BaseURL
+	graphqlURL.Path = strings.TrimSuffix(graphqlURL.Path, "v3/") + "graphql"
+
+	c := newClient(ghClient)
+	c.GraphQL = &graphqlClient{
+		client: ghClient,
+		url:    graphqlURL.String(),
+	}
+
+	return c, nil
+}
 
+// newClient returns a client that uses the given go-github client.
+func newClient(ghClient *github.Client) *Client {
 	return &Client{
 		Search:       ghClient.Search,
 		Repositories: ghClient.Repositories,
+		Activity:     ghClient.Activity,
+		Teams:        ghClient.Teams,
+		GraphQL: &graphqlClient{
+			client: ghClient,
+			url:    "graphql",
+		},
 	}
 }
 
-// FetchRepos fetches the repositories for the given query
-func (c *Client) FetchRepos(ctx context.Context, query string) ([]*github.Repository, error) {
-	opts := &github.SearchOptions{
-		ListOptions: github.ListOptions{PerPage: 50},
+// FetchRepos fetches the repositories matching any of the given queries,
+// without duplicates. Queries that match more repositories than the search
+// API returns are split into disjoint slices by their creation date.
+func (c *Client) FetchRepos(ctx context.Context, queries ...string) ([]*github.Repository, error) {
+	s := &repoSearch{
+		client: c,
+		seen:   make(map[int64]bool),
 	}
 
-	var repos []*github.Repository
-	for {
-		res, resp, err := c.Search.Repositories(ctx, query, opts)
-		if err != nil {
+	for _, query := range queries {
+		s.query = query
+		s.incomplete = false
+
+		if err := s.fetch(ctx, time.Time{}, time.Time{}); err != nil {
 			return nil, err
 		}
 
-		repos = append(repos, res.Repositories...)
-		if resp.NextPage == 0 {
-			break
+		if s.incomplete {
+			log.Printf("[WARN] query %q matches more than %d repositories, some repositories are missing", query, searchResultLimit)
 		}
-
-		opts.Page = resp.NextPage
 	}
 
-	return repos, nil
+	return s.repos, nil
 }
 
 func (c *Client) Branch(ctx context.Context, owner, name, branch string) (*Branch, error) {
-	res, resp, err := c.Repositories.GetBranch(ctx, owner, name, branch, true)
+	var (
+		res  *github.Branch
+		resp *github.Response
+	)
+
+	err := c.do(ctx, categoryCore, func() (*github.Response, error) {
+		var err error
+		res, resp, err = c.Repositories.GetBranch(ctx, owner, name, branch, true)
+		return resp, err
+	})
 	if err != nil {
 		if resp != nil && resp.StatusCode == http.StatusNotFound {
 			return nil, ErrBranchNotFound
@@ -84,7 +172,107 @@ func (c *Client) Branch(ctx context.Context, owner, name, branch string) (*Branc
 	updatedAt := res.GetCommit().GetCommit().GetCommitter().GetDate()
 	sha := res.GetCommit().GetSHA()
 	return &Branch{
+		Name:      res.GetName(),
 		SHA:       sha,
 		UpdatedAt: updatedAt,
 	}, nil
 }
+
+// DefaultBranches returns the head of the default branch of the given
+// repositories. The branches are resolved in batches via the GraphQL API. The
+// value is nil for repositories without a default branch, such as empty
+// repositories. Repositories that couldn't be resolved, i.e: because the
+// GraphQL API is not available, are not part of the returned map and should
+// be resolved one by one with Branch.
+func (c *Client) DefaultBranches(ctx context.Context, repos []RepoRef) (map[RepoRef]*Branch, error) {
+	branches := make(map[RepoRef]*Branch, len(repos))
+	if c.GraphQL == nil {
+		return branches, nil
+	}
+
+	for start := 0; start < len(repos); start += graphqlBatchSize {
+		end := start + graphqlBatchSize
+		if end > len(repos) {
+			end = len(repos)
+		}
+
+		err := c.defaultBranches(ctx, repos[start:end], branches)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			log.Printf("[WARN] resolving branches via GraphQL failed, falling back to the REST API: %s", err)
+		}
+	}
+
+	return branches, nil
+}
+
+// defaultBranches resolves the default branches of the given repositories
+// with a single GraphQL query and adds them to branches.
+func (c *Client) defaultBranches(ctx context.Context, repos []RepoRef, branches map[RepoRef]*Branch) error {
+	type repository struct {
+		DefaultBranchRef *struct {
+			Name   string `json:"name"`
+			Target struct {
+				OID           string    `json:"oid"`
+				CommittedDate time.Time `json:"committedDate"`
+			} `json:"target"`
+		} `json:"defaultBranchRef"`
+	}
+
+	var (
+		params  []string
+		fields  []string
+		vars    = make(map[string]interface{}, len(repos)*2)
+		aliases = make(map[string]RepoRef, len(repos))
+	)
+
+	for i, repo := range repos {
+		alias := fmt.Sprintf("r%d", i)
+		aliases[alias] = repo
+
+		vars[fmt.Sprintf("o%d", i)] = repo.Owner
+		vars[fmt.Sprintf("n%d", i)] = repo.Name
+		params = append(params, fmt.Sprintf("$o%d: String!, $n%d: String!", i, i))
+		fields = append(fields, fmt.Sprintf(
+			"%s: repository(owner: $o%d, name: $n%d) { defaultBranchRef { name target { oid ... on Commit { committedDate } } } }",
+			alias, i, i))
+	}
+
+	query := fmt.Sprintf("query(%s) {\n%s\n}", strings.Join(params, ", "), strings.Join(fields, "\n"))
+
+	var data map[string]*repository
+	err := c.do(ctx, categoryGraphQL, func() (*github.Response, error) {
+		return c.GraphQL.Query(ctx, query, vars, &data)
+	})
+	if err != nil {
+		// missing repositories are reported as errors, but the data of all
+		// other repositories is still valid.
+		var gqlErr *GraphQLError
+		if !errors.As(err, &gqlErr) || !gqlErr.onlyNotFound() {
+			return err
+		}
+	}
+
+	for alias, repo := range aliases {
+		res, ok := data[alias]
+		if !ok {
+			continue
+		}
+
+		if res == nil || res.DefaultBranchRef == nil {
+			branches[repo] = nil
+			continue
+		}
+
+		branches[repo] = &Branch{
+			Name:      res.DefaultBranchRef.Name,
+			SHA:       res.DefaultBranchRef.Target.OID,
+			UpdatedAt: res.DefaultBranchRef.Target.CommittedDate,
+		}
+	}
+
+	return nil
+}
@@ -2,9 +2,14 @@ package gh
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
 	"testing"
 	"time"
 
+	"github.com/fatih/starhook/internal/apitest"
 	"github.com/google/go-github/v39/github"
 
 	qt "github.com/frankban/quicktest"
@@ -17,6 +22,37 @@ func TestNewClient(t *testing.T) {
 	c.Assert(client, qt.Not(qt.IsNil), qt.Commentf("client should be not nil"))
 }
 
+func TestNewEnterpriseClient(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var paths []string
+	server := apitest.NewServer(c, "Authorization", "Bearer token", func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+
+		if r.URL.Path == "/api/graphql" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{}})
+			return
+		}
+
+		json.NewEncoder(w).Encode(&github.RepositoriesSearchResult{
+			Repositories: []*github.Repository{{ID: github.Int64(1)}},
+		})
+	})
+
+	client, err := NewEnterpriseClient(ctx, "token", server.URL)
+	c.Assert(err, qt.IsNil)
+
+	repos, err := client.FetchRepos(ctx, "org:bigcorp")
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+
+	_, err = client.GraphQL.Query(ctx, "query { viewer { login } }", nil, &struct{}{})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(paths, qt.DeepEquals, []string{"/api/v3/search/repositories", "/api/graphql"})
+}
+
 func TestClient_FetchRepos(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
@@ -105,6 +141,18 @@ func (m *mockRepositoriesService) GetBranch(ctx context.Context, owner, repo, br
 	return nil, &github.Response{}, nil
 }
 
+func (m *mockRepositoriesService) Get(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	return nil, &github.Response{}, nil
+}
+
+func (m *mockRepositoriesService) List(ctx context.Context, user string, opts *github.RepositoryListOptions) ([]*github.Repository, *github.Response, error) {
+	return nil, &github.Response{}, nil
+}
+
+func (m *mockRepositoriesService) ListByOrg(ctx context.Context, org string, opts *github.RepositoryListByOrgOptions) ([]*github.Repository, *github.Response, error) {
+	return nil, &github.Response{}, nil
+}
+
 type mockSearchService struct {
 	RepositoriesFunc    func(ctx context.Context, query string, opt *github.SearchOptions) (*github.RepositoriesSearchResult, *github.Response, error)
 	RepositoriesInvoked bool
@@ -117,3 +165,97 @@ func (m *mockSearchService) Repositories(ctx context.Context, query string, opt
 	}
 	return nil, &github.Response{}, nil
 }
+
+func TestClient_DefaultBranches(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	committedDate := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	var queries int
+	client := newGraphQLTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.URL.Path, qt.Equals, "/graphql")
+		queries++
+
+		var req struct {
+			Query     string            `json:"query"`
+			Variables map[string]string `json:"variables"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		c.Assert(err, qt.IsNil)
+
+		data := make(map[string]interface{})
+		var errs []map[string]interface{}
+		for i := 0; i < len(req.Variables)/2; i++ {
+			alias := fmt.Sprintf("r%d", i)
+			name := req.Variables[fmt.Sprintf("n%d", i)]
+
+			switch name {
+			case "missing":
+				data[alias] = nil
+				errs = append(errs, map[string]interface{}{"type": "NOT_FOUND", "message": "not found"})
+			case "empty":
+				data[alias] = map[string]interface{}{"defaultBranchRef": nil}
+			default:
+				data[alias] = map[string]interface{}{
+					"defaultBranchRef": map[string]interface{}{
+						"name": "main",
+						"target": map[string]interface{}{
+							"oid":           "sha-" + name,
+							"committedDate": committedDate,
+						},
+					},
+				}
+			}
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data, "errors": errs})
+	})
+
+	var refs []RepoRef
+	for i := 0; i < graphqlBatchSize+10; i++ {
+		refs = append(refs, RepoRef{Owner: "fatih", Name: fmt.Sprintf("repo%d", i), Branch: "master"})
+	}
+	missing := RepoRef{Owner: "fatih", Name: "missing", Branch: "main"}
+	empty := RepoRef{Owner: "fatih", Name: "empty", Branch: "main"}
+	refs = append(refs, missing, empty)
+
+	branches, err := client.DefaultBranches(ctx, refs)
+	c.Assert(err, qt.IsNil)
+	c.Assert(queries, qt.Equals, 2, qt.Commentf("repos should be resolved in batches"))
+	c.Assert(branches, qt.HasLen, len(refs))
+
+	c.Assert(branches[refs[0]], qt.DeepEquals, &Branch{
+		Name:      "main",
+		SHA:       "sha-repo0",
+		UpdatedAt: committedDate,
+	})
+	c.Assert(branches[missing], qt.IsNil)
+	c.Assert(branches[empty], qt.IsNil)
+}
+
+func TestClient_DefaultBranches_fallback(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	client := newGraphQLTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	branches, err := client.DefaultBranches(ctx, []RepoRef{{Owner: "fatih", Name: "vim-go"}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(branches, qt.HasLen, 0, qt.Commentf("unresolved repos should be resolved with the REST API"))
+}
+
+// newGraphQLTestClient returns a client that sends its GraphQL queries to a
+// stand-in GitHub API.
+func newGraphQLTestClient(c *qt.C, handler http.HandlerFunc) *Client {
+	server := apitest.NewServer(c, "", "", handler)
+
+	ghClient := github.NewClient(nil)
+	ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+
+	return &Client{
+		GraphQL: &graphqlClient{client: ghClient, url: "graphql"},
+	}
+}
@@ -0,0 +1,108 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// graphqlBatchSize is the number of repositories resolved with a single
+// GraphQL query. GitHub limits the number of nodes a single query can
+// request, hence a large number of repositories is split into batches.
+const graphqlBatchSize = 50
+
+type graphqlService interface {
+	// Query executes the given GraphQL query and decodes the returned data
+	// into v. If the response contains errors, the partial data is decoded
+	// and a *GraphQLError is returned.
+	Query(ctx context.Context, query string, variables map[string]interface{}, v interface{}) (*github.Response, error)
+}
+
+// GraphQLError is returned if a GraphQL query returns errors.
+type GraphQLError struct {
+	Errors []struct {
+		Type    string        `json:"type"`
+		Message string        `json:"message"`
+		Path    []interface{} `json:"path"`
+	}
+}
+
+func (e *GraphQLError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Message)
+	}
+
+	return fmt.Sprintf("graphql query failed: %s", strings.Join(msgs, ", "))
+}
+
+// hasType reports whether any of the errors is of the given type.
+func (e *GraphQLError) hasType(typ string) bool {
+	for _, err := range e.Errors {
+		if err.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyNotFound reports whether all errors are caused by missing resources.
+func (e *GraphQLError) onlyNotFound() bool {
+	for _, err := range e.Errors {
+		if err.Type != "NOT_FOUND" {
+			return false
+		}
+	}
+	return true
+}
+
+// graphqlClient executes GraphQL queries via the given GitHub client, which
+// takes care of authentication.
+type graphqlClient struct {
+	client *github.Client
+	url    string // relative to the client's base URL, or absolute
+}
+
+func (g *graphqlClient) Query(ctx context.Context, query string, variables map[string]interface{}, v interface{}) (*github.Response, error) {
+	body := map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	}
+
+	req, err := g.client.NewRequest("POST", g.url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors json.RawMessage `json:"errors"`
+	}
+
+	res, err := g.client.Do(ctx, req, &resp)
+	if err != nil {
+		return res, err
+	}
+
+	if len(resp.Data) != 0 && v != nil {
+		if err := json.Unmarshal(resp.Data, v); err != nil {
+			return res, err
+		}
+	}
+
+	if len(resp.Errors) != 0 && string(resp.Errors) != "null" {
+		gqlErr := &GraphQLError{}
+		if err := json.Unmarshal(resp.Errors, &gqlErr.Errors); err != nil {
+			return res, err
+		}
+
+		if len(gqlErr.Errors) != 0 {
+			return res, gqlErr
+		}
+	}
+
+	return res, nil
+}
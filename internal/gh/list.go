@@ -0,0 +1,199 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// listPerPage is the page size of the listing endpoints.
+const listPerPage = 100
+
+// ListOrgRepos lists all repositories of the given organization, including
+// private and internal repositories the token has access to.
+func (c *Client) ListOrgRepos(ctx context.Context, org string) ([]*github.Repository, error) {
+	opts := &github.RepositoryListByOrgOptions{
+		Type:        "all",
+		ListOptions: github.ListOptions{PerPage: listPerPage},
+	}
+
+	var repos []*github.Repository
+	for {
+		var (
+			res  []*github.Repository
+			resp *github.Response
+		)
+
+		err := c.do(ctx, categoryCore, func() (*github.Response, error) {
+			var err error
+			res, resp, err = c.Repositories.ListByOrg(ctx, org, opts)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		repos = append(repos, res...)
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+
+		opts.Page = resp.NextPage
+	}
+}
+
+// ListUserRepos lists all repositories owned by the given user. If user is
+// empty, the repositories of the authenticated user are listed, including
+// the private ones.
+func (c *Client) ListUserRepos(ctx context.Context, user string) ([]*github.Repository, error) {
+	opts := &github.RepositoryListOptions{
+		ListOptions: github.ListOptions{PerPage: listPerPage},
+	}
+
+	// the type and affiliation parameters can't be used together
+	if user == "" {
+		opts.Affiliation = "owner"
+	} else {
+		opts.Type = "owner"
+	}
+
+	var repos []*github.Repository
+	for {
+		var (
+			res  []*github.Repository
+			resp *github.Response
+		)
+
+		err := c.do(ctx, categoryCore, func() (*github.Response, error) {
+			var err error
+			res, resp, err = c.Repositories.List(ctx, user, opts)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		repos = append(repos, res...)
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+
+		opts.Page = resp.NextPage
+	}
+}
+
+// ListStarred lists all repositories starred by the given user. If user is
+// empty, the repositories starred by the authenticated user are listed.
+func (c *Client) ListStarred(ctx context.Context, user string) ([]*github.Repository, error) {
+	opts := &github.ActivityListStarredOptions{
+		ListOptions: github.ListOptions{PerPage: listPerPage},
+	}
+
+	var repos []*github.Repository
+	for {
+		var (
+			res  []*github.StarredRepository
+			resp *github.Response
+		)
+
+		err := c.do(ctx, categoryCore, func() (*github.Response, error) {
+			var err error
+			res, resp, err = c.Activity.ListStarred(ctx, user, opts)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, starred := range res {
+			if starred.Repository != nil {
+				repos = append(repos, starred.Repository)
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+
+		opts.Page = resp.NextPage
+	}
+}
+
+// ListTeamRepos lists all repositories of the team with the given slug.
+func (c *Client) ListTeamRepos(ctx context.Context, org, slug string) ([]*github.Repository, error) {
+	opts := &github.ListOptions{PerPage: listPerPage}
+
+	var repos []*github.Repository
+	for {
+		var (
+			res  []*github.Repository
+			resp *github.Response
+		)
+
+		err := c.do(ctx, categoryCore, func() (*github.Response, error) {
+			var err error
+			res, resp, err = c.Teams.ListTeamReposBySlug(ctx, org, slug, opts)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		repos = append(repos, res...)
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+
+		opts.Page = resp.NextPage
+	}
+}
+
+// GetRepos fetches the given repositories, in the form of "owner/name".
+// Repositories that don't exist, or the token has no access to, are
+// skipped.
+func (c *Client) GetRepos(ctx context.Context, nwos []string) ([]*github.Repository, error) {
+	var repos []*github.Repository
+	for _, nwo := range nwos {
+		owner, name, ok := splitNwo(nwo)
+		if !ok {
+			return nil, fmt.Errorf("invalid repository %q, should be in the form of 'owner/name'", nwo)
+		}
+
+		var (
+			repo *github.Repository
+			resp *github.Response
+		)
+
+		err := c.do(ctx, categoryCore, func() (*github.Response, error) {
+			var err error
+			repo, resp, err = c.Repositories.Get(ctx, owner, name)
+			return resp, err
+		})
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				log.Printf("[WARN] skipping %q, repository not found", nwo)
+				continue
+			}
+
+			return nil, err
+		}
+
+		repos = append(repos, repo)
+	}
+
+	return repos, nil
+}
+
+// splitNwo splits the given "owner/name" into its parts.
+func splitNwo(nwo string) (owner, name string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(nwo), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,120 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v39/github"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// paginate writes the given page of items and links to the next page, like
+// the GitHub API does.
+func paginate(w http.ResponseWriter, r *http.Request, pages ...interface{}) {
+	page := 1
+	fmt.Sscan(r.URL.Query().Get("page"), &page)
+
+	if page < len(pages) {
+		w.Header().Set("Link", fmt.Sprintf(`<%s?page=%d>; rel="next"`, r.URL.Path, page+1))
+	}
+
+	json.NewEncoder(w).Encode(pages[page-1])
+}
+
+func TestClient_ListOrgRepos(t *testing.T) {
+	c := qt.New(t)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, qt.Equals, "/orgs/bigcorp/repos")
+		c.Check(r.URL.Query().Get("type"), qt.Equals, "all")
+
+		paginate(w, r,
+			[]*github.Repository{{ID: github.Int64(1)}, {ID: github.Int64(2)}},
+			[]*github.Repository{{ID: github.Int64(3)}},
+		)
+	})
+
+	repos, err := client.ListOrgRepos(context.Background(), "bigcorp")
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 3)
+	c.Assert(client.Calls(), qt.Equals, int64(2))
+}
+
+func TestClient_ListUserRepos(t *testing.T) {
+	c := qt.New(t)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		// the authenticated user's private repositories are only listed
+		// with the affiliation parameter.
+		c.Check(r.URL.Path, qt.Equals, "/user/repos")
+		c.Check(r.URL.Query().Get("affiliation"), qt.Equals, "owner")
+		c.Check(r.URL.Query().Get("type"), qt.Equals, "")
+
+		paginate(w, r, []*github.Repository{{ID: github.Int64(1)}})
+	})
+
+	repos, err := client.ListUserRepos(context.Background(), "")
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+}
+
+func TestClient_ListStarred(t *testing.T) {
+	c := qt.New(t)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, qt.Equals, "/users/fatih/starred")
+
+		paginate(w, r,
+			[]*github.StarredRepository{{Repository: &github.Repository{ID: github.Int64(1)}}},
+			[]*github.StarredRepository{{Repository: &github.Repository{ID: github.Int64(2)}}},
+		)
+	})
+
+	repos, err := client.ListStarred(context.Background(), "fatih")
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 2)
+	c.Assert(repos[1].GetID(), qt.Equals, int64(2))
+}
+
+func TestClient_ListTeamRepos(t *testing.T) {
+	c := qt.New(t)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, qt.Equals, "/orgs/bigcorp/teams/platform/repos")
+
+		paginate(w, r, []*github.Repository{{ID: github.Int64(1)}})
+	})
+
+	repos, err := client.ListTeamRepos(context.Background(), "bigcorp", "platform")
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+}
+
+func TestClient_GetRepos(t *testing.T) {
+	c := qt.New(t)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/fatih/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Not Found"})
+			return
+		}
+
+		c.Check(r.URL.Path, qt.Equals, "/repos/fatih/color")
+		json.NewEncoder(w).Encode(&github.Repository{ID: github.Int64(1)})
+	})
+
+	ctx := context.Background()
+
+	// missing repositories are skipped
+	repos, err := client.GetRepos(ctx, []string{"fatih/color", "fatih/missing"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+
+	_, err = client.GetRepos(ctx, []string{"color"})
+	c.Assert(err, qt.ErrorMatches, `invalid repository "color".*`)
+}
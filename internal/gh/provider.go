@@ -0,0 +1,137 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fatih/starhook/internal"
+
+	"github.com/google/go-github/v39/github"
+)
+
+var _ internal.Provider = (*Provider)(nil)
+
+// Provider fetches repositories from GitHub.
+type Provider struct {
+	client *Client
+	host   string
+}
+
+// NewProvider returns a provider that uses the given client. The host is
+// used to build the clone URLs, i.e: "github.com".
+func NewProvider(client *Client, host string) *Provider {
+	return &Provider{
+		client: client,
+		host:   host,
+	}
+}
+
+// Calls returns the number of API requests made by the provider.
+func (p *Provider) Calls() int64 {
+	return p.client.Calls()
+}
+
+// ListRepos lists the repositories of the given source.
+func (p *Provider) ListRepos(ctx context.Context, source *internal.Source) ([]*internal.Repository, error) {
+	var (
+		repos []*github.Repository
+		err   error
+	)
+
+	switch source.Kind {
+	case internal.SourceSearch:
+		repos, err = p.client.FetchRepos(ctx, source.Query...)
+	case internal.SourceOrg:
+		repos, err = p.client.ListOrgRepos(ctx, source.Org)
+	case internal.SourceUser:
+		repos, err = p.client.ListUserRepos(ctx, source.User)
+	case internal.SourceStarred:
+		repos, err = p.client.ListStarred(ctx, source.User)
+	case internal.SourceTeam:
+		repos, err = p.client.ListTeamRepos(ctx, source.Org, source.Team)
+	case internal.SourceList:
+		repos, err = p.client.GetRepos(ctx, source.Repos)
+	default:
+		return nil, fmt.Errorf("unknown source kind %q", source.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*internal.Repository, 0, len(repos))
+	for _, repo := range repos {
+		owner := repo.GetOwner().GetLogin()
+		name := repo.GetName()
+
+		out = append(out, &internal.Repository{
+			RemoteID: repo.GetID(),
+			Nwo:      fmt.Sprintf("%s/%s", owner, name),
+			Owner:    owner,
+			Name:     name,
+			Branch:   repo.GetDefaultBranch(),
+			Metadata: metadata(repo),
+		})
+	}
+
+	return out, nil
+}
+
+// metadata returns the metadata of the given repository.
+func metadata(repo *github.Repository) internal.Metadata {
+	visibility := repo.GetVisibility()
+	if visibility == "" {
+		// only returned by newer GitHub Enterprise Server versions
+		visibility = internal.VisibilityPublic
+		if repo.GetPrivate() {
+			visibility = internal.VisibilityPrivate
+		}
+	}
+
+	return internal.Metadata{
+		Language:    repo.GetLanguage(),
+		Topics:      repo.Topics,
+		Description: repo.GetDescription(),
+		Visibility:  visibility,
+		Fork:        repo.GetFork(),
+		Archived:    repo.GetArchived(),
+		Size:        int64(repo.GetSize()),
+		Stars:       int64(repo.GetStargazersCount()),
+		PushedAt:    repo.GetPushedAt().Time.UTC(),
+		HTMLURL:     repo.GetHTMLURL(),
+	}
+}
+
+// DefaultBranches returns the heads of the default branches of the given
+// repositories, resolved in batches via the GraphQL API.
+func (p *Provider) DefaultBranches(ctx context.Context, repos []*internal.Repository) (map[string]*internal.Branch, error) {
+	refs := make([]RepoRef, 0, len(repos))
+	for _, repo := range repos {
+		refs = append(refs, RepoRef{
+			Owner:  repo.Owner,
+			Name:   repo.Name,
+			Branch: repo.Branch,
+		})
+	}
+
+	heads, err := p.client.DefaultBranches(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	branches := make(map[string]*internal.Branch, len(heads))
+	for ref, branch := range heads {
+		branches[ref.Owner+"/"+ref.Name] = branch
+	}
+
+	return branches, nil
+}
+
+// Branch returns the head of the given branch of a repository.
+func (p *Provider) Branch(ctx context.Context, repo *internal.Repository, branch string) (*internal.Branch, error) {
+	return p.client.Branch(ctx, repo.Owner, repo.Name, branch)
+}
+
+// CloneURL returns the HTTPS URL to clone the given repository.
+func (p *Provider) CloneURL(repo *internal.Repository) string {
+	return fmt.Sprintf("https://%s/%s/%s.git", p.host, repo.Owner, repo.Name)
+}
@@ -0,0 +1,104 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+
+	"github.com/google/go-github/v39/github"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestProvider_ListRepos(t *testing.T) {
+	c := qt.New(t)
+
+	pushedAt := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, qt.Equals, "/users/fatih/starred")
+
+		json.NewEncoder(w).Encode([]*github.StarredRepository{{
+			Repository: &github.Repository{
+				ID:              github.Int64(1),
+				Name:            github.String("vim-go"),
+				DefaultBranch:   github.String("master"),
+				Owner:           &github.User{Login: github.String("fatih")},
+				Language:        github.String("Vim script"),
+				Topics:          []string{"vim", "go"},
+				Description:     github.String("Go development plugin for Vim"),
+				Private:         github.Bool(false),
+				Archived:        github.Bool(true),
+				Size:            github.Int(4096),
+				StargazersCount: github.Int(15000),
+				PushedAt:        &github.Timestamp{Time: pushedAt},
+				HTMLURL:         github.String("https://github.com/fatih/vim-go"),
+			},
+		}})
+	})
+
+	p := NewProvider(client, "github.com")
+
+	repos, err := p.ListRepos(context.Background(), &internal.Source{
+		Kind: internal.SourceStarred,
+		User: "fatih",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.DeepEquals, []*internal.Repository{{
+		RemoteID: 1,
+		Nwo:      "fatih/vim-go",
+		Owner:    "fatih",
+		Name:     "vim-go",
+		Branch:   "master",
+		Metadata: internal.Metadata{
+			Language:    "Vim script",
+			Topics:      []string{"vim", "go"},
+			Description: "Go development plugin for Vim",
+			Visibility:  internal.VisibilityPublic,
+			Archived:    true,
+			Size:        4096,
+			Stars:       15000,
+			PushedAt:    pushedAt,
+			HTMLURL:     "https://github.com/fatih/vim-go",
+		},
+	}})
+	c.Assert(p.CloneURL(repos[0]), qt.Equals, "https://github.com/fatih/vim-go.git")
+}
+
+func TestProvider_DefaultBranches(t *testing.T) {
+	c := qt.New(t)
+
+	committedDate := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, qt.Equals, "/graphql")
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"r0": map[string]interface{}{
+					"defaultBranchRef": map[string]interface{}{
+						"name": "main",
+						"target": map[string]interface{}{
+							"oid":           "123",
+							"committedDate": committedDate,
+						},
+					},
+				},
+			},
+		})
+	})
+
+	p := NewProvider(client, "github.com")
+
+	heads, err := p.DefaultBranches(context.Background(), []*internal.Repository{
+		{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go", Branch: "master"},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(heads, qt.DeepEquals, map[string]*internal.Branch{
+		"fatih/vim-go": {Name: "main", SHA: "123", UpdatedAt: committedDate},
+	})
+}
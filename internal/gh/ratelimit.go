@@ -0,0 +1,285 @@
+package gh
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+const (
+	// maxConcurrency is the maximum number of concurrent requests per rate
+	// limit category, if there is enough quota left.
+	maxConcurrency = 10
+
+	// maxRetries is the maximum number of retries of a single request that
+	// hit a rate limit.
+	maxRetries = 5
+
+	// defaultRetryAfter is used for secondary rate limits, if GitHub doesn't
+	// tell us how long to wait.
+	defaultRetryAfter = time.Minute
+)
+
+// rate limit categories, each category has its own quota.
+const (
+	categoryCore    = "core"
+	categorySearch  = "search"
+	categoryGraphQL = "graphql"
+)
+
+// rateLimiter tracks the remaining quota of a single rate limit category and
+// reduces the number of concurrent requests as the quota drops.
+type rateLimiter struct {
+	mu        sync.Mutex
+	inflight  int
+	remaining int // negative if unknown
+	limit     int
+	reset     time.Time
+
+	// released is closed and replaced whenever a request finishes, to wake
+	// up waiting requests.
+	released chan struct{}
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		remaining: -1,
+		released:  make(chan struct{}),
+	}
+}
+
+// concurrency returns the number of allowed concurrent requests. It's
+// reduced linearly once less than half of the quota is left. l.mu must be
+// held.
+func (l *rateLimiter) concurrency() int {
+	if l.remaining < 0 || l.limit <= 0 || l.remaining*2 >= l.limit {
+		return maxConcurrency
+	}
+
+	n := maxConcurrency * l.remaining * 2 / l.limit
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// acquire blocks until a request can be made. If the quota is exhausted, it
+// sleeps until the quota is reset.
+func (l *rateLimiter) acquire(ctx context.Context, sleep func(context.Context, time.Duration) error) error {
+	for {
+		l.mu.Lock()
+		if l.remaining == 0 && time.Now().Before(l.reset) {
+			wait := time.Until(l.reset)
+			l.mu.Unlock()
+
+			log.Printf("[INFO] GitHub API rate limit exceeded, waiting %s until it's reset", wait.Round(time.Second))
+			if err := sleep(ctx, wait); err != nil {
+				return err
+			}
+
+			l.mu.Lock()
+			l.remaining = -1 // unknown until the next response
+			l.mu.Unlock()
+			continue
+		}
+
+		if l.inflight < l.concurrency() {
+			l.inflight++
+			l.mu.Unlock()
+			return nil
+		}
+
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release marks a request as finished and updates the quota with the rate
+// returned by GitHub.
+func (l *rateLimiter) release(rate github.Rate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+	if rate.Limit > 0 {
+		l.remaining = rate.Remaining
+		l.limit = rate.Limit
+		l.reset = rate.Reset.Time
+	}
+
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// exhausted marks the quota as exhausted until the given reset time.
+func (l *rateLimiter) exhausted(reset time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remaining = 0
+	l.reset = reset
+}
+
+// Calls returns the number of API requests made by the client.
+func (c *Client) Calls() int64 {
+	return atomic.LoadInt64(&c.calls)
+}
+
+// limiter returns the rate limiter of the given category.
+func (c *Client) limiter(category string) *rateLimiter {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+
+	if c.limits == nil {
+		c.limits = make(map[string]*rateLimiter)
+	}
+
+	l, ok := c.limits[category]
+	if !ok {
+		l = newRateLimiter()
+		c.limits[category] = l
+	}
+
+	return l
+}
+
+// do calls fn, which makes a single API request of the given rate limit
+// category. It waits if the quota is exhausted and retries fn if GitHub
+// returns a rate limit error.
+func (c *Client) do(ctx context.Context, category string, fn func() (*github.Response, error)) error {
+	sleep := c.sleep
+	if sleep == nil {
+		sleep = sleepCtx
+	}
+
+	l := c.limiter(category)
+
+	for attempt := 0; ; attempt++ {
+		if err := l.acquire(ctx, sleep); err != nil {
+			return err
+		}
+
+		atomic.AddInt64(&c.calls, 1)
+		resp, err := fn()
+
+		var rate github.Rate
+		if resp != nil {
+			rate = resp.Rate
+		}
+		l.release(rate)
+
+		if rate.Limit > 0 {
+			log.Printf("[DEBUG] GitHub API quota (%s): %d/%d remaining, resets in %s",
+				category, rate.Remaining, rate.Limit, time.Until(rate.Reset.Time).Round(time.Second))
+		}
+
+		if err == nil || attempt == maxRetries {
+			return err
+		}
+
+		// the quota is reset on GitHub's clock, wait a bit longer to account
+		// for clock skew.
+		if reset, ok := rateLimitReset(resp, err); ok {
+			l.exhausted(reset.Add(time.Second))
+			continue
+		}
+
+		wait, ok := retryAfter(resp, err)
+		if !ok {
+			return err
+		}
+
+		log.Printf("[INFO] GitHub API secondary rate limit exceeded, waiting %s", wait)
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// rateLimitReset returns the time the quota is reset, if the request failed
+// because the quota is exhausted.
+func rateLimitReset(resp *github.Response, err error) (time.Time, bool) {
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		return rateErr.Rate.Reset.Time, true
+	}
+
+	if resp == nil || resp.Rate.Limit == 0 || resp.Rate.Remaining != 0 {
+		return time.Time{}, false
+	}
+
+	// GraphQL reports an exhausted quota as a regular query error
+	var gqlErr *GraphQLError
+	if errors.As(err, &gqlErr) && gqlErr.hasType("RATE_LIMITED") {
+		return resp.Rate.Reset.Time, true
+	}
+
+	// some endpoints, such as GetBranch, don't return a RateLimitError
+	if resp.Response != nil && isRateLimitStatus(resp.StatusCode) {
+		return resp.Rate.Reset.Time, true
+	}
+
+	return time.Time{}, false
+}
+
+// retryAfter returns the duration to wait, if the request failed because of
+// a secondary rate limit.
+func retryAfter(resp *github.Response, err error) (time.Duration, bool) {
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if abuseErr.RetryAfter != nil {
+			return *abuseErr.RetryAfter, true
+		}
+		return defaultRetryAfter, true
+	}
+
+	// newer secondary rate limit responses are not recognized by go-github,
+	// but they always contain the Retry-After header.
+	var res *http.Response
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		res = errResp.Response
+	} else if resp != nil && resp.Response != nil {
+		res = resp.Response
+	}
+
+	if res == nil || !isRateLimitStatus(res.StatusCode) {
+		return 0, false
+	}
+
+	secs, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Duration(secs) * time.Second, true
+}
+
+func isRateLimitStatus(code int) bool {
+	return code == http.StatusForbidden || code == http.StatusTooManyRequests
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,122 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fatih/starhook/internal/apitest"
+	"github.com/google/go-github/v39/github"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// newTestClient returns a client that talks to a stand-in GitHub API. It
+// doesn't sleep, but records the durations it would have slept.
+func newTestClient(c *qt.C, handler http.HandlerFunc) (*Client, *apitest.Sleeper) {
+	server := apitest.NewServer(c, "", "", handler)
+
+	ghClient := github.NewClient(nil)
+	ghClient.BaseURL, _ = url.Parse(server.URL + "/")
+
+	sleeper := &apitest.Sleeper{}
+	client := newClient(ghClient)
+	client.sleep = sleeper.Sleep
+
+	return client, sleeper
+}
+
+func TestClient_rateLimit(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// the quota was reset just now
+	reset := time.Now().Truncate(time.Second)
+
+	var requests int
+	client, slept := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		w.Header().Set("X-RateLimit-Limit", "30")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+		if requests == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"message": "API rate limit exceeded"})
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Remaining", "29")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"total_count": 1,
+			"items":       []map[string]interface{}{{"id": 1, "name": "vim-go"}},
+		})
+	})
+
+	repos, err := client.FetchRepos(ctx, "user:fatih")
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+	c.Assert(requests, qt.Equals, 2)
+	c.Assert(client.Calls(), qt.Equals, int64(2))
+	c.Assert(slept.Slept(), qt.HasLen, 1, qt.Commentf("client should wait until the quota is reset"))
+}
+
+func TestClient_secondaryRateLimit(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var requests int
+	client, slept := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if requests == 1 {
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{
+				"message":           "You have exceeded a secondary rate limit",
+				"documentation_url": "https://docs.github.com/rest/overview/resources-in-the-rest-api#secondary-rate-limits",
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name": "main",
+			"commit": map[string]interface{}{
+				"sha": "123",
+			},
+		})
+	})
+
+	branch, err := client.Branch(ctx, "fatih", "vim-go", "main")
+	c.Assert(err, qt.IsNil)
+	c.Assert(branch.SHA, qt.Equals, "123")
+	c.Assert(slept.Slept(), qt.DeepEquals, []time.Duration{3 * time.Second})
+}
+
+func TestRateLimiter_concurrency(t *testing.T) {
+	tests := []struct {
+		remaining int
+		limit     int
+		want      int
+	}{
+		{remaining: -1, limit: 0, want: maxConcurrency},
+		{remaining: 5000, limit: 5000, want: maxConcurrency},
+		{remaining: 2500, limit: 5000, want: maxConcurrency},
+		{remaining: 1250, limit: 5000, want: maxConcurrency / 2},
+		{remaining: 10, limit: 5000, want: 1},
+		{remaining: 0, limit: 5000, want: 1},
+	}
+
+	for _, tt := range tests {
+		c := qt.New(t)
+		l := newRateLimiter()
+		l.remaining = tt.remaining
+		l.limit = tt.limit
+		c.Assert(l.concurrency(), qt.Equals, tt.want, qt.Commentf("remaining: %d, limit: %d", tt.remaining, tt.limit))
+	}
+}
@@ -0,0 +1,116 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// searchResultLimit is the maximum number of results the search API returns
+// for a single query.
+const searchResultLimit = 1000
+
+// searchDateFormat is the format of the dates in the created qualifier.
+const searchDateFormat = "2006-01-02T15:04:05+00:00"
+
+// searchEpoch is a date before any repository was created on GitHub.
+var searchEpoch = time.Date(2007, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+// repoSearch fetches all repositories of a search query.
+type repoSearch struct {
+	client *Client
+	query  string
+
+	repos []*github.Repository
+	seen  map[int64]bool // IDs of the fetched repositories
+
+	// incomplete is set if some repositories couldn't be fetched because
+	// of the search result limit.
+	incomplete bool
+}
+
+// fetch fetches the repositories created between from and to, both
+// inclusive. If from is zero, the whole query is fetched. Slices that match
+// more repositories than the search API returns are split in half until
+// each slice fits.
+func (s *repoSearch) fetch(ctx context.Context, from, to time.Time) error {
+	query := s.query
+	if !from.IsZero() {
+		query = fmt.Sprintf("%s created:%s..%s", query,
+			from.Format(searchDateFormat), to.Format(searchDateFormat))
+	}
+
+	opts := &github.SearchOptions{
+		ListOptions: github.ListOptions{PerPage: 50},
+	}
+
+	for {
+		var (
+			res  *github.RepositoriesSearchResult
+			resp *github.Response
+		)
+
+		err := s.client.do(ctx, categorySearch, func() (*github.Response, error) {
+			var err error
+			res, resp, err = s.client.Search.Repositories(ctx, query, opts)
+			return resp, err
+		})
+		if err != nil {
+			return err
+		}
+
+		if opts.Page == 0 && res.GetTotal() > searchResultLimit {
+			if from.IsZero() && !strings.Contains(s.query, "created:") {
+				log.Printf("[DEBUG] query %q matches %d repositories, splitting it by creation date",
+					s.query, res.GetTotal())
+				return s.split(ctx, searchEpoch, time.Now().UTC().Truncate(time.Second))
+			}
+
+			if !from.IsZero() && to.Sub(from) >= time.Second {
+				return s.split(ctx, from, to)
+			}
+
+			// the query can't be split any further, fetch as many
+			// repositories as possible.
+			s.incomplete = true
+		}
+
+		s.add(res.Repositories)
+		if resp.NextPage == 0 {
+			return nil
+		}
+
+		opts.Page = resp.NextPage
+	}
+}
+
+// split fetches the repositories created between from and to, by
+// splitting the range in two disjoint halves.
+func (s *repoSearch) split(ctx context.Context, from, to time.Time) error {
+	mid := from.Add(to.Sub(from) / 2).Truncate(time.Second)
+	if err := s.fetch(ctx, from, mid); err != nil {
+		return err
+	}
+
+	return s.fetch(ctx, mid.Add(time.Second), to)
+}
+
+// add adds the given repositories, skipping the ones that were already
+// fetched. Repositories might be returned twice if they're updated while
+// the query is paginated.
+func (s *repoSearch) add(repos []*github.Repository) {
+	for _, repo := range repos {
+		if id := repo.GetID(); id != 0 {
+			if s.seen[id] {
+				continue
+			}
+			s.seen[id] = true
+		}
+
+		s.repos = append(s.repos, repo)
+	}
+}
@@ -0,0 +1,160 @@
+package gh
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// fakeSearchService searches a fixed set of repositories by their creation
+// date, and returns at most searchResultLimit results, like the search API.
+type fakeSearchService struct {
+	repos   []*github.Repository
+	queries []string
+}
+
+var createdRe = regexp.MustCompile(`created:(\S+)\.\.(\S+)`)
+
+func (f *fakeSearchService) Repositories(ctx context.Context, query string, opt *github.SearchOptions) (*github.RepositoriesSearchResult, *github.Response, error) {
+	f.queries = append(f.queries, query)
+
+	var from, to time.Time
+	if m := createdRe.FindStringSubmatch(query); m != nil {
+		var err error
+		if from, err = time.Parse(searchDateFormat, m[1]); err != nil {
+			return nil, nil, err
+		}
+		if to, err = time.Parse(searchDateFormat, m[2]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var matched []*github.Repository
+	for _, repo := range f.repos {
+		created := repo.GetCreatedAt().Time
+		if !from.IsZero() && (created.Before(from) || created.After(to)) {
+			continue
+		}
+
+		matched = append(matched, repo)
+	}
+
+	total := len(matched)
+	if len(matched) > searchResultLimit {
+		matched = matched[:searchResultLimit]
+	}
+
+	page := opt.Page
+	if page == 0 {
+		page = 1
+	}
+
+	start := (page - 1) * opt.PerPage
+	end := start + opt.PerPage
+	if start > len(matched) {
+		start = len(matched)
+	}
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	resp := &github.Response{}
+	if end < len(matched) {
+		resp.NextPage = page + 1
+	}
+
+	return &github.RepositoriesSearchResult{
+		Total:        &total,
+		Repositories: matched[start:end],
+	}, resp, nil
+}
+
+func newFakeRepos(n int, created time.Time, step time.Duration) []*github.Repository {
+	repos := make([]*github.Repository, 0, n)
+	for i := 0; i < n; i++ {
+		repos = append(repos, &github.Repository{
+			ID:        github.Int64(int64(i + 1)),
+			CreatedAt: &github.Timestamp{Time: created.Add(time.Duration(i) * step)},
+		})
+	}
+	return repos
+}
+
+func TestClient_FetchRepos_split(t *testing.T) {
+	c := qt.New(t)
+
+	search := &fakeSearchService{
+		repos: newFakeRepos(2500, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour),
+	}
+	client := &Client{Search: search}
+
+	repos, err := client.FetchRepos(context.Background(), "org:bigcorp")
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 2500)
+
+	seen := make(map[int64]bool)
+	for _, repo := range repos {
+		c.Assert(seen[repo.GetID()], qt.IsFalse, qt.Commentf("repo %d is returned twice", repo.GetID()))
+		seen[repo.GetID()] = true
+	}
+
+	c.Assert(search.queries[0], qt.Equals, "org:bigcorp")
+	for _, query := range search.queries[1:] {
+		c.Assert(query, qt.Matches, `org:bigcorp created:\S+\.\.\S+`)
+	}
+}
+
+func TestClient_FetchRepos_incomplete(t *testing.T) {
+	c := qt.New(t)
+
+	// all repositories are created at the same time, so the query can't be
+	// split.
+	search := &fakeSearchService{
+		repos: newFakeRepos(1200, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), 0),
+	}
+	client := &Client{Search: search}
+
+	repos, err := client.FetchRepos(context.Background(), "org:bigcorp")
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, searchResultLimit)
+}
+
+func TestClient_FetchRepos_createdQualifier(t *testing.T) {
+	c := qt.New(t)
+
+	search := &fakeSearchService{
+		repos: newFakeRepos(1200, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour),
+	}
+	client := &Client{Search: search}
+
+	// queries with their own created qualifier are not split
+	repos, err := client.FetchRepos(context.Background(), "org:bigcorp created:>2010-01-01")
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, searchResultLimit)
+	for _, query := range search.queries {
+		c.Assert(query, qt.Equals, "org:bigcorp created:>2010-01-01")
+	}
+}
+
+func TestClient_FetchRepos_multipleQueries(t *testing.T) {
+	c := qt.New(t)
+
+	// the fake returns the same repositories for all queries
+	search := &fakeSearchService{
+		repos: newFakeRepos(120, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), time.Hour),
+	}
+	client := &Client{Search: search}
+
+	repos, err := client.FetchRepos(context.Background(), "org:bigcorp language:go", "topic:platform org:contoso")
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 120, qt.Commentf("repositories matching both queries should be returned once"))
+	c.Assert(search.queries, qt.DeepEquals, []string{
+		"org:bigcorp language:go", "org:bigcorp language:go", "org:bigcorp language:go",
+		"topic:platform org:contoso", "topic:platform org:contoso", "topic:platform org:contoso",
+	})
+}
@@ -2,11 +2,48 @@ package git
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"strings"
 )
 
 type Client struct {
 	Dir string
+
+	// Env contains additional environment variables, in the form of
+	// "key=value", passed to git. They're not part of the returned errors,
+	// hence they can be used to pass credentials.
+	Env []string
+}
+
+// Error is returned if running git fails.
+type Error struct {
+	Args []string
+	Dir  string
+	Out  []byte // combined output of stdout and stderr
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("running git failed: %s (out: %q, args: %+v, dir: %s)",
+		e.Err, string(e.Out), e.Args, e.Dir)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Message returns the last non-empty line of git's output, which usually
+// describes why git failed, i.e: "fatal: not a git repository"
+func (e *Error) Message() string {
+	lines := strings.Split(strings.TrimSpace(string(e.Out)), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+
+	return e.Err.Error()
 }
 
 func (g *Client) Run(args ...string) ([]byte, error) {
@@ -14,11 +51,18 @@ func (g *Client) Run(args ...string) ([]byte, error) {
 	if g.Dir != "" {
 		c.Dir = g.Dir
 	}
+	if len(g.Env) != 0 {
+		c.Env = append(os.Environ(), g.Env...)
+	}
 
 	out, err := c.CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("running git failed: %w (out: %q, args: %+v, dir: %s)",
-			err, string(out), args, c.Dir)
+		return nil, &Error{
+			Args: args,
+			Dir:  c.Dir,
+			Out:  out,
+			Err:  err,
+		}
 	}
 
 	return out, nil
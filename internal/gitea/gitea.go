@@ -0,0 +1,361 @@
+// Package gitea fetches repositories from Gitea.
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/httpretry"
+)
+
+// perPage is the requested page size of the listing endpoints. Gitea caps it
+// to its MAX_RESPONSE_ITEMS setting, 50 by default, hence a short page isn't
+// the last one. The pages are read until an empty one.
+const perPage = 50
+
+var _ internal.Provider = (*Client)(nil)
+
+// Client fetches repositories via Gitea's REST API.
+type Client struct {
+	baseURL *url.URL // i.e: https://gitea.example.com/api/v1/
+	token   string
+
+	// HTTPClient is used to make the requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+
+	calls int64 // number of API requests, accessed atomically
+
+	// sleep waits before retrying a throttled request, tests replace it
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewClient returns a client for the Gitea API with the given URL, i.e:
+// "https://gitea.example.com/api/v1/".
+func NewClient(apiURL, token string) (*Client, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return &Client{
+		baseURL: u,
+		token:   token,
+	}, nil
+}
+
+// repository is a Gitea repository, only the fields used by starhook are
+// decoded.
+type repository struct {
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	FullName      string `json:"full_name"`
+	DefaultBranch string `json:"default_branch"`
+	Owner         struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+
+	Language    string   `json:"language"`
+	Topics      []string `json:"topics"`
+	Description string   `json:"description"`
+	Private     bool     `json:"private"`
+	Internal    bool     `json:"internal"`
+	Fork        bool     `json:"fork"`
+	Archived    bool     `json:"archived"`
+	Size        int64    `json:"size"`
+	StarsCount  int64    `json:"stars_count"`
+	HTMLURL     string   `json:"html_url"`
+}
+
+// metadata returns the metadata of the repository. Gitea doesn't return
+// the time of the last push.
+func (r *repository) metadata() internal.Metadata {
+	visibility := internal.VisibilityPublic
+	switch {
+	case r.Private:
+		visibility = internal.VisibilityPrivate
+	case r.Internal:
+		visibility = internal.VisibilityInternal
+	}
+
+	topics := r.Topics
+	if len(topics) == 0 {
+		topics = nil
+	}
+
+	return internal.Metadata{
+		Language:    r.Language,
+		Topics:      topics,
+		Description: r.Description,
+		Visibility:  visibility,
+		Fork:        r.Fork,
+		Archived:    r.Archived,
+		Size:        r.Size,
+		Stars:       r.StarsCount,
+		HTMLURL:     r.HTMLURL,
+	}
+}
+
+// branch is a Gitea branch.
+type branch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID        string    `json:"id"`
+		Timestamp time.Time `json:"timestamp"`
+	} `json:"commit"`
+}
+
+// Error is returned if the Gitea API returns an unsuccessful status code.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gitea: %d %s", e.StatusCode, e.Message)
+}
+
+// isNotFound reports whether err is caused by a missing resource.
+func isNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// Calls returns the number of API requests made by the client.
+func (c *Client) Calls() int64 {
+	return atomic.LoadInt64(&c.calls)
+}
+
+// ListRepos lists the repositories of the given source. Teams are not
+// supported.
+func (c *Client) ListRepos(ctx context.Context, source *internal.Source) ([]*internal.Repository, error) {
+	var repos []*repository
+	var err error
+
+	switch source.Kind {
+	case internal.SourceSearch:
+		repos, err = c.searchRepos(ctx, source.Query)
+	case internal.SourceOrg:
+		repos, err = c.listRepos(ctx, "orgs/"+url.PathEscape(source.Org)+"/repos")
+	case internal.SourceUser:
+		if source.User == "" {
+			repos, err = c.listRepos(ctx, "user/repos")
+		} else {
+			repos, err = c.listRepos(ctx, "users/"+url.PathEscape(source.User)+"/repos")
+		}
+	case internal.SourceStarred:
+		if source.User == "" {
+			repos, err = c.listRepos(ctx, "user/starred")
+		} else {
+			repos, err = c.listRepos(ctx, "users/"+url.PathEscape(source.User)+"/starred")
+		}
+	case internal.SourceList:
+		repos, err = c.getRepos(ctx, source.Repos)
+	default:
+		return nil, fmt.Errorf("source kind %q is not supported by Gitea", source.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*internal.Repository, 0, len(repos))
+	for _, repo := range repos {
+		out = append(out, &internal.Repository{
+			RemoteID: repo.ID,
+			Nwo:      repo.FullName,
+			Owner:    repo.Owner.Login,
+			Name:     repo.Name,
+			Branch:   repo.DefaultBranch,
+			Metadata: repo.metadata(),
+		})
+	}
+
+	return out, nil
+}
+
+// DefaultBranches returns no branches, Gitea doesn't allow resolving the
+// branches of many repositories at once. The branches are resolved with
+// Branch instead.
+func (c *Client) DefaultBranches(ctx context.Context, repos []*internal.Repository) (map[string]*internal.Branch, error) {
+	return map[string]*internal.Branch{}, nil
+}
+
+// Branch returns the head of the given branch of a repository.
+func (c *Client) Branch(ctx context.Context, repo *internal.Repository, name string) (*internal.Branch, error) {
+	// empty repositories have no default branch, the path would list all
+	// branches instead
+	if name == "" {
+		return nil, internal.ErrBranchNotFound
+	}
+
+	path := fmt.Sprintf("repos/%s/%s/branches/%s",
+		url.PathEscape(repo.Owner), url.PathEscape(repo.Name), url.PathEscape(name))
+
+	var b branch
+	if err := c.get(ctx, path, nil, &b); err != nil {
+		if isNotFound(err) {
+			return nil, internal.ErrBranchNotFound
+		}
+		return nil, err
+	}
+
+	return &internal.Branch{
+		Name:      b.Name,
+		SHA:       b.Commit.ID,
+		UpdatedAt: b.Commit.Timestamp,
+	}, nil
+}
+
+// CloneURL returns the HTTPS URL to clone the given repository.
+func (c *Client) CloneURL(repo *internal.Repository) string {
+	return fmt.Sprintf("%s://%s/%s/%s.git", c.baseURL.Scheme, c.baseURL.Host, repo.Owner, repo.Name)
+}
+
+// searchRepos searches the repositories matching any of the given keywords,
+// without duplicates.
+func (c *Client) searchRepos(ctx context.Context, queries []string) ([]*repository, error) {
+	var repos []*repository
+	seen := make(map[int64]bool)
+
+	for _, query := range queries {
+		for page := 1; ; page++ {
+			var res struct {
+				Data []*repository `json:"data"`
+			}
+
+			err := c.get(ctx, "repos/search", url.Values{
+				"q":     {query},
+				"page":  {strconv.Itoa(page)},
+				"limit": {strconv.Itoa(perPage)},
+			}, &res)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, repo := range res.Data {
+				if !seen[repo.ID] {
+					seen[repo.ID] = true
+					repos = append(repos, repo)
+				}
+			}
+
+			if len(res.Data) == 0 {
+				break
+			}
+		}
+	}
+
+	return repos, nil
+}
+
+// listRepos lists all repositories of the given endpoint.
+func (c *Client) listRepos(ctx context.Context, path string) ([]*repository, error) {
+	var repos []*repository
+	for page := 1; ; page++ {
+		var res []*repository
+
+		err := c.get(ctx, path, url.Values{
+			"page":  {strconv.Itoa(page)},
+			"limit": {strconv.Itoa(perPage)},
+		}, &res)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(res) == 0 {
+			return repos, nil
+		}
+		repos = append(repos, res...)
+	}
+}
+
+// getRepos fetches the given repositories, in the form of "owner/name".
+// Repositories that don't exist, or the token has no access to, are
+// skipped.
+func (c *Client) getRepos(ctx context.Context, nwos []string) ([]*repository, error) {
+	var repos []*repository
+	for _, nwo := range nwos {
+		parts := strings.Split(nwo, "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid repository %q, should be in the form of 'owner/name'", nwo)
+		}
+
+		var repo repository
+		path := "repos/" + url.PathEscape(parts[0]) + "/" + url.PathEscape(parts[1])
+		if err := c.get(ctx, path, nil, &repo); err != nil {
+			if isNotFound(err) {
+				log.Printf("[WARN] skipping %q, repository not found", nwo)
+				continue
+			}
+			return nil, err
+		}
+
+		repos = append(repos, &repo)
+	}
+
+	return repos, nil
+}
+
+// get makes a GET request to the given path, relative to the API URL, and
+// decodes the response into v.
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	// the path is already escaped, hence use Opaque to not escape it twice
+	u := *c.baseURL
+	u.Opaque = "//" + u.Host + u.EscapedPath() + path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "token "+c.token)
+	}
+
+	resp, err := httpretry.Do(c.HTTPClient, req, httpretry.Options{
+		Name:  "Gitea",
+		Calls: &c.calls,
+		Sleep: c.sleep,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		var e struct {
+			Message string `json:"message"`
+		}
+
+		msg := strings.TrimSpace(string(body))
+		if err := json.Unmarshal(body, &e); err == nil && e.Message != "" {
+			msg = e.Message
+		}
+
+		return &Error{StatusCode: resp.StatusCode, Message: msg}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("gitea: decoding response of %q failed: %w", path, err)
+	}
+
+	return nil
+}
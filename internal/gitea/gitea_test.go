@@ -0,0 +1,258 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/apitest"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// newTestClient returns a client that talks to a stand-in API. It doesn't
+// sleep, but records the durations it would have slept.
+func newTestClient(c *qt.C, handler http.HandlerFunc) (*Client, *apitest.Sleeper) {
+	server := apitest.NewServer(c, "Authorization", "token token", handler)
+
+	client, err := NewClient(server.URL+"/api/v1/", "token")
+	c.Assert(err, qt.IsNil)
+
+	sleeper := &apitest.Sleeper{}
+	client.sleep = sleeper.Sleep
+	return client, sleeper
+}
+
+func newRepos(n int) []*repository {
+	repos := make([]*repository, 0, n)
+	for i := 1; i <= n; i++ {
+		repo := &repository{
+			ID:            int64(i),
+			Name:          fmt.Sprintf("repo-%d", i),
+			FullName:      fmt.Sprintf("bigcorp/repo-%d", i),
+			DefaultBranch: "main",
+		}
+		repo.Owner.Login = "bigcorp"
+		repos = append(repos, repo)
+	}
+	return repos
+}
+
+// paginate returns the page of the given repositories requested by r. The
+// page size is capped to maxItems, like Gitea's MAX_RESPONSE_ITEMS setting.
+func paginate(r *http.Request, repos []*repository, maxItems int) []*repository {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit > maxItems {
+		limit = maxItems
+	}
+
+	start := (page - 1) * limit
+	if start > len(repos) {
+		start = len(repos)
+	}
+	end := start + limit
+	if end > len(repos) {
+		end = len(repos)
+	}
+
+	return repos[start:end]
+}
+
+func TestClient_ListRepos(t *testing.T) {
+	c := qt.New(t)
+
+	all := newRepos(perPage + 10)
+	all[0].Language = "Go"
+	all[0].Topics = []string{"cli"}
+	all[0].Private = true
+	all[0].Size = 42
+	all[0].StarsCount = 7
+	all[0].HTMLURL = "https://gitea.example.com/bigcorp/repo-1"
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, qt.Equals, "/api/v1/orgs/bigcorp/repos")
+		json.NewEncoder(w).Encode(paginate(r, all, perPage))
+	})
+
+	repos, err := client.ListRepos(context.Background(), &internal.Source{
+		Kind: internal.SourceOrg,
+		Org:  "bigcorp",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, len(all))
+	c.Assert(repos[0], qt.DeepEquals, &internal.Repository{
+		RemoteID: 1,
+		Nwo:      "bigcorp/repo-1",
+		Owner:    "bigcorp",
+		Name:     "repo-1",
+		Branch:   "main",
+		Metadata: internal.Metadata{
+			Language:   "Go",
+			Topics:     []string{"cli"},
+			Visibility: internal.VisibilityPrivate,
+			Size:       42,
+			Stars:      7,
+			HTMLURL:    "https://gitea.example.com/bigcorp/repo-1",
+		},
+	})
+	c.Assert(repos[1].Visibility, qt.Equals, internal.VisibilityPublic)
+	c.Assert(client.Calls(), qt.Equals, int64(3))
+}
+
+func TestClient_ListRepos_maxResponseItems(t *testing.T) {
+	c := qt.New(t)
+
+	// the server returns less repositories per page than requested
+	all := newRepos(25)
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/orgs/bigcorp/repos":
+			json.NewEncoder(w).Encode(paginate(r, all, 10))
+		case "/api/v1/repos/search":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ok":   true,
+				"data": paginate(r, all, 10),
+			})
+		}
+	})
+
+	repos, err := client.ListRepos(context.Background(), &internal.Source{
+		Kind: internal.SourceOrg,
+		Org:  "bigcorp",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, len(all))
+
+	repos, err = client.ListRepos(context.Background(), &internal.Source{
+		Kind:  internal.SourceSearch,
+		Query: internal.Queries{"repo"},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, len(all))
+}
+
+func TestClient_ListRepos_search(t *testing.T) {
+	c := qt.New(t)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, qt.Equals, "/api/v1/repos/search")
+		c.Check(r.URL.Query().Get("q"), qt.Equals, "starhook")
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":   true,
+			"data": paginate(r, newRepos(3), perPage),
+		})
+	})
+
+	repos, err := client.ListRepos(context.Background(), &internal.Source{
+		Kind:  internal.SourceSearch,
+		Query: internal.Queries{"starhook"},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 3)
+}
+
+func TestClient_ListRepos_multipleQueries(t *testing.T) {
+	c := qt.New(t)
+
+	var queries []string
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		queries = append(queries, q)
+
+		// the queries share the second repository
+		repos := newRepos(2)
+		if q == "vim" {
+			repos = newRepos(3)[1:]
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":   true,
+			"data": paginate(r, repos, perPage),
+		})
+	})
+
+	repos, err := client.ListRepos(context.Background(), &internal.Source{
+		Kind:  internal.SourceSearch,
+		Query: internal.Queries{"starhook", "vim"},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(queries, qt.DeepEquals, []string{"starhook", "starhook", "vim", "vim"})
+
+	var nwos []string
+	for _, repo := range repos {
+		nwos = append(nwos, repo.Nwo)
+	}
+	c.Assert(nwos, qt.DeepEquals, []string{"bigcorp/repo-1", "bigcorp/repo-2", "bigcorp/repo-3"})
+}
+
+func TestClient_Branch(t *testing.T) {
+	c := qt.New(t)
+
+	committed := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/repos/bigcorp/api/branches/main":
+			b := branch{Name: "main"}
+			b.Commit.ID = "123"
+			b.Commit.Timestamp = committed
+			json.NewEncoder(w).Encode(b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "branch not found"}`))
+		}
+	})
+
+	ctx := context.Background()
+	repo := &internal.Repository{Nwo: "bigcorp/api", Owner: "bigcorp", Name: "api"}
+
+	b, err := client.Branch(ctx, repo, "main")
+	c.Assert(err, qt.IsNil)
+	c.Assert(b, qt.DeepEquals, &internal.Branch{
+		Name:      "main",
+		SHA:       "123",
+		UpdatedAt: committed,
+	})
+
+	_, err = client.Branch(ctx, repo, "missing")
+	c.Assert(errors.Is(err, internal.ErrBranchNotFound), qt.IsTrue)
+}
+
+func TestClient_Branch_emptyRepo(t *testing.T) {
+	c := qt.New(t)
+
+	// without a name, the path lists all branches
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]branch{})
+	})
+
+	repo := &internal.Repository{Nwo: "bigcorp/empty", Owner: "bigcorp", Name: "empty"}
+	_, err := client.Branch(context.Background(), repo, "")
+	c.Assert(errors.Is(err, internal.ErrBranchNotFound), qt.IsTrue)
+	c.Assert(client.Calls(), qt.Equals, int64(0))
+}
+
+func TestClient_rateLimit(t *testing.T) {
+	c := qt.New(t)
+
+	client, slept := newTestClient(c, apitest.Throttle(2, "3", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(paginate(r, newRepos(1), perPage))
+	}))
+
+	repos, err := client.ListRepos(context.Background(), &internal.Source{
+		Kind: internal.SourceOrg,
+		Org:  "bigcorp",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+	c.Assert(slept.Slept(), qt.DeepEquals, []time.Duration{3 * time.Second, 3 * time.Second})
+	c.Assert(client.Calls(), qt.Equals, int64(4))
+}
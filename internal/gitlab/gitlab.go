@@ -0,0 +1,335 @@
+// Package gitlab fetches repositories (projects) from GitLab.
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/httpretry"
+)
+
+// perPage is the page size of the listing endpoints.
+const perPage = 100
+
+var _ internal.Provider = (*Client)(nil)
+
+// Client fetches projects via GitLab's REST API.
+type Client struct {
+	baseURL *url.URL // i.e: https://gitlab.com/api/v4/
+	token   string
+
+	// HTTPClient is used to make the requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+
+	calls int64 // number of API requests, accessed atomically
+
+	// sleep waits before retrying a throttled request, tests replace it
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewClient returns a client for the GitLab API with the given URL, i.e:
+// "https://gitlab.com/api/v4/".
+func NewClient(apiURL, token string) (*Client, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return &Client{
+		baseURL: u,
+		token:   token,
+	}, nil
+}
+
+// project is a GitLab project, only the fields used by starhook are
+// decoded.
+type project struct {
+	ID                int64  `json:"id"`
+	Path              string `json:"path"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	DefaultBranch     string `json:"default_branch"`
+	Namespace         struct {
+		FullPath string `json:"full_path"`
+	} `json:"namespace"`
+
+	Description       string          `json:"description"`
+	Topics            []string        `json:"topics"`
+	TagList           []string        `json:"tag_list"` // topics before GitLab 14.5
+	Visibility        string          `json:"visibility"`
+	ForkedFromProject json.RawMessage `json:"forked_from_project"`
+	Archived          bool            `json:"archived"`
+	StarCount         int64           `json:"star_count"`
+	LastActivityAt    time.Time       `json:"last_activity_at"` // includes pushes
+	WebURL            string          `json:"web_url"`
+}
+
+// metadata returns the metadata of the project. The language and the size
+// aren't part of the listed projects.
+func (p *project) metadata() internal.Metadata {
+	topics := p.Topics
+	if len(topics) == 0 {
+		topics = p.TagList
+	}
+	if len(topics) == 0 {
+		topics = nil
+	}
+
+	return internal.Metadata{
+		Topics:      topics,
+		Description: p.Description,
+		Visibility:  p.Visibility,
+		Fork:        len(p.ForkedFromProject) != 0 && string(p.ForkedFromProject) != "null",
+		Archived:    p.Archived,
+		Stars:       p.StarCount,
+		PushedAt:    p.LastActivityAt.UTC(),
+		HTMLURL:     p.WebURL,
+	}
+}
+
+// branch is a GitLab branch.
+type branch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID            string    `json:"id"`
+		CommittedDate time.Time `json:"committed_date"`
+	} `json:"commit"`
+}
+
+// Error is returned if the GitLab API returns an unsuccessful status code.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gitlab: %d %s", e.StatusCode, e.Message)
+}
+
+// isNotFound reports whether err is caused by a missing resource.
+func isNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// Calls returns the number of API requests made by the client.
+func (c *Client) Calls() int64 {
+	return atomic.LoadInt64(&c.calls)
+}
+
+// ListRepos lists the projects of the given source. Organizations are
+// GitLab's groups, including their subgroups. Teams are not supported.
+func (c *Client) ListRepos(ctx context.Context, source *internal.Source) ([]*internal.Repository, error) {
+	var projects []*project
+	var err error
+
+	switch source.Kind {
+	case internal.SourceSearch:
+		projects, err = c.searchProjects(ctx, source.Query)
+	case internal.SourceOrg:
+		projects, err = c.listProjects(ctx, "groups/"+url.PathEscape(source.Org)+"/projects",
+			url.Values{"include_subgroups": {"true"}})
+	case internal.SourceUser:
+		if source.User == "" {
+			projects, err = c.listProjects(ctx, "projects", url.Values{"owned": {"true"}})
+		} else {
+			projects, err = c.listProjects(ctx, "users/"+url.PathEscape(source.User)+"/projects", nil)
+		}
+	case internal.SourceStarred:
+		if source.User == "" {
+			projects, err = c.listProjects(ctx, "projects", url.Values{"starred": {"true"}})
+		} else {
+			projects, err = c.listProjects(ctx, "users/"+url.PathEscape(source.User)+"/starred_projects", nil)
+		}
+	case internal.SourceList:
+		projects, err = c.getProjects(ctx, source.Repos)
+	default:
+		return nil, fmt.Errorf("source kind %q is not supported by GitLab", source.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]*internal.Repository, 0, len(projects))
+	for _, p := range projects {
+		repos = append(repos, &internal.Repository{
+			RemoteID: p.ID,
+			Nwo:      p.PathWithNamespace,
+			Owner:    p.Namespace.FullPath,
+			Name:     p.Path,
+			Branch:   p.DefaultBranch,
+			Metadata: p.metadata(),
+		})
+	}
+
+	return repos, nil
+}
+
+// DefaultBranches returns no branches, GitLab doesn't allow resolving the
+// branches of many projects at once. The branches are resolved with Branch
+// instead.
+func (c *Client) DefaultBranches(ctx context.Context, repos []*internal.Repository) (map[string]*internal.Branch, error) {
+	return map[string]*internal.Branch{}, nil
+}
+
+// Branch returns the head of the given branch of a project.
+func (c *Client) Branch(ctx context.Context, repo *internal.Repository, name string) (*internal.Branch, error) {
+	// empty projects have no default branch, the path would list all
+	// branches instead
+	if name == "" {
+		return nil, internal.ErrBranchNotFound
+	}
+
+	path := fmt.Sprintf("projects/%s/repository/branches/%s",
+		url.PathEscape(repo.Nwo), url.PathEscape(name))
+
+	var b branch
+	if _, err := c.get(ctx, path, nil, &b); err != nil {
+		if isNotFound(err) {
+			return nil, internal.ErrBranchNotFound
+		}
+		return nil, err
+	}
+
+	return &internal.Branch{
+		Name:      b.Name,
+		SHA:       b.Commit.ID,
+		UpdatedAt: b.Commit.CommittedDate,
+	}, nil
+}
+
+// CloneURL returns the HTTPS URL to clone the given project.
+func (c *Client) CloneURL(repo *internal.Repository) string {
+	return fmt.Sprintf("%s://%s/%s.git", c.baseURL.Scheme, c.baseURL.Host, repo.Nwo)
+}
+
+// searchProjects lists the projects matching any of the given keywords,
+// without duplicates.
+func (c *Client) searchProjects(ctx context.Context, queries []string) ([]*project, error) {
+	var projects []*project
+	seen := make(map[int64]bool)
+
+	for _, query := range queries {
+		res, err := c.listProjects(ctx, "projects", url.Values{"search": {query}})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range res {
+			if !seen[p.ID] {
+				seen[p.ID] = true
+				projects = append(projects, p)
+			}
+		}
+	}
+
+	return projects, nil
+}
+
+// listProjects lists all projects of the given endpoint.
+func (c *Client) listProjects(ctx context.Context, path string, query url.Values) ([]*project, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("per_page", strconv.Itoa(perPage))
+
+	var projects []*project
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+
+		var res []*project
+		resp, err := c.get(ctx, path, query, &res)
+		if err != nil {
+			return nil, err
+		}
+
+		projects = append(projects, res...)
+		page = resp.Header.Get("X-Next-Page")
+	}
+
+	return projects, nil
+}
+
+// getProjects fetches the projects with the given paths. Projects that
+// don't exist, or the token has no access to, are skipped.
+func (c *Client) getProjects(ctx context.Context, paths []string) ([]*project, error) {
+	var projects []*project
+	for _, path := range paths {
+		var p project
+		if _, err := c.get(ctx, "projects/"+url.PathEscape(path), nil, &p); err != nil {
+			if isNotFound(err) {
+				log.Printf("[WARN] skipping %q, project not found", path)
+				continue
+			}
+			return nil, err
+		}
+
+		projects = append(projects, &p)
+	}
+
+	return projects, nil
+}
+
+// get makes a GET request to the given path, relative to the API URL, and
+// decodes the response into v.
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) (*http.Response, error) {
+	// the path is already escaped, hence use Opaque to not escape it twice
+	u := *c.baseURL
+	u.Opaque = "//" + u.Host + u.EscapedPath() + path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.token != "" {
+		req.Header.Set("PRIVATE-TOKEN", c.token)
+	}
+
+	resp, err := httpretry.Do(c.HTTPClient, req, httpretry.Options{
+		Name:  "GitLab",
+		Calls: &c.calls,
+		Sleep: c.sleep,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		var e struct {
+			Message interface{} `json:"message"`
+		}
+
+		msg := strings.TrimSpace(string(body))
+		if err := json.Unmarshal(body, &e); err == nil && e.Message != nil {
+			msg = fmt.Sprint(e.Message)
+		}
+
+		return nil, &Error{StatusCode: resp.StatusCode, Message: msg}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("gitlab: decoding response of %q failed: %w", path, err)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,156 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/apitest"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// newTestClient returns a client that talks to a stand-in API. It doesn't
+// sleep, but records the durations it would have slept.
+func newTestClient(c *qt.C, handler http.HandlerFunc) (*Client, *apitest.Sleeper) {
+	server := apitest.NewServer(c, "PRIVATE-TOKEN", "token", handler)
+
+	client, err := NewClient(server.URL+"/api/v4", "token")
+	c.Assert(err, qt.IsNil)
+
+	sleeper := &apitest.Sleeper{}
+	client.sleep = sleeper.Sleep
+	return client, sleeper
+}
+
+func TestClient_ListRepos(t *testing.T) {
+	c := qt.New(t)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.EscapedPath(), qt.Equals, "/api/v4/groups/bigcorp%2Fplatform/projects")
+		c.Check(r.URL.Query().Get("include_subgroups"), qt.Equals, "true")
+
+		if r.URL.Query().Get("page") == "1" {
+			w.Header().Set("X-Next-Page", "2")
+			w.Write([]byte(`[{"id": 1, "path": "api", "path_with_namespace": "bigcorp/platform/api",
+				"default_branch": "main", "namespace": {"full_path": "bigcorp/platform"}}]`))
+			return
+		}
+
+		w.Write([]byte(`[{"id": 2, "path": "web", "path_with_namespace": "bigcorp/platform/web",
+			"default_branch": "master", "namespace": {"full_path": "bigcorp/platform"},
+			"description": "Web frontend", "tag_list": ["frontend"], "visibility": "internal",
+			"forked_from_project": {"id": 1}, "archived": true, "star_count": 3,
+			"last_activity_at": "2021-10-01T12:00:00Z", "web_url": "https://gitlab.com/bigcorp/platform/web"}]`))
+	})
+
+	repos, err := client.ListRepos(context.Background(), &internal.Source{
+		Kind: internal.SourceOrg,
+		Org:  "bigcorp/platform",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.DeepEquals, []*internal.Repository{
+		{
+			RemoteID: 1,
+			Nwo:      "bigcorp/platform/api",
+			Owner:    "bigcorp/platform",
+			Name:     "api",
+			Branch:   "main",
+		},
+		{
+			RemoteID: 2,
+			Nwo:      "bigcorp/platform/web",
+			Owner:    "bigcorp/platform",
+			Name:     "web",
+			Branch:   "master",
+			Metadata: internal.Metadata{
+				Topics:      []string{"frontend"},
+				Description: "Web frontend",
+				Visibility:  internal.VisibilityInternal,
+				Fork:        true,
+				Archived:    true,
+				Stars:       3,
+				PushedAt:    time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC),
+				HTMLURL:     "https://gitlab.com/bigcorp/platform/web",
+			},
+		},
+	})
+	c.Assert(client.Calls(), qt.Equals, int64(2))
+
+	_, err = client.ListRepos(context.Background(), &internal.Source{Kind: internal.SourceTeam})
+	c.Assert(err, qt.ErrorMatches, `source kind "team" is not supported by GitLab`)
+}
+
+func TestClient_Branch(t *testing.T) {
+	c := qt.New(t)
+
+	committed := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/bigcorp%2Fapi/repository/branches/main":
+			b := branch{Name: "main"}
+			b.Commit.ID = "123"
+			b.Commit.CommittedDate = committed
+			json.NewEncoder(w).Encode(b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "404 Branch Not Found"}`))
+		}
+	})
+
+	ctx := context.Background()
+	repo := &internal.Repository{Nwo: "bigcorp/api", Owner: "bigcorp", Name: "api"}
+
+	b, err := client.Branch(ctx, repo, "main")
+	c.Assert(err, qt.IsNil)
+	c.Assert(b, qt.DeepEquals, &internal.Branch{
+		Name:      "main",
+		SHA:       "123",
+		UpdatedAt: committed,
+	})
+
+	_, err = client.Branch(ctx, repo, "missing")
+	c.Assert(errors.Is(err, internal.ErrBranchNotFound), qt.IsTrue)
+
+	c.Assert(client.CloneURL(repo), qt.Matches, `http://127\.0\.0\.1:\d+/bigcorp/api\.git`)
+}
+
+func TestClient_Branch_emptyRepo(t *testing.T) {
+	c := qt.New(t)
+
+	// without a name, the path lists all branches
+	client, _ := newTestClient(c, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]branch{})
+	})
+
+	repo := &internal.Repository{Nwo: "bigcorp/empty", Owner: "bigcorp", Name: "empty"}
+	_, err := client.Branch(context.Background(), repo, "")
+	c.Assert(errors.Is(err, internal.ErrBranchNotFound), qt.IsTrue)
+	c.Assert(client.Calls(), qt.Equals, int64(0))
+}
+
+func TestClient_rateLimit(t *testing.T) {
+	c := qt.New(t)
+
+	client, slept := newTestClient(c, apitest.Throttle(10, "", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+
+	_, err := client.ListRepos(context.Background(), &internal.Source{
+		Kind: internal.SourceOrg,
+		Org:  "bigcorp",
+	})
+
+	var e *Error
+	c.Assert(errors.As(err, &e), qt.IsTrue)
+	c.Assert(e.StatusCode, qt.Equals, http.StatusTooManyRequests)
+	c.Assert(slept.Slept(), qt.DeepEquals, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+	}, qt.Commentf("client should back off if Retry-After is missing"))
+	c.Assert(client.Calls(), qt.Equals, int64(6))
+}
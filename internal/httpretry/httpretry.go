@@ -0,0 +1,119 @@
+// Package httpretry retries HTTP requests that are throttled by the server.
+package httpretry
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxRetries is the maximum number of retries of a single request.
+	maxRetries = 5
+
+	// baseBackoff is the wait before the first retry, if the server doesn't
+	// tell us how long to wait. It's doubled on every retry.
+	baseBackoff = time.Second
+)
+
+// Options defines the options of Do.
+type Options struct {
+	// Name of the service, used in the log messages, i.e: "GitLab".
+	Name string
+
+	// Calls is incremented atomically for every request sent, including the
+	// retries. Optional.
+	Calls *int64
+
+	// Sleep waits for the given duration, or until the context is done.
+	// Defaults to a timer, tests replace it.
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Do sends the given request with the client. If the server responds with
+// 429 Too Many Requests or 503 Service Unavailable, the request is retried
+// after the duration of the Retry-After header, or an exponential backoff if
+// it's missing. The last response is returned once the retries are
+// exhausted. The request must not have a body.
+func Do(client *http.Client, req *http.Request, opts Options) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	sleep := opts.Sleep
+	if sleep == nil {
+		sleep = sleepCtx
+	}
+
+	for attempt := 0; ; attempt++ {
+		if opts.Calls != nil {
+			atomic.AddInt64(opts.Calls, 1)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isThrottled(resp.StatusCode) || attempt == maxRetries {
+			return resp, nil
+		}
+
+		wait, ok := retryAfter(resp.Header.Get("Retry-After"))
+		if !ok {
+			wait = baseBackoff << attempt
+		}
+
+		// drain the body, so the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		resp.Body.Close()
+
+		log.Printf("[INFO] %s API responded with %q, retrying in %s", opts.Name, resp.Status, wait)
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryAfter parses the value of the Retry-After header, which is either a
+// number of seconds or an HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+
+	wait := time.Until(t)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+func isThrottled(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
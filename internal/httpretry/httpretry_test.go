@@ -0,0 +1,76 @@
+package httpretry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{value: "", ok: false},
+		{value: "5", want: 5 * time.Second, ok: true},
+		{value: "-1", ok: false},
+		{value: "soon", ok: false},
+		{value: "Wed, 21 Oct 2015 07:28:00 GMT", want: 0, ok: true}, // in the past
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			c := qt.New(t)
+
+			got, ok := retryAfter(tt.value)
+			c.Assert(ok, qt.Equals, tt.ok)
+			c.Assert(got, qt.Equals, tt.want)
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	c := qt.New(t)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	c.Cleanup(server.Close)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	c.Assert(err, qt.IsNil)
+
+	var (
+		calls int64
+		slept []time.Duration
+	)
+	resp, err := Do(nil, req, Options{
+		Calls: &calls,
+		Sleep: func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			return nil
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+
+	// other errors are not retried
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusNotFound)
+	c.Assert(slept, qt.DeepEquals, []time.Duration{time.Second, 7 * time.Second})
+	c.Assert(calls, qt.Equals, int64(3))
+}
@@ -0,0 +1,140 @@
+package jsonstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// backupSuffix is the suffix of the backup of the store, which is the
+	// state before the last write.
+	backupSuffix = ".bak"
+
+	// corruptSuffix is the suffix a corrupt store is moved to, before it's
+	// recovered from its backup.
+	corruptSuffix = ".corrupt"
+)
+
+// errCorrupt is returned if the store can't be decoded.
+var errCorrupt = errors.New("store is corrupt")
+
+// readDB reads the store at the given path and migrates it to the current
+// schema version.
+func readDB(path string) (*internalDB, error) {
+	in, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var db internalDB
+	if err := json.Unmarshal(in, &db); err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", errCorrupt, path, err)
+	}
+
+	if err := migrate(&db); err != nil {
+		return nil, err
+	}
+
+	return &db, nil
+}
+
+// writeDB writes the store to the given path atomically, by writing it to a
+// temporary file first and renaming it afterwards. The previous store is
+// kept as the backup.
+func writeDB(path string, db *internalDB) error {
+	out, err := json.MarshalIndent(db, " ", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once it's renamed
+
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// make sure the content is on disk before the file is renamed
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	if err := backup(path); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// backup replaces the backup of the store at the given path with the
+// current store. A hard link is used, so the store always exists, even if
+// the process crashes before the new store is in place.
+func backup(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	bak := path + backupSuffix
+	if err := os.Remove(bak); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Link(path, bak); err != nil {
+		// the filesystem might not support hard links
+		log.Printf("[DEBUG] linking the store backup failed, renaming it instead: %s", err)
+		return os.Rename(path, bak)
+	}
+
+	return nil
+}
+
+// recoverDB recovers the store at the given path from its backup, if
+// reading the store failed with the given error, because it's missing or
+// corrupt. A corrupt store is kept with the ".corrupt" suffix. It returns
+// nil if neither the store nor its backup exist.
+func recoverDB(path string, cause error) (*internalDB, error) {
+	missing := errors.Is(cause, os.ErrNotExist)
+	if !missing && !errors.Is(cause, errCorrupt) {
+		return nil, cause
+	}
+
+	db, err := readDB(path + backupSuffix)
+	if err != nil {
+		if missing && errors.Is(err, os.ErrNotExist) {
+			return nil, nil // new store
+		}
+
+		return nil, fmt.Errorf("%v, recovering from the backup failed: %w", cause, err)
+	}
+
+	if !missing {
+		log.Printf("[WARN] %s, recovering it from its backup %q", cause, path+backupSuffix)
+
+		if err := os.Rename(path, path+corruptSuffix); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeDB(path, db); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -2,270 +2,270 @@ package jsonstore
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/fatih/starhook/internal"
 )
 
-const dbFile = "starhook.json"
+// DBFile is the name of the store inside the repository directory.
+const DBFile = "starhook.json"
 
 var _ internal.MetadataStore = (*MetadataStore)(nil)
 
 type internalDB struct {
 	Repositories []*internal.Repository `json:"repositories"`
 
-	// Query defines the initial GitHub search query to establish the DB
-	Query string `json:"query"`
+	// Query defines the search queries of the repositories of the DB.
+	Query internal.Queries `json:"query"`
+
+	// SchemaVersion is the version of the DB's format. DBs written before
+	// the version was introduced have version 0, they're migrated to the
+	// current version once they're read.
+	SchemaVersion int `json:"schema_version"`
+
+	// NextID is the ID of the next created repository. IDs are never
+	// reused, even if the repository with the highest ID is deleted.
+	NextID int64 `json:"next_id"`
 }
 
 type MetadataStore struct {
 	path string
-	mu   sync.Mutex
+
+	mu sync.Mutex // protects the fields below
+
+	// db is the decoded store. It's only read again if the file was changed
+	// since, i.e: by a different starhook process.
+	db      *internalDB
+	modTime time.Time
+	size    int64
 }
 
-func NewMetadataStore(dir, query string) (*MetadataStore, error) {
+// NewMetadataStore opens the store inside the given directory, and creates
+// it if it doesn't exist. If the store is missing or corrupt, i.e: because
+// starhook crashed while writing it, it's recovered from its backup. The
+// queries identify the repositories of the store, opening an existing store
+// with different queries fails. Their order doesn't matter.
+func NewMetadataStore(dir string, queries ...string) (*MetadataStore, error) {
 	if _, err := os.Stat(dir); err != nil {
-		fmt.Printf("err = %+v\n", err)
 		return nil, fmt.Errorf("dir %q does not exist", dir)
 	}
 
-	reposfile := filepath.Join(dir, dbFile)
-
-	// check if the file exists
-	in, err := os.ReadFile(reposfile)
-	if err != nil && !os.IsNotExist(err) {
-		return nil, err
+	r := &MetadataStore{
+		path: filepath.Join(dir, DBFile),
 	}
 
-	// if not, create a new one
-	if os.IsNotExist(err) {
-		db := internalDB{
-			Query: query,
-		}
-
-		out, err := json.MarshalIndent(&db, " ", "  ")
+	db, err := r.load()
+	if err != nil {
+		db, err = recoverDB(r.path, err)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		if err := os.WriteFile(reposfile, []byte(out), 0666); err != nil {
-			return nil, err
+	// if it doesn't exist, create a new one
+	if db == nil {
+		db = &internalDB{
+			Query:         queries,
+			SchemaVersion: schemaVersion,
+			NextID:        1,
 		}
-	} else {
-		// check whether the query matches
-		var db internalDB
-		err = json.Unmarshal(in, &db)
-		if err != nil {
+
+		if err := r.save(db); err != nil {
 			return nil, err
 		}
+	}
 
-		if db.Query != query {
-			return nil, fmt.Errorf("store error: query mismatch\n  current: %q\n  passed : %q",
-				db.Query, query)
-		}
+	// check whether the queries match
+	if !db.Query.Equal(queries) {
+		return nil, fmt.Errorf("store error: query mismatch\n  current: %s\n  passed : %s",
+			db.Query, internal.Queries(queries))
 	}
 
-	return &MetadataStore{
-		path: reposfile,
-	}, nil
+	return r, nil
 }
 
-func (r *MetadataStore) FindRepos(ctx context.Context, filter internal.RepositoryFilter, opt internal.FindOptions) ([]*internal.Repository, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	in, err := os.ReadFile(r.path)
-	if err != nil {
-		return nil, err
-	}
-
-	var db internalDB
-	err = json.Unmarshal(in, &db)
+// load returns the decoded store. The store is read from the file only if
+// it was changed since it was read or written the last time.
+func (r *MetadataStore) load() (*internalDB, error) {
+	fi, err := os.Stat(r.path)
 	if err != nil {
 		return nil, err
 	}
 
-	return db.Repositories, nil
-}
-
-func (r *MetadataStore) FindRepo(ctx context.Context, repoID int64) (*internal.Repository, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	in, err := os.ReadFile(r.path)
-	if err != nil {
-		return nil, err
+	if r.db != nil && fi.ModTime().Equal(r.modTime) && fi.Size() == r.size {
+		return r.db, nil
 	}
 
-	var db internalDB
-	err = json.Unmarshal(in, &db)
+	db, err := readDB(r.path)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, repo := range db.Repositories {
-		if repo.ID == repoID {
-			return repo, nil
-		}
-	}
-
-	return nil, internal.ErrNotFound
+	r.db, r.modTime, r.size = db, fi.ModTime(), fi.Size()
+	return db, nil
 }
 
-func (r *MetadataStore) CreateRepo(ctx context.Context, repo *internal.Repository) (int64, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	in, err := os.ReadFile(r.path)
-	if err != nil {
-		return 0, err
-	}
+// save writes the given store to the file and keeps it as the decoded store.
+func (r *MetadataStore) save(db *internalDB) error {
+	// the file is in an unknown state if writing it fails
+	r.db = nil
 
-	var db internalDB
-	err = json.Unmarshal(in, &db)
-	if err != nil {
-		return 0, err
-	}
-
-	if len(db.Repositories) == 0 {
-		repo.ID = 1
-	} else {
-		repo.ID = int64(len(db.Repositories)) + 1
+	if err := writeDB(r.path, db); err != nil {
+		return err
 	}
 
-	now := time.Now().UTC()
-	repo.CreatedAt = now
-	repo.UpdatedAt = now
-
-	db.Repositories = append(db.Repositories, repo)
-
-	out, err := json.MarshalIndent(&db, " ", "  ")
+	fi, err := os.Stat(r.path)
 	if err != nil {
-		return 0, err
-	}
-
-	if err := os.WriteFile(r.path, out, 0644); err != nil {
-		return 0, err
+		return err
 	}
 
-	return repo.ID, nil
+	r.db, r.modTime, r.size = db, fi.ModTime(), fi.Size()
+	return nil
 }
 
-func (r *MetadataStore) UpdateRepo(ctx context.Context, by internal.RepositoryBy, upd internal.RepositoryUpdate) error {
+// Tx runs fn in a transaction. The changes are made on a copy of the
+// decoded store, which is written once fn succeeds.
+func (r *MetadataStore) Tx(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	in, err := os.ReadFile(r.path)
+	db, err := r.load()
 	if err != nil {
 		return err
 	}
 
-	var db internalDB
-	err = json.Unmarshal(in, &db)
-	if err != nil {
+	t := &tx{db: db}
+	if err := fn(t); err != nil {
 		return err
 	}
 
-	updatable := func(repo *internal.Repository) bool {
-		if by.Name != nil && *by.Name == repo.Name {
-			return true
-		}
-		if by.RepoID != nil && *by.RepoID == repo.ID {
-			return true
-		}
-
-		return false
+	if !t.changed {
+		return nil
 	}
 
-	for i, repo := range db.Repositories {
-		if !updatable(repo) {
-			continue
-		}
-
-		if upd.Nwo != nil {
-			repo.Nwo = *upd.Nwo
-		}
-
-		if upd.Owner != nil {
-			repo.Owner = *upd.Owner
-		}
+	return r.save(t.db)
+}
 
-		if upd.SHA != nil {
-			repo.SHA = *upd.SHA
-		}
+// SetQuery changes the queries that identify the repositories of the store.
+func (r *MetadataStore) SetQuery(ctx context.Context, queries ...string) error {
+	return r.Tx(ctx, func(t internal.MetadataStore) error {
+		t.(*tx).writable().Query = queries
+		return nil
+	})
+}
 
-		if upd.BranchUpdatedAt != nil {
-			repo.BranchUpdatedAt = *upd.BranchUpdatedAt
-		}
+// NextID returns the ID of the next created repository.
+func (r *MetadataStore) NextID(ctx context.Context) (id int64, err error) {
+	err = r.Tx(ctx, func(t internal.MetadataStore) error {
+		id = t.(*tx).db.NextID
+		return nil
+	})
+	return id, err
+}
 
-		if upd.SyncedAt != nil {
-			repo.SyncedAt = *upd.SyncedAt
-		}
+func (r *MetadataStore) FindRepos(ctx context.Context, filter internal.RepositoryFilter, opt internal.FindOptions) (repos []*internal.Repository, err error) {
+	err = r.Tx(ctx, func(tx internal.MetadataStore) error {
+		repos, err = tx.FindRepos(ctx, filter, opt)
+		return err
+	})
+	return repos, err
+}
 
-		repo.UpdatedAt = time.Now().UTC()
-		db.Repositories[i] = repo
-	}
+func (r *MetadataStore) FindRepo(ctx context.Context, repoID int64) (repo *internal.Repository, err error) {
+	err = r.Tx(ctx, func(tx internal.MetadataStore) error {
+		repo, err = tx.FindRepo(ctx, repoID)
+		return err
+	})
+	return repo, err
+}
 
-	out, err := json.MarshalIndent(&db, " ", "  ")
-	if err != nil {
+func (r *MetadataStore) FindReposByIDs(ctx context.Context, ids []int64) (repos []*internal.Repository, err error) {
+	err = r.Tx(ctx, func(tx internal.MetadataStore) error {
+		repos, err = tx.FindReposByIDs(ctx, ids)
 		return err
-	}
+	})
+	return repos, err
+}
 
-	if err := os.WriteFile(r.path, out, 0644); err != nil {
+func (r *MetadataStore) CreateRepo(ctx context.Context, repo *internal.Repository) (id int64, err error) {
+	err = r.Tx(ctx, func(tx internal.MetadataStore) error {
+		id, err = tx.CreateRepo(ctx, repo)
 		return err
-	}
+	})
+	return id, err
+}
 
-	return nil
+func (r *MetadataStore) UpsertRepos(ctx context.Context, repos []*internal.Repository) error {
+	return r.Tx(ctx, func(tx internal.MetadataStore) error {
+		return tx.UpsertRepos(ctx, repos)
+	})
 }
 
-func (r *MetadataStore) DeleteRepo(ctx context.Context, by internal.RepositoryBy) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *MetadataStore) UpdateRepo(ctx context.Context, by internal.RepositoryBy, upd internal.RepositoryUpdate) error {
+	return r.Tx(ctx, func(tx internal.MetadataStore) error {
+		return tx.UpdateRepo(ctx, by, upd)
+	})
+}
 
-	in, err := os.ReadFile(r.path)
-	if err != nil {
-		return err
-	}
+func (r *MetadataStore) DeleteRepo(ctx context.Context, by internal.RepositoryBy) error {
+	return r.Tx(ctx, func(tx internal.MetadataStore) error {
+		return tx.DeleteRepo(ctx, by)
+	})
+}
 
-	var db internalDB
-	err = json.Unmarshal(in, &db)
-	if err != nil {
-		return err
+// sortRepos sorts the given repositories by the field of the given options.
+// Repositories with the same value are sorted by their ID.
+func sortRepos(repos []*internal.Repository, opt internal.FindOptions) {
+	var less func(a, b *internal.Repository) bool
+	switch opt.SortBy {
+	case internal.SortByName:
+		less = func(a, b *internal.Repository) bool { return a.Nwo < b.Nwo }
+	case internal.SortBySyncedAt:
+		less = func(a, b *internal.Repository) bool { return a.SyncedAt.Before(b.SyncedAt) }
+	case internal.SortByBranchUpdatedAt:
+		less = func(a, b *internal.Repository) bool { return a.BranchUpdatedAt.Before(b.BranchUpdatedAt) }
+	case internal.SortByCreatedAt:
+		less = func(a, b *internal.Repository) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case internal.SortByStars:
+		less = func(a, b *internal.Repository) bool { return a.Stars < b.Stars }
+	case internal.SortByPushedAt:
+		less = func(a, b *internal.Repository) bool { return a.PushedAt.Before(b.PushedAt) }
+	default:
+		less = func(a, b *internal.Repository) bool { return false }
 	}
 
-	deleteAble := func(repo *internal.Repository) bool {
-		if by.Name != nil && *by.Name == repo.Name {
-			return true
+	sort.SliceStable(repos, func(i, j int) bool {
+		a, b := repos[i], repos[j]
+		if opt.Descending {
+			a, b = b, a
 		}
-		if by.RepoID != nil && *by.RepoID == repo.ID {
+
+		if less(a, b) {
 			return true
 		}
-
-		return false
-	}
-
-	ix := 0 // to be deleted
-	for i, repo := range db.Repositories {
-		if !deleteAble(repo) {
-			continue
+		if less(b, a) {
+			return false
 		}
-		ix = i
-	}
+		return a.ID < b.ID
+	})
+}
 
-	db.Repositories = append(db.Repositories[:ix], db.Repositories[ix+1:]...)
-	out, err := json.MarshalIndent(&db, " ", "  ")
-	if err != nil {
-		return err
+// paginate returns the page of the given repositories selected by the
+// offset and limit of the given options.
+func paginate(repos []*internal.Repository, opt internal.FindOptions) []*internal.Repository {
+	if opt.Offset >= len(repos) {
+		return []*internal.Repository{}
 	}
+	repos = repos[opt.Offset:]
 
-	if err := os.WriteFile(r.path, out, 0644); err != nil {
-		return err
+	if opt.Limit > 0 && opt.Limit < len(repos) {
+		repos = repos[:opt.Limit]
 	}
 
-	return nil
+	return repos
 }
@@ -3,8 +3,12 @@ package jsonstore
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/fatih/starhook/internal"
 
@@ -53,6 +57,45 @@ func TestNewMetadataStore_existingFile(t *testing.T) {
 	c.Assert(string(out), qt.Equals, content)
 }
 
+func TestNewMetadataStore_SetQuery(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.SetQuery(context.Background(), "other:query"), qt.IsNil)
+
+	_, err = NewMetadataStore(dir, "other:query")
+	c.Assert(err, qt.IsNil)
+
+	_, err = NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.ErrorMatches, `(?s)store error: query mismatch.*`)
+}
+
+func TestNewMetadataStore_multipleQueries(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	_, err := NewMetadataStore(dir, "org:fatih", "topic:vim")
+	c.Assert(err, qt.IsNil)
+
+	// the order of the queries doesn't matter
+	_, err = NewMetadataStore(dir, "topic:vim", "org:fatih")
+	c.Assert(err, qt.IsNil)
+
+	_, err = NewMetadataStore(dir, "org:fatih")
+	c.Assert(err, qt.ErrorMatches, `(?s)store error: query mismatch.*`)
+
+	out, err := os.ReadFile(filepath.Join(dir, DBFile))
+	c.Assert(err, qt.IsNil)
+
+	var db struct {
+		Query []string `json:"query"`
+	}
+	c.Assert(json.Unmarshal(out, &db), qt.IsNil)
+	c.Assert(db.Query, qt.DeepEquals, []string{"org:fatih", "topic:vim"})
+}
+
 func TestNewMetadataStore_CreateRepo(t *testing.T) {
 	c := qt.New(t)
 	dir := c.Mkdir()
@@ -225,3 +268,543 @@ func TestNewMetadataStore_DeleteRepo(t *testing.T) {
 	c.Assert(err, qt.IsNil)
 	c.Assert(repos, qt.HasLen, 1)
 }
+
+func TestNewMetadataStore_FindRepos_filter(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	yes, no := true, false
+
+	repos := []*internal.Repository{
+		// synced a day ago, up to date
+		{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go", Branch: "master",
+			SyncedAt: now.Add(-24 * time.Hour), BranchUpdatedAt: now.Add(-48 * time.Hour),
+			Metadata: internal.Metadata{Language: "Vim Script", Topics: []string{"vim", "go"}, Visibility: "public", Stars: 15000}},
+		// synced a week ago, stale
+		{Nwo: "fatih/color", Owner: "fatih", Name: "color", Branch: "main",
+			SyncedAt: now.Add(-7 * 24 * time.Hour), BranchUpdatedAt: now.Add(-time.Hour),
+			Metadata: internal.Metadata{Language: "Go", Topics: []string{"cli"}, Visibility: "public", Stars: 6000}},
+		// never synced
+		{Nwo: "golang/go", Owner: "golang", Name: "go", Branch: "master",
+			Metadata: internal.Metadata{Language: "Go", Topics: []string{"go", "language"}, Visibility: "public", Stars: 100000}},
+		// failed
+		{Nwo: "golang/vim-go", Owner: "golang", Name: "vim-go", Branch: "main",
+			SyncedAt: now.Add(-time.Hour), SyncError: "exit status 128",
+			Metadata: internal.Metadata{Language: "Vim Script", Visibility: "private", Archived: true, Fork: true, Stars: 1}},
+	}
+
+	for _, repo := range repos {
+		_, err := store.CreateRepo(ctx, repo)
+		c.Assert(err, qt.IsNil)
+	}
+
+	tests := []struct {
+		name   string
+		filter internal.RepositoryFilter
+		want   []string
+	}{
+		{
+			name:   "all",
+			filter: internal.RepositoryFilter{},
+			want:   []string{"fatih/vim-go", "fatih/color", "golang/go", "golang/vim-go"},
+		},
+		{
+			name:   "owner",
+			filter: internal.RepositoryFilter{Owner: "golang"},
+			want:   []string{"golang/go", "golang/vim-go"},
+		},
+		{
+			name:   "name glob",
+			filter: internal.RepositoryFilter{Name: "vim-*"},
+			want:   []string{"fatih/vim-go", "golang/vim-go"},
+		},
+		{
+			name:   "name with owner glob",
+			filter: internal.RepositoryFilter{Name: "fatih/*"},
+			want:   []string{"fatih/vim-go", "fatih/color"},
+		},
+		{
+			name:   "branch",
+			filter: internal.RepositoryFilter{Branch: "main"},
+			want:   []string{"fatih/color", "golang/vim-go"},
+		},
+		{
+			name:   "never synced",
+			filter: internal.RepositoryFilter{State: internal.SyncStateNever},
+			want:   []string{"golang/go"},
+		},
+		{
+			name:   "stale",
+			filter: internal.RepositoryFilter{State: internal.SyncStateStale},
+			want:   []string{"fatih/color"},
+		},
+		{
+			name:   "failed",
+			filter: internal.RepositoryFilter{State: internal.SyncStateFailed},
+			want:   []string{"golang/vim-go"},
+		},
+		{
+			name:   "synced after",
+			filter: internal.RepositoryFilter{SyncedAfter: now.Add(-2 * 24 * time.Hour)},
+			want:   []string{"fatih/vim-go", "golang/vim-go"},
+		},
+		{
+			name:   "synced before",
+			filter: internal.RepositoryFilter{SyncedBefore: now.Add(-2 * time.Hour)},
+			want:   []string{"fatih/vim-go", "fatih/color"},
+		},
+		{
+			name:   "language",
+			filter: internal.RepositoryFilter{Language: "go"},
+			want:   []string{"fatih/color", "golang/go"},
+		},
+		{
+			name:   "topic",
+			filter: internal.RepositoryFilter{Topic: "go"},
+			want:   []string{"fatih/vim-go", "golang/go"},
+		},
+		{
+			name:   "visibility",
+			filter: internal.RepositoryFilter{Visibility: internal.VisibilityPrivate},
+			want:   []string{"golang/vim-go"},
+		},
+		{
+			name:   "archived",
+			filter: internal.RepositoryFilter{Archived: &yes},
+			want:   []string{"golang/vim-go"},
+		},
+		{
+			name:   "not forked",
+			filter: internal.RepositoryFilter{Fork: &no},
+			want:   []string{"fatih/vim-go", "fatih/color", "golang/go"},
+		},
+		{
+			name: "multiple",
+			filter: internal.RepositoryFilter{
+				Owner:       "fatih",
+				SyncedAfter: now.Add(-2 * 24 * time.Hour),
+			},
+			want: []string{"fatih/vim-go"},
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			repos, err := store.FindRepos(ctx, tt.filter, internal.FindOptions{})
+			c.Assert(err, qt.IsNil)
+			c.Assert(nwos(repos), qt.DeepEquals, tt.want)
+		})
+	}
+
+	_, err = store.FindRepos(ctx, internal.RepositoryFilter{State: "unknown"}, internal.FindOptions{})
+	c.Assert(err, qt.ErrorMatches, `unknown sync state "unknown".*`)
+
+	_, err = store.FindRepos(ctx, internal.RepositoryFilter{Name: "["}, internal.FindOptions{})
+	c.Assert(err, qt.ErrorMatches, `invalid name pattern "\["`+`.*`)
+}
+
+func TestNewMetadataStore_FindRepos_options(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	repos := []*internal.Repository{
+		{Nwo: "fatih/vim-go", SyncedAt: now.Add(-time.Hour)},
+		{Nwo: "fatih/color", SyncedAt: now.Add(-3 * time.Hour)},
+		{Nwo: "golang/go", SyncedAt: now.Add(-2 * time.Hour)},
+		{Nwo: "fatih/structs", SyncedAt: now.Add(-2 * time.Hour)},
+	}
+
+	for _, repo := range repos {
+		_, err := store.CreateRepo(ctx, repo)
+		c.Assert(err, qt.IsNil)
+	}
+
+	tests := []struct {
+		name string
+		opt  internal.FindOptions
+		want []string
+	}{
+		{
+			name: "default",
+			opt:  internal.FindOptions{},
+			want: []string{"fatih/vim-go", "fatih/color", "golang/go", "fatih/structs"},
+		},
+		{
+			name: "name",
+			opt:  internal.FindOptions{SortBy: internal.SortByName},
+			want: []string{"fatih/color", "fatih/structs", "fatih/vim-go", "golang/go"},
+		},
+		{
+			name: "synced at, ties sorted by id",
+			opt:  internal.FindOptions{SortBy: internal.SortBySyncedAt},
+			want: []string{"fatih/color", "golang/go", "fatih/structs", "fatih/vim-go"},
+		},
+		{
+			name: "descending",
+			opt:  internal.FindOptions{SortBy: internal.SortBySyncedAt, Descending: true},
+			want: []string{"fatih/vim-go", "fatih/structs", "golang/go", "fatih/color"},
+		},
+		{
+			name: "limit",
+			opt:  internal.FindOptions{SortBy: internal.SortByName, Limit: 2},
+			want: []string{"fatih/color", "fatih/structs"},
+		},
+		{
+			name: "offset",
+			opt:  internal.FindOptions{SortBy: internal.SortByName, Offset: 2, Limit: 25},
+			want: []string{"fatih/vim-go", "golang/go"},
+		},
+		{
+			name: "offset out of range",
+			opt:  internal.FindOptions{Offset: 10},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, tt.opt)
+			c.Assert(err, qt.IsNil)
+			c.Assert(nwos(repos), qt.DeepEquals, tt.want)
+		})
+	}
+
+	_, err = store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{SortBy: "forks"})
+	c.Assert(err, qt.ErrorMatches, `unknown sort field "forks".*`)
+}
+
+func nwos(repos []*internal.Repository) []string {
+	out := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		out = append(out, repo.Nwo)
+	}
+	return out
+}
+
+func TestNewMetadataStore_backup(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+	ctx := context.Background()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	_, err = store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+	c.Assert(err, qt.IsNil)
+	_, err = store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/color"})
+	c.Assert(err, qt.IsNil)
+
+	// the backup is the state before the last write
+	db, err := readDB(store.path + backupSuffix)
+	c.Assert(err, qt.IsNil)
+	c.Assert(nwos(db.Repositories), qt.DeepEquals, []string{"fatih/vim-go"})
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 2)
+}
+
+func TestNewMetadataStore_recover(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		corrupt func(c *qt.C, path string)
+	}{
+		{
+			name: "truncated",
+			corrupt: func(c *qt.C, path string) {
+				c.Assert(os.Truncate(path, 10), qt.IsNil)
+			},
+		},
+		{
+			name: "empty",
+			corrupt: func(c *qt.C, path string) {
+				c.Assert(os.Truncate(path, 0), qt.IsNil)
+			},
+		},
+		{
+			name: "missing",
+			corrupt: func(c *qt.C, path string) {
+				c.Assert(os.Remove(path), qt.IsNil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			dir := c.Mkdir()
+
+			store, err := NewMetadataStore(dir, "test:query")
+			c.Assert(err, qt.IsNil)
+
+			_, err = store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+			c.Assert(err, qt.IsNil)
+			_, err = store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/color"})
+			c.Assert(err, qt.IsNil)
+
+			tt.corrupt(c, store.path)
+
+			store, err = NewMetadataStore(dir, "test:query")
+			c.Assert(err, qt.IsNil)
+
+			repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+			c.Assert(err, qt.IsNil)
+			c.Assert(nwos(repos), qt.DeepEquals, []string{"fatih/vim-go"})
+		})
+	}
+}
+
+func TestNewMetadataStore_recover_noBackup(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(os.WriteFile(store.path, []byte(`{"query": "te`), 0o644), qt.IsNil)
+
+	_, err = NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.ErrorMatches, `store is corrupt: .*, recovering from the backup failed: .*`)
+
+	// the corrupt store is left as it is
+	out, err := os.ReadFile(store.path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(out), qt.Equals, `{"query": "te`)
+}
+
+func TestNewMetadataStore_DeleteRepo_notFound(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	ctx := context.Background()
+	_, err = store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+	c.Assert(err, qt.IsNil)
+
+	id := int64(42)
+	err = store.DeleteRepo(ctx, internal.RepositoryBy{RepoID: &id})
+	c.Assert(errors.Is(err, internal.ErrNotFound), qt.IsTrue)
+
+	repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+}
+
+func TestNewMetadataStore_CreateRepo_afterDelete(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	ctx := context.Background()
+	id1, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+	c.Assert(err, qt.IsNil)
+	id2, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/color"})
+	c.Assert(err, qt.IsNil)
+
+	err = store.DeleteRepo(ctx, internal.RepositoryBy{RepoID: &id1})
+	c.Assert(err, qt.IsNil)
+
+	// IDs are never reused
+	id3, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/structs"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(id3, qt.Equals, id2+1)
+
+	rp, err := store.FindRepo(ctx, id2)
+	c.Assert(err, qt.IsNil)
+	c.Assert(rp.Nwo, qt.Equals, "fatih/color")
+}
+
+func TestNewMetadataStore_migrate(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	// written before the schema version was introduced, fatih/structs reused
+	// the ID of fatih/color after fatih/vim-go was deleted
+	content := `{
+ "query": "test:query",
+ "repositories": [
+  {"ID": 2, "Nwo": "fatih/color"},
+  {"ID": 2, "Nwo": "fatih/structs"},
+  {"ID": 0, "Nwo": "fatih/camelcase"}
+ ]
+}`
+	err := os.WriteFile(filepath.Join(dir, DBFile), []byte(content), 0o644)
+	c.Assert(err, qt.IsNil)
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	// opening the store doesn't change it
+	out, err := os.ReadFile(store.path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(out), qt.Equals, content)
+
+	ctx := context.Background()
+	repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+
+	ids := make(map[string]int64)
+	for _, repo := range repos {
+		ids[repo.Nwo] = repo.ID
+	}
+	c.Assert(ids, qt.DeepEquals, map[string]int64{
+		"fatih/color":     2,
+		"fatih/structs":   3,
+		"fatih/camelcase": 4,
+	})
+
+	id, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(id, qt.Equals, int64(5))
+
+	db, err := readDB(store.path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(db.SchemaVersion, qt.Equals, schemaVersion)
+	c.Assert(db.NextID, qt.Equals, int64(6))
+}
+
+func TestNewMetadataStore_newerSchemaVersion(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	content := fmt.Sprintf(`{"query": "test:query", "schema_version": %d}`, schemaVersion+1)
+	err := os.WriteFile(filepath.Join(dir, DBFile), []byte(content), 0o644)
+	c.Assert(err, qt.IsNil)
+
+	_, err = NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.ErrorMatches, `store has schema version \d+, but only up to \d+ is supported.*`)
+}
+
+func TestNewMetadataStore_Tx(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	ctx := context.Background()
+	id, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+	c.Assert(err, qt.IsNil)
+
+	before, err := os.Stat(store.path)
+	c.Assert(err, qt.IsNil)
+
+	repos := []*internal.Repository{
+		{ID: id, Nwo: "fatih/vim-go", SHA: "123"},
+		{Nwo: "fatih/color"},
+		{Nwo: "fatih/structs"},
+	}
+
+	var found []*internal.Repository
+	err = store.Tx(ctx, func(tx internal.MetadataStore) error {
+		if err := tx.UpsertRepos(ctx, repos); err != nil {
+			return err
+		}
+
+		var err error
+		found, err = tx.FindReposByIDs(ctx, []int64{repos[2].ID, repos[0].ID})
+		return err
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(nwos(found), qt.DeepEquals, []string{"fatih/structs", "fatih/vim-go"})
+	c.Assert(found[1].SHA, qt.Equals, "123")
+	c.Assert(repos[1].ID, qt.Equals, id+1)
+	c.Assert(repos[2].ID, qt.Equals, id+2)
+
+	// the store is read from the file, and written only once
+	out, err := os.ReadFile(store.path + backupSuffix)
+	c.Assert(err, qt.IsNil)
+	c.Assert(int64(len(out)), qt.Equals, before.Size())
+
+	db, err := readDB(store.path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(nwos(db.Repositories), qt.DeepEquals, []string{"fatih/vim-go", "fatih/color", "fatih/structs"})
+}
+
+func TestNewMetadataStore_Tx_rollback(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	ctx := context.Background()
+	id, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+	c.Assert(err, qt.IsNil)
+
+	errFailed := errors.New("failed")
+	err = store.Tx(ctx, func(tx internal.MetadataStore) error {
+		name := "gh-ost"
+		if err := tx.UpdateRepo(ctx, internal.RepositoryBy{RepoID: &id}, internal.RepositoryUpdate{Name: &name}); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/color"}); err != nil {
+			return err
+		}
+
+		return errFailed
+	})
+	c.Assert(errors.Is(err, errFailed), qt.IsTrue)
+
+	repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+	c.Assert(repos[0].Name, qt.Equals, "")
+
+	_, err = store.FindReposByIDs(ctx, []int64{id, id + 1})
+	c.Assert(errors.Is(err, internal.ErrNotFound), qt.IsTrue)
+
+	err = store.UpsertRepos(ctx, []*internal.Repository{{ID: 42, Nwo: "fatih/color"}})
+	c.Assert(errors.Is(err, internal.ErrNotFound), qt.IsTrue)
+}
+
+func TestNewMetadataStore_cache(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	ctx := context.Background()
+	id, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+	c.Assert(err, qt.IsNil)
+
+	// returned repositories are copies of the stored ones
+	rp, err := store.FindRepo(ctx, id)
+	c.Assert(err, qt.IsNil)
+	rp.Nwo = "fatih/color"
+
+	rp, err = store.FindRepo(ctx, id)
+	c.Assert(err, qt.IsNil)
+	c.Assert(rp.Nwo, qt.Equals, "fatih/vim-go")
+
+	// the store is read again once it's changed by a different store
+	other, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+
+	_, err = other.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/structs"})
+	c.Assert(err, qt.IsNil)
+
+	repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(nwos(repos), qt.DeepEquals, []string{"fatih/vim-go", "fatih/structs"})
+}
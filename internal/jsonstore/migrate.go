@@ -0,0 +1,65 @@
+package jsonstore
+
+import (
+	"fmt"
+	"log"
+)
+
+// migrations upgrade the DB from one schema version to the next one, the
+// migration at index i upgrades version i to i+1. New migrations are only
+// appended, existing ones must not be changed.
+var migrations = []func(db *internalDB) error{
+	migrateIDs,
+}
+
+// schemaVersion is the current version of the DB's format.
+var schemaVersion = len(migrations)
+
+// migrate upgrades the given DB to the current schema version. The DB is
+// only migrated in memory, it's written with the new version on the next
+// write.
+func migrate(db *internalDB) error {
+	if db.SchemaVersion > schemaVersion {
+		return fmt.Errorf("store has schema version %d, but only up to %d is supported. Please upgrade starhook",
+			db.SchemaVersion, schemaVersion)
+	}
+
+	for v := db.SchemaVersion; v < schemaVersion; v++ {
+		if err := migrations[v](db); err != nil {
+			return fmt.Errorf("migrating the store from schema version %d to %d failed: %w", v, v+1, err)
+		}
+		db.SchemaVersion = v + 1
+	}
+
+	return nil
+}
+
+// migrateIDs introduces NextID. Before, IDs were derived from the number of
+// repositories, hence a repository created after a delete might have reused
+// an existing ID. Duplicate IDs are replaced with new IDs, the first
+// repository with an ID keeps it.
+func migrateIDs(db *internalDB) error {
+	var maxID int64
+	for _, repo := range db.Repositories {
+		if repo.ID > maxID {
+			maxID = repo.ID
+		}
+	}
+
+	db.NextID = maxID + 1
+
+	seen := make(map[int64]bool, len(db.Repositories))
+	for _, repo := range db.Repositories {
+		if repo.ID > 0 && !seen[repo.ID] {
+			seen[repo.ID] = true
+			continue
+		}
+
+		log.Printf("[DEBUG] store: repository %q has a duplicate ID %d, assigning ID %d",
+			repo.Nwo, repo.ID, db.NextID)
+		repo.ID = db.NextID
+		db.NextID++
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package jsonstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+)
+
+var _ internal.MetadataStore = (*tx)(nil)
+
+// tx operates on a decoded store. The store is copied before it's changed
+// the first time, hence the decoded store of MetadataStore is never changed
+// by a failed transaction.
+type tx struct {
+	db      *internalDB
+	changed bool
+}
+
+// writable returns the store to change.
+func (t *tx) writable() *internalDB {
+	if t.changed {
+		return t.db
+	}
+
+	db := *t.db
+	db.Repositories = make([]*internal.Repository, 0, len(t.db.Repositories))
+	for _, repo := range t.db.Repositories {
+		db.Repositories = append(db.Repositories, clone(repo))
+	}
+
+	t.db = &db
+	t.changed = true
+	return t.db
+}
+
+// clone returns a copy of the given repository. Repositories are copied
+// in and out of the store, so they're never changed by the caller.
+func clone(repo *internal.Repository) *internal.Repository {
+	rp := *repo
+	if repo.Topics != nil {
+		rp.Topics = append([]string{}, repo.Topics...)
+	}
+	return &rp
+}
+
+// Tx runs fn as part of the current transaction.
+func (t *tx) Tx(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
+	return fn(t)
+}
+
+func (t *tx) FindRepos(ctx context.Context, filter internal.RepositoryFilter, opt internal.FindOptions) ([]*internal.Repository, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+
+	repos := make([]*internal.Repository, 0, len(t.db.Repositories))
+	for _, repo := range t.db.Repositories {
+		if filter.Match(repo) {
+			repos = append(repos, clone(repo))
+		}
+	}
+
+	sortRepos(repos, opt)
+	return paginate(repos, opt), nil
+}
+
+func (t *tx) FindRepo(ctx context.Context, repoID int64) (*internal.Repository, error) {
+	for _, repo := range t.db.Repositories {
+		if repo.ID == repoID {
+			return clone(repo), nil
+		}
+	}
+
+	return nil, internal.ErrNotFound
+}
+
+func (t *tx) FindReposByIDs(ctx context.Context, ids []int64) ([]*internal.Repository, error) {
+	byID := make(map[int64]*internal.Repository, len(t.db.Repositories))
+	for _, repo := range t.db.Repositories {
+		byID[repo.ID] = repo
+	}
+
+	repos := make([]*internal.Repository, 0, len(ids))
+	for _, id := range ids {
+		repo, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("repository %d: %w", id, internal.ErrNotFound)
+		}
+
+		repos = append(repos, clone(repo))
+	}
+
+	return repos, nil
+}
+
+func (t *tx) CreateRepo(ctx context.Context, repo *internal.Repository) (int64, error) {
+	db := t.writable()
+
+	repo.ID = db.NextID
+	db.NextID++
+
+	now := time.Now().UTC()
+	repo.CreatedAt = now
+	repo.UpdatedAt = now
+
+	db.Repositories = append(db.Repositories, clone(repo))
+	return repo.ID, nil
+}
+
+func (t *tx) UpsertRepos(ctx context.Context, repos []*internal.Repository) error {
+	if len(repos) == 0 {
+		return nil
+	}
+
+	db := t.writable()
+
+	index := make(map[int64]int, len(db.Repositories))
+	for i, repo := range db.Repositories {
+		index[repo.ID] = i
+	}
+
+	now := time.Now().UTC()
+	for _, repo := range repos {
+		if repo.ID == 0 {
+			if _, err := t.CreateRepo(ctx, repo); err != nil {
+				return err
+			}
+			continue
+		}
+
+		i, ok := index[repo.ID]
+		if !ok {
+			return fmt.Errorf("repository %d: %w", repo.ID, internal.ErrNotFound)
+		}
+
+		repo.CreatedAt = db.Repositories[i].CreatedAt
+		repo.UpdatedAt = now
+		db.Repositories[i] = clone(repo)
+	}
+
+	return nil
+}
+
+func (t *tx) UpdateRepo(ctx context.Context, by internal.RepositoryBy, upd internal.RepositoryUpdate) error {
+	updatable := func(repo *internal.Repository) bool {
+		if by.Name != nil && *by.Name == repo.Name {
+			return true
+		}
+		if by.Nwo != nil && *by.Nwo == repo.Nwo {
+			return true
+		}
+		if by.RepoID != nil && *by.RepoID == repo.ID {
+			return true
+		}
+
+		return false
+	}
+
+	var matched []int
+	for i, repo := range t.db.Repositories {
+		if updatable(repo) {
+			matched = append(matched, i)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil
+	}
+
+	db := t.writable()
+	for _, i := range matched {
+		repo := db.Repositories[i]
+
+		if upd.Nwo != nil {
+			repo.Nwo = *upd.Nwo
+		}
+
+		if upd.Owner != nil {
+			repo.Owner = *upd.Owner
+		}
+
+		if upd.Name != nil {
+			repo.Name = *upd.Name
+		}
+
+		if upd.RemoteID != nil {
+			repo.RemoteID = *upd.RemoteID
+		}
+
+		if upd.Branch != nil {
+			repo.Branch = *upd.Branch
+		}
+
+		if upd.SHA != nil {
+			repo.SHA = *upd.SHA
+		}
+
+		if upd.BranchUpdatedAt != nil {
+			repo.BranchUpdatedAt = *upd.BranchUpdatedAt
+		}
+
+		if upd.SyncedAt != nil {
+			repo.SyncedAt = *upd.SyncedAt
+		}
+
+		if upd.SyncError != nil {
+			repo.SyncError = *upd.SyncError
+		}
+
+		repo.UpdatedAt = time.Now().UTC()
+	}
+
+	return nil
+}
+
+func (t *tx) DeleteRepo(ctx context.Context, by internal.RepositoryBy) error {
+	deleteAble := func(repo *internal.Repository) bool {
+		if by.Name != nil && *by.Name == repo.Name {
+			return true
+		}
+		if by.Nwo != nil && *by.Nwo == repo.Nwo {
+			return true
+		}
+		if by.RepoID != nil && *by.RepoID == repo.ID {
+			return true
+		}
+
+		return false
+	}
+
+	db := t.writable()
+
+	repos := make([]*internal.Repository, 0, len(db.Repositories))
+	for _, repo := range db.Repositories {
+		if !deleteAble(repo) {
+			repos = append(repos, repo)
+		}
+	}
+
+	if len(repos) == len(db.Repositories) {
+		return internal.ErrNotFound
+	}
+
+	db.Repositories = repos
+	return nil
+}
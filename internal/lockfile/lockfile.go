@@ -0,0 +1,53 @@
+// Package lockfile provides advisory file locks, which prevent multiple
+// starhook processes from modifying the same reposet at once.
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrLocked is returned if the lock is held by another process.
+var ErrLocked = errors.New("locked by another process")
+
+// Lock is an advisory lock on a file. The lock is released by the operating
+// system if the process exits, hence a crashed process never leaves a stale
+// lock behind.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire acquires the lock on the file at the given path, the file is
+// created if it doesn't exist. It doesn't block, if the lock is held by
+// another process, ErrLocked is returned.
+func Acquire(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// the PID of the holder helps to find the process, it's not used for
+	// locking
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%d\n", os.Getpid())
+	}
+
+	return &Lock{f: f}, nil
+}
+
+// Release releases the lock. The file is not removed, because another
+// process might be waiting to lock it already.
+func (l *Lock) Release() error {
+	if err := unlock(l.f); err != nil {
+		l.f.Close()
+		return err
+	}
+
+	return l.f.Close()
+}
@@ -0,0 +1,28 @@
+package lockfile
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestAcquire(t *testing.T) {
+	c := qt.New(t)
+	path := filepath.Join(c.Mkdir(), "starhook.lock")
+
+	l, err := Acquire(path)
+	c.Assert(err, qt.IsNil)
+
+	// flock locks are per open file, hence a second open conflicts even
+	// within the same process
+	_, err = Acquire(path)
+	c.Assert(errors.Is(err, ErrLocked), qt.IsTrue, qt.Commentf("got: %v", err))
+
+	c.Assert(l.Release(), qt.IsNil)
+
+	l, err = Acquire(path)
+	c.Assert(err, qt.IsNil)
+	c.Assert(l.Release(), qt.IsNil)
+}
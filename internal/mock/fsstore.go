@@ -2,6 +2,7 @@ package mock
 
 import (
 	"context"
+	"sync"
 
 	"github.com/fatih/starhook/internal"
 )
@@ -10,6 +11,8 @@ var _ internal.RepositoryStore = (*RepositoryStore)(nil)
 
 // RepositoryStore represents a mock implementation of internal.RepositoryStore.
 type RepositoryStore struct {
+	mu sync.Mutex // protects the *Invoked fields
+
 	CreateRepoFn      func(ctx context.Context, repo *internal.Repository) error
 	CreateRepoInvoked bool
 
@@ -18,22 +21,79 @@ type RepositoryStore struct {
 
 	DeleteRepoFn      func(ctx context.Context, repo *internal.Repository) error
 	DeleteRepoInvoked bool
+
+	MoveRepoFn      func(ctx context.Context, from, to *internal.Repository) error
+	MoveRepoInvoked bool
+
+	SwitchBranchFn      func(ctx context.Context, repo *internal.Repository, from string) error
+	SwitchBranchInvoked bool
+
+	ArchiveRepoFn      func(ctx context.Context, repo *internal.Repository) (string, error)
+	ArchiveRepoInvoked bool
+
+	LocalChangesFn      func(ctx context.Context, repo *internal.Repository) ([]string, error)
+	LocalChangesInvoked bool
 }
 
 // CreateRepository creates a single repository and returns the ID.
 func (r *RepositoryStore) CreateRepo(ctx context.Context, repo *internal.Repository) error {
+	r.mu.Lock()
 	r.CreateRepoInvoked = true
+	r.mu.Unlock()
+
 	return r.CreateRepoFn(ctx, repo)
 }
 
 // UpdateRepo updates a single repository
 func (r *RepositoryStore) UpdateRepo(ctx context.Context, opt internal.UpdateOptions, repo *internal.Repository) error {
+	r.mu.Lock()
 	r.UpdateRepoInvoked = true
+	r.mu.Unlock()
+
 	return r.UpdateRepoFn(ctx, opt, repo)
 }
 
 // DeleteRepo deletes a single repository
 func (r *RepositoryStore) DeleteRepo(ctx context.Context, repo *internal.Repository) error {
+	r.mu.Lock()
 	r.DeleteRepoInvoked = true
+	r.mu.Unlock()
+
 	return r.DeleteRepoFn(ctx, repo)
 }
+
+// MoveRepo moves a single repository
+func (r *RepositoryStore) MoveRepo(ctx context.Context, from, to *internal.Repository) error {
+	r.mu.Lock()
+	r.MoveRepoInvoked = true
+	r.mu.Unlock()
+
+	return r.MoveRepoFn(ctx, from, to)
+}
+
+// SwitchBranch switches the default branch of a single repository
+func (r *RepositoryStore) SwitchBranch(ctx context.Context, repo *internal.Repository, from string) error {
+	r.mu.Lock()
+	r.SwitchBranchInvoked = true
+	r.mu.Unlock()
+
+	return r.SwitchBranchFn(ctx, repo, from)
+}
+
+// ArchiveRepo archives a single repository
+func (r *RepositoryStore) ArchiveRepo(ctx context.Context, repo *internal.Repository) (string, error) {
+	r.mu.Lock()
+	r.ArchiveRepoInvoked = true
+	r.mu.Unlock()
+
+	return r.ArchiveRepoFn(ctx, repo)
+}
+
+// LocalChanges returns the local changes of a single repository
+func (r *RepositoryStore) LocalChanges(ctx context.Context, repo *internal.Repository) ([]string, error) {
+	r.mu.Lock()
+	r.LocalChangesInvoked = true
+	r.mu.Unlock()
+
+	return r.LocalChangesFn(ctx, repo)
+}
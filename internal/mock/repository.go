@@ -2,6 +2,7 @@ package mock
 
 import (
 	"context"
+	"sync"
 
 	"github.com/fatih/starhook/internal"
 )
@@ -10,48 +11,101 @@ var _ internal.MetadataStore = (*MetadataStore)(nil)
 
 // MetadataStore represents a mock implementation of internal.MetadataStore.
 type MetadataStore struct {
+	mu sync.Mutex // protects the *Invoked fields
+
 	FindReposFn      func(ctx context.Context, filter internal.RepositoryFilter, opt internal.FindOptions) ([]*internal.Repository, error)
 	FindReposInvoked bool
 
 	FindRepoFn      func(ctx context.Context, repoID int64) (*internal.Repository, error)
 	FindRepoInvoked bool
 
+	FindReposByIDsFn      func(ctx context.Context, ids []int64) ([]*internal.Repository, error)
+	FindReposByIDsInvoked bool
+
 	CreateRepoFn      func(ctx context.Context, repo *internal.Repository) (int64, error)
 	CreateRepoInvoked bool
 
+	UpsertReposFn      func(ctx context.Context, repos []*internal.Repository) error
+	UpsertReposInvoked bool
+
 	UpdateRepoFn      func(ctx context.Context, by internal.RepositoryBy, upd internal.RepositoryUpdate) error
 	UpdateRepoInvoked bool
 
 	DeleteRepoFn      func(ctx context.Context, by internal.RepositoryBy) error
 	DeleteRepoInvoked bool
+
+	TxFn      func(ctx context.Context, fn func(tx internal.MetadataStore) error) error
+	TxInvoked bool
 }
 
 // FindRepositories returns a list of repositories
 func (r *MetadataStore) FindRepos(ctx context.Context, filter internal.RepositoryFilter, opt internal.FindOptions) ([]*internal.Repository, error) {
+	r.mu.Lock()
 	r.FindReposInvoked = true
+	r.mu.Unlock()
+
 	return r.FindReposFn(ctx, filter, opt)
 }
 
 // FindRepo returns the *Repository with the given ID
 func (r *MetadataStore) FindRepo(ctx context.Context, repoID int64) (*internal.Repository, error) {
+	r.mu.Lock()
 	r.FindRepoInvoked = true
+	r.mu.Unlock()
+
 	return r.FindRepoFn(ctx, repoID)
 }
 
+// FindReposByIDs returns the repositories with the given IDs
+func (r *MetadataStore) FindReposByIDs(ctx context.Context, ids []int64) ([]*internal.Repository, error) {
+	r.mu.Lock()
+	r.FindReposByIDsInvoked = true
+	r.mu.Unlock()
+
+	return r.FindReposByIDsFn(ctx, ids)
+}
+
 // CreateRepository creates a single repository and returns the ID.
 func (r *MetadataStore) CreateRepo(ctx context.Context, repo *internal.Repository) (int64, error) {
+	r.mu.Lock()
 	r.CreateRepoInvoked = true
+	r.mu.Unlock()
+
 	return r.CreateRepoFn(ctx, repo)
 }
 
+// UpsertRepos creates or updates the given repositories
+func (r *MetadataStore) UpsertRepos(ctx context.Context, repos []*internal.Repository) error {
+	r.mu.Lock()
+	r.UpsertReposInvoked = true
+	r.mu.Unlock()
+
+	return r.UpsertReposFn(ctx, repos)
+}
+
 // UpdateRepo updates a single repository
 func (r *MetadataStore) UpdateRepo(ctx context.Context, by internal.RepositoryBy, upd internal.RepositoryUpdate) error {
+	r.mu.Lock()
 	r.UpdateRepoInvoked = true
+	r.mu.Unlock()
+
 	return r.UpdateRepoFn(ctx, by, upd)
 }
 
 // DeleteRepo deletes a single repository
 func (r *MetadataStore) DeleteRepo(ctx context.Context, by internal.RepositoryBy) error {
+	r.mu.Lock()
 	r.DeleteRepoInvoked = true
+	r.mu.Unlock()
+
 	return r.DeleteRepoFn(ctx, by)
 }
+
+// Tx runs fn in a transaction
+func (r *MetadataStore) Tx(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
+	r.mu.Lock()
+	r.TxInvoked = true
+	r.mu.Unlock()
+
+	return r.TxFn(ctx, fn)
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrBranchNotFound is returned if a branch doesn't exist.
+var ErrBranchNotFound = errors.New("branch not found")
+
+// Branch is the head of a branch.
+type Branch struct {
+	Name      string
+	SHA       string
+	UpdatedAt time.Time
+}
+
+// Provider is a code hosting service, such as GitHub, that repositories are
+// synced from.
+type Provider interface {
+	// ListRepos lists the repositories of the given source.
+	ListRepos(ctx context.Context, source *Source) ([]*Repository, error)
+
+	// DefaultBranches returns the heads of the default branches of the
+	// given repositories, by their Nwo. A nil branch means the repository
+	// is empty. It's a best effort to resolve many repositories at once,
+	// repositories that are missing are resolved with Branch.
+	DefaultBranches(ctx context.Context, repos []*Repository) (map[string]*Branch, error)
+
+	// Branch returns the head of the given branch of a repository. It
+	// returns ErrBranchNotFound if the branch doesn't exist.
+	Branch(ctx context.Context, repo *Repository, branch string) (*Branch, error)
+
+	// CloneURL returns the HTTPS URL to clone the given repository.
+	CloneURL(repo *Repository) string
+}
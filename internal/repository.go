@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
+	"strings"
 	"time"
 )
 
@@ -16,6 +18,11 @@ type Repository struct {
 	Owner string // i.e: fatih, github
 	Name  string // i.e: vim-go, gh-ost
 
+	// RemoteID is the ID of the repository on GitHub. Unlike the name, it
+	// doesn't change if the repository is renamed or transferred. A zero
+	// value means it's not known yet.
+	RemoteID int64
+
 	// Branch defines the default branch, usually it's main, but people can
 	// change it.
 	Branch string //
@@ -29,25 +36,250 @@ type Repository struct {
 	// BranchUpdatedAt defines the time the branch was updated on GitHub
 	BranchUpdatedAt time.Time
 
+	// SyncError is the error of the last failed sync operation. It's empty
+	// if the last sync succeeded. Failed repositories are retried first.
+	SyncError string
+
+	// Metadata is updated with the fetched repository on every sync.
+	Metadata
+
 	CreatedAt time.Time // time this object was created in the store
 	UpdatedAt time.Time // time this object was updated in the store
 }
 
-type RepositoryFilter struct{}
+// Visibilities of a repository.
+const (
+	VisibilityPublic   = "public"
+	VisibilityPrivate  = "private"
+	VisibilityInternal = "internal"
+)
+
+// Metadata is the information about a repository the provider returns with
+// the repository. Fields that aren't supported by a provider are empty.
+type Metadata struct {
+	Language    string // primary language, i.e: Go
+	Topics      []string
+	Description string
+
+	// Visibility is either "public", "private" or "internal".
+	Visibility string
+
+	Fork     bool
+	Archived bool
+
+	Size     int64     // size in kilobytes
+	Stars    int64     // number of stargazers
+	PushedAt time.Time // time of the last push to any branch
+	HTMLURL  string    // URL of the repository's web page
+}
+
+// Equal reports whether m and other are the same.
+func (m Metadata) Equal(other Metadata) bool {
+	if len(m.Topics) != len(other.Topics) {
+		return false
+	}
+	for i := range m.Topics {
+		if m.Topics[i] != other.Topics[i] {
+			return false
+		}
+	}
+
+	return m.Language == other.Language &&
+		m.Description == other.Description &&
+		m.Visibility == other.Visibility &&
+		m.Fork == other.Fork &&
+		m.Archived == other.Archived &&
+		m.Size == other.Size &&
+		m.Stars == other.Stars &&
+		m.PushedAt.Equal(other.PushedAt) &&
+		m.HTMLURL == other.HTMLURL
+}
+
+// HasTopic reports whether the repository has the given topic.
+func (m Metadata) HasTopic(topic string) bool {
+	for _, t := range m.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncState is the state of a repository's local copy.
+type SyncState string
+
+const (
+	// SyncStateNever selects repositories that were never synced (cloned)
+	// locally.
+	SyncStateNever SyncState = "never"
+
+	// SyncStateStale selects repositories whose local copy is behind the
+	// remote branch.
+	SyncStateStale SyncState = "stale"
+
+	// SyncStateFailed selects repositories whose last sync failed.
+	SyncStateFailed SyncState = "failed"
+)
+
+// ParseSyncState parses the given sync state. An empty state selects all
+// repositories.
+func ParseSyncState(state string) (SyncState, error) {
+	switch s := SyncState(state); s {
+	case "", SyncStateNever, SyncStateStale, SyncStateFailed:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown sync state %q, should be one of: %s, %s, %s",
+			state, SyncStateNever, SyncStateStale, SyncStateFailed)
+	}
+}
+
+// RepositoryFilter selects repositories. Empty fields select all
+// repositories.
+type RepositoryFilter struct {
+	// Owner selects the repositories of the given owner.
+	Owner string
+
+	// Name is a glob pattern, such as "vim-*", matched against the name of
+	// the repositories. If it contains a "/", it's matched against the
+	// name with owner instead.
+	Name string
+
+	// Branch selects the repositories with the given default branch.
+	Branch string
+
+	// State selects the repositories with the given sync state.
+	State SyncState
+
+	// SyncedAfter and SyncedBefore select the repositories that were last
+	// synced within the given range.
+	SyncedAfter  time.Time
+	SyncedBefore time.Time
+
+	// Language selects the repositories with the given primary language.
+	// It's matched case-insensitively.
+	Language string
+
+	// Topic selects the repositories with the given topic.
+	Topic string
+
+	// Visibility selects the repositories with the given visibility.
+	Visibility string
+
+	// Archived and Fork select archived or forked repositories if they're
+	// true, and the other repositories if they're false.
+	Archived *bool
+	Fork     *bool
+}
+
+// Validate checks whether the filter is valid.
+func (f RepositoryFilter) Validate() error {
+	if _, err := path.Match(f.Name, ""); err != nil {
+		return fmt.Errorf("invalid name pattern %q: %w", f.Name, err)
+	}
+
+	if _, err := ParseSyncState(string(f.State)); err != nil {
+		return err
+	}
+
+	switch f.Visibility {
+	case "", VisibilityPublic, VisibilityPrivate, VisibilityInternal:
+	default:
+		return fmt.Errorf("unknown visibility %q, should be one of: %s, %s, %s",
+			f.Visibility, VisibilityPublic, VisibilityPrivate, VisibilityInternal)
+	}
+
+	return nil
+}
+
+// Match reports whether the given repository is selected by the filter.
+func (f RepositoryFilter) Match(repo *Repository) bool {
+	if f.Owner != "" && f.Owner != repo.Owner {
+		return false
+	}
+
+	if f.Name != "" {
+		name := repo.Name
+		if strings.Contains(f.Name, "/") {
+			name = repo.Nwo
+		}
+
+		if ok, _ := path.Match(f.Name, name); !ok {
+			return false
+		}
+	}
+
+	if f.Branch != "" && f.Branch != repo.Branch {
+		return false
+	}
+
+	switch f.State {
+	case SyncStateNever:
+		if !repo.SyncedAt.IsZero() {
+			return false
+		}
+	case SyncStateStale:
+		if repo.SyncedAt.IsZero() || !repo.SyncedAt.Before(repo.BranchUpdatedAt) {
+			return false
+		}
+	case SyncStateFailed:
+		if repo.SyncError == "" {
+			return false
+		}
+	}
+
+	if !f.SyncedAfter.IsZero() && !repo.SyncedAt.After(f.SyncedAfter) {
+		return false
+	}
+
+	if !f.SyncedBefore.IsZero() && (repo.SyncedAt.IsZero() || !repo.SyncedAt.Before(f.SyncedBefore)) {
+		return false
+	}
+
+	if f.Language != "" && !strings.EqualFold(f.Language, repo.Language) {
+		return false
+	}
+
+	if f.Topic != "" && !repo.HasTopic(f.Topic) {
+		return false
+	}
+
+	if f.Visibility != "" && f.Visibility != repo.Visibility {
+		return false
+	}
+
+	if f.Archived != nil && *f.Archived != repo.Archived {
+		return false
+	}
+
+	if f.Fork != nil && *f.Fork != repo.Fork {
+		return false
+	}
+
+	return true
+}
 
 // RepositoryUpdate is used to update a Repository's fields.
 type RepositoryUpdate struct {
 	Nwo             *string
 	Owner           *string
+	Name            *string
+	RemoteID        *int64
+	Branch          *string
 	SHA             *string
 	SyncedAt        *time.Time
 	BranchUpdatedAt *time.Time
+	SyncError       *string
 }
 
 // RepositoryBy is used to select a repository to update.
 type RepositoryBy struct {
 	RepoID *int64
-	Name   *string
+	Nwo    *string
+
+	// Name selects repositories by their name only. Repositories with the
+	// same name from different owners are all selected, prefer RepoID or
+	// Nwo instead.
+	Name *string
 }
 
 // MetadataStore manages the information about repositories.
@@ -58,14 +290,30 @@ type MetadataStore interface {
 	// FindRepo returns the *Repository with the given ID
 	FindRepo(ctx context.Context, repoID int64) (*Repository, error)
 
+	// FindReposByIDs returns the repositories with the given IDs, in the
+	// same order. It returns ErrNotFound if any of them doesn't exist.
+	FindReposByIDs(ctx context.Context, ids []int64) ([]*Repository, error)
+
 	// CreateRepository creates a single repository and returns the ID.
 	CreateRepo(ctx context.Context, repo *Repository) (int64, error)
 
+	// UpsertRepos creates the given repositories without an ID and sets
+	// their ID. All fields of the other repositories are replaced, except
+	// their creation time. It returns ErrNotFound if any of them doesn't
+	// exist.
+	UpsertRepos(ctx context.Context, repos []*Repository) error
+
 	// UpdateRepo updates a single repository
 	UpdateRepo(ctx context.Context, by RepositoryBy, upd RepositoryUpdate) error
 
-	// DeleteRepo deletes a single repository
+	// DeleteRepo deletes the repositories selected by the given selector.
+	// It returns ErrNotFound if no repository is selected.
 	DeleteRepo(ctx context.Context, by RepositoryBy) error
+
+	// Tx runs fn in a transaction. All changes made via the given store are
+	// applied at once if fn succeeds, and discarded otherwise. fn must only
+	// use the given store.
+	Tx(ctx context.Context, fn func(tx MetadataStore) error) error
 }
 
 // UpdateOptions defines the options for a n
@@ -73,6 +321,45 @@ type UpdateOptions struct {
 	ForceClean bool
 }
 
+// RemovePolicy defines what happens to a local repository once it's no
+// longer part of a reposet.
+type RemovePolicy string
+
+const (
+	// RemoveDelete deletes the repository from the filesystem. Repositories
+	// with local changes are archived instead.
+	RemoveDelete RemovePolicy = "delete"
+
+	// RemoveArchive moves the repository into the archive directory.
+	RemoveArchive RemovePolicy = "archive"
+
+	// RemoveKeep keeps the repository on the filesystem, but stops tracking
+	// it.
+	RemoveKeep RemovePolicy = "keep"
+)
+
+// DefaultRemovePolicy is the policy used if no policy is defined.
+const DefaultRemovePolicy = RemoveArchive
+
+// ParseRemovePolicy parses the given policy. An empty policy returns
+// DefaultRemovePolicy.
+func ParseRemovePolicy(policy string) (RemovePolicy, error) {
+	switch p := RemovePolicy(policy); p {
+	case "":
+		return DefaultRemovePolicy, nil
+	case RemoveDelete, RemoveArchive, RemoveKeep:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown remove policy %q, should be one of: %s, %s, %s",
+			policy, RemoveDelete, RemoveArchive, RemoveKeep)
+	}
+}
+
+// DeleteOptions defines the options to delete a repository.
+type DeleteOptions struct {
+	Policy RemovePolicy
+}
+
 // RepositoryStore manages the repositories on a filesystem.
 type RepositoryStore interface {
 	// CreateRepo creates a single repository.
@@ -83,6 +370,23 @@ type RepositoryStore interface {
 
 	// DeleteRepo deletes a single repository.
 	DeleteRepo(ctx context.Context, repo *Repository) error
+
+	// MoveRepo moves a single repository to the location of the renamed
+	// repository.
+	MoveRepo(ctx context.Context, from, to *Repository) error
+
+	// SwitchBranch fetches the default branch of a single repository and
+	// switches to it, if the previous default branch is checked out.
+	SwitchBranch(ctx context.Context, repo *Repository, from string) error
+
+	// ArchiveRepo moves a single repository into the archive and returns
+	// its new location.
+	ArchiveRepo(ctx context.Context, repo *Repository) (string, error)
+
+	// LocalChanges returns the local changes of a single repository that
+	// would be lost if it's deleted, such as uncommitted changes, stashes
+	// or unpushed commits.
+	LocalChanges(ctx context.Context, repo *Repository) ([]string, error)
 }
 
 // DefaultFindOptions is the default option to be used with Find* methods
@@ -90,15 +394,49 @@ var DefaultFindOptions = FindOptions{
 	Limit: 25,
 }
 
+// Fields to sort repositories by.
+const (
+	SortByID              = "id"
+	SortByName            = "name" // name with owner
+	SortBySyncedAt        = "synced_at"
+	SortByBranchUpdatedAt = "branch_updated_at"
+	SortByCreatedAt       = "created_at"
+	SortByStars           = "stars"
+	SortByPushedAt        = "pushed_at"
+)
+
 // FindOptions is passed to methods who require to specifcy how to find their
 // resources.
 type FindOptions struct {
-	Offset     int
-	Limit      int
-	SortBy     string
+	// Offset is the number of resources to skip.
+	Offset int
+
+	// Limit is the maximum number of resources to return. Zero means no
+	// limit.
+	Limit int
+
+	// SortBy is the field to sort by. Defaults to SortByID.
+	SortBy string
+
+	// Descending reverses the order.
 	Descending bool
 }
 
+// Validate checks whether the options are valid.
+func (f FindOptions) Validate() error {
+	if f.Offset < 0 || f.Limit < 0 {
+		return errors.New("offset and limit should not be negative")
+	}
+
+	switch f.SortBy {
+	case "", SortByID, SortByName, SortBySyncedAt, SortByBranchUpdatedAt, SortByCreatedAt, SortByStars, SortByPushedAt:
+		return nil
+	default:
+		return fmt.Errorf("unknown sort field %q, should be one of: %s, %s, %s, %s, %s, %s, %s",
+			f.SortBy, SortByID, SortByName, SortBySyncedAt, SortByBranchUpdatedAt, SortByCreatedAt, SortByStars, SortByPushedAt)
+	}
+}
+
 // SortByDirection returns the sort directive for a given resource.
 func (f FindOptions) SortByDirection() string {
 	if f.Descending {
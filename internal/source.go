@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Source kinds define where the repositories of a reposet are fetched from.
+const (
+	// SourceSearch fetches the repositories with the reposet's search query.
+	SourceSearch = "search"
+
+	// SourceOrg lists all repositories of an organization (or group),
+	// including private and internal repositories.
+	SourceOrg = "org"
+
+	// SourceUser lists all repositories owned by a user.
+	SourceUser = "user"
+
+	// SourceStarred lists all repositories starred by a user.
+	SourceStarred = "starred"
+
+	// SourceTeam lists all repositories of an organization's team.
+	SourceTeam = "team"
+
+	// SourceList fetches an explicit list of repositories.
+	SourceList = "list"
+
+	// SourceDir lists all bare repositories of a local directory. It's
+	// only supported by the file provider.
+	SourceDir = "dir"
+)
+
+// Source defines where the repositories of a reposet are fetched from.
+type Source struct {
+	// Kind is one of "search", "org", "user", "starred", "team", "list"
+	// or "dir".
+	Kind string `json:"kind"`
+
+	// Query are the search queries of the "search" kind. The repositories
+	// matching any of the queries are fetched.
+	Query Queries `json:"query,omitempty"`
+
+	// Org is the organization of the "org" and "team" kinds.
+	Org string `json:"org,omitempty"`
+
+	// Team is the slug of the team of the "team" kind.
+	Team string `json:"team,omitempty"`
+
+	// User is the user of the "user" and "starred" kinds. If empty, the
+	// authenticated user is used.
+	User string `json:"user,omitempty"`
+
+	// Repos is the list of repositories of the "list" kind, in the form of
+	// "owner/name". For the file provider, they're paths or file:// URLs of
+	// bare repositories.
+	Repos []string `json:"repos,omitempty"`
+
+	// Path is the directory of the "dir" kind.
+	Path string `json:"path,omitempty"`
+}
+
+// Validate checks whether the source has all the fields its kind requires.
+func (s *Source) Validate() error {
+	switch s.Kind {
+	case SourceSearch, SourceUser, SourceStarred:
+	case SourceOrg:
+		if s.Org == "" {
+			return errors.New("org source requires an organization")
+		}
+	case SourceTeam:
+		if s.Org == "" || s.Team == "" {
+			return errors.New("team source requires an organization and a team")
+		}
+	case SourceList:
+		if len(s.Repos) == 0 {
+			return errors.New("list source requires at least one repository")
+		}
+
+		// owners might be nested, i.e: GitLab's subgroups
+		for _, repo := range s.Repos {
+			i := strings.LastIndex(repo, "/")
+			if i <= 0 || i == len(repo)-1 {
+				return fmt.Errorf("invalid repository %q, should be in the form of 'owner/name'", repo)
+			}
+		}
+	case SourceDir:
+		if s.Path == "" {
+			return errors.New("dir source requires a path")
+		}
+	default:
+		return fmt.Errorf("unknown source kind %q, should be one of %q, %q, %q, %q, %q, %q or %q",
+			s.Kind, SourceSearch, SourceOrg, SourceUser, SourceStarred, SourceTeam, SourceList, SourceDir)
+	}
+
+	return nil
+}
+
+// String returns a short description of the source, such as "org:github".
+func (s *Source) String() string {
+	switch s.Kind {
+	case SourceOrg:
+		return "org:" + s.Org
+	case SourceUser, SourceStarred:
+		if s.User == "" {
+			return s.Kind
+		}
+		return s.Kind + ":" + s.User
+	case SourceTeam:
+		return "team:" + s.Org + "/" + s.Team
+	case SourceList:
+		return "list:" + strings.Join(s.Repos, ",")
+	case SourceDir:
+		return "dir:" + s.Path
+	default:
+		return s.Kind
+	}
+}
+
+// Queries is a list of search queries. A single query is encoded as a
+// string in JSON, hence existing configurations with a single query are
+// decoded and encoded unchanged.
+type Queries []string
+
+// MarshalJSON implements json.Marshaler.
+func (q Queries) MarshalJSON() ([]byte, error) {
+	if len(q) == 1 {
+		return json.Marshal(q[0])
+	}
+	return json.Marshal([]string(q))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts a string or a list
+// of strings.
+func (q *Queries) UnmarshalJSON(data []byte) error {
+	var query string
+	if err := json.Unmarshal(data, &query); err == nil {
+		*q = nil
+		if query != "" {
+			*q = Queries{query}
+		}
+		return nil
+	}
+
+	var queries []string
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return fmt.Errorf("query should be a string or a list of strings: %w", err)
+	}
+
+	*q = queries
+	return nil
+}
+
+// Equal reports whether both lists have the same queries, in any order.
+func (q Queries) Equal(other Queries) bool {
+	if len(q) != len(other) {
+		return false
+	}
+
+	a := append([]string{}, q...)
+	b := append([]string{}, other...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the quoted queries separated by commas.
+func (q Queries) String() string {
+	quoted := make([]string, 0, len(q))
+	for _, query := range q {
+		quoted = append(quoted, fmt.Sprintf("%q", query))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Set adds the given query. It allows passing a flag multiple times.
+func (q *Queries) Set(query string) error {
+	*q = append(*q, query)
+	return nil
+}
@@ -0,0 +1,709 @@
+// Package sqlitestore stores the metadata of repositories in a SQLite
+// database.
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// DBFile is the name of the database inside the repository directory.
+const DBFile = "starhook.db"
+
+var (
+	_ internal.MetadataStore = (*MetadataStore)(nil)
+	_ internal.MetadataStore = (*txStore)(nil)
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS repositories (
+	id                INTEGER PRIMARY KEY AUTOINCREMENT,
+	nwo               TEXT NOT NULL,
+	owner             TEXT NOT NULL,
+	name              TEXT NOT NULL,
+	remote_id         INTEGER NOT NULL DEFAULT 0,
+	branch            TEXT NOT NULL DEFAULT '',
+	sha               TEXT NOT NULL DEFAULT '',
+	synced_at         INTEGER NOT NULL DEFAULT 0,
+	branch_updated_at INTEGER NOT NULL DEFAULT 0,
+	sync_error        TEXT NOT NULL DEFAULT '',
+	created_at        INTEGER NOT NULL DEFAULT 0,
+	updated_at        INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS repositories_nwo ON repositories (nwo);
+CREATE INDEX IF NOT EXISTS repositories_name ON repositories (name);
+`
+
+// migrations are applied in order to databases with a lower user_version.
+// The first one creates the initial schema, which already exists in
+// databases created before the schema was versioned.
+var migrations = []string{
+	schema,
+	`ALTER TABLE repositories ADD COLUMN language TEXT NOT NULL DEFAULT '';
+	ALTER TABLE repositories ADD COLUMN topics TEXT NOT NULL DEFAULT '[]';
+	ALTER TABLE repositories ADD COLUMN description TEXT NOT NULL DEFAULT '';
+	ALTER TABLE repositories ADD COLUMN visibility TEXT NOT NULL DEFAULT '';
+	ALTER TABLE repositories ADD COLUMN fork INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE repositories ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE repositories ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE repositories ADD COLUMN stars INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE repositories ADD COLUMN pushed_at INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE repositories ADD COLUMN html_url TEXT NOT NULL DEFAULT '';`,
+}
+
+// fields are the columns of the fields of a repository, in the order of
+// fieldValues. The ID and the timestamps of the store are not included.
+var fields = []string{
+	"nwo", "owner", "name", "remote_id", "branch", "sha", "synced_at",
+	"branch_updated_at", "sync_error", "language", "topics", "description",
+	"visibility", "fork", "archived", "size", "stars", "pushed_at", "html_url",
+}
+
+// columns are the columns of a repository, in the order they're scanned.
+var columns = "id, " + strings.Join(fields, ", ") + ", created_at, updated_at"
+
+// fieldValues returns the values of the fields of the given repository.
+func fieldValues(repo *internal.Repository) []interface{} {
+	topics := repo.Topics
+	if topics == nil {
+		topics = []string{}
+	}
+	encoded, _ := json.Marshal(topics) // can't fail for strings
+
+	return []interface{}{
+		repo.Nwo, repo.Owner, repo.Name, repo.RemoteID, repo.Branch, repo.SHA,
+		toUnix(repo.SyncedAt), toUnix(repo.BranchUpdatedAt), repo.SyncError,
+		repo.Language, string(encoded), repo.Description, repo.Visibility,
+		repo.Fork, repo.Archived, repo.Size, repo.Stars, toUnix(repo.PushedAt),
+		repo.HTMLURL,
+	}
+}
+
+// placeholders returns n comma separated placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// sortColumns are the columns of the fields repositories are sorted by.
+var sortColumns = map[string]string{
+	"":                             "id",
+	internal.SortByID:              "id",
+	internal.SortByName:            "nwo",
+	internal.SortBySyncedAt:        "synced_at",
+	internal.SortByBranchUpdatedAt: "branch_updated_at",
+	internal.SortByCreatedAt:       "created_at",
+	internal.SortByStars:           "stars",
+	internal.SortByPushedAt:        "pushed_at",
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn implements the operations of internal.MetadataStore on the database
+// or on a transaction.
+type conn struct {
+	q querier
+}
+
+type MetadataStore struct {
+	conn
+	db *sql.DB
+}
+
+// txStore is the store of a transaction.
+type txStore struct {
+	conn
+}
+
+// NewMetadataStore opens the database inside the given directory, and
+// creates it if it doesn't exist. The queries identify the repositories of
+// the database, opening an existing database with different queries fails.
+// Their order doesn't matter.
+func NewMetadataStore(dir string, queries ...string) (*MetadataStore, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("dir %q does not exist", dir)
+	}
+
+	dsn := (&url.URL{
+		Scheme: "file",
+		Path:   filepath.Join(dir, DBFile),
+		RawQuery: url.Values{
+			"_pragma": {"busy_timeout(5000)", "journal_mode(WAL)"},
+		}.Encode(),
+	}).String()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer only, hence serialize all operations
+	// instead of failing with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	s := &MetadataStore{conn: conn{q: db}, db: db}
+	if err := s.init(queries); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// init migrates the schema to the latest version and checks whether the
+// queries match.
+func (s *MetadataStore) init(queries internal.Queries) error {
+	ctx := context.Background()
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		var version int
+		if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+			return err
+		}
+
+		if version > len(migrations) {
+			return fmt.Errorf("store has schema version %d, but only up to %d is supported. Please upgrade starhook",
+				version, len(migrations))
+		}
+
+		for ; version < len(migrations); version++ {
+			if _, err := tx.ExecContext(ctx, migrations[version]); err != nil {
+				return fmt.Errorf("migrating the store to schema version %d failed: %w", version+1, err)
+			}
+		}
+
+		// PRAGMA doesn't support parameters
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+			return err
+		}
+
+		var value string
+		err := tx.QueryRowContext(ctx, `SELECT value FROM meta WHERE key = 'query'`).Scan(&value)
+		if errors.Is(err, sql.ErrNoRows) {
+			_, err = tx.ExecContext(ctx, `INSERT INTO meta (key, value) VALUES ('query', ?)`, encodeQueries(queries))
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if current := decodeQueries(value); !current.Equal(queries) {
+			return fmt.Errorf("store error: query mismatch\n  current: %s\n  passed : %s",
+				current, queries)
+		}
+
+		return nil
+	})
+}
+
+// Close closes the database.
+func (s *MetadataStore) Close() error {
+	return s.db.Close()
+}
+
+// SetQuery changes the queries that identify the repositories of the
+// database.
+func (s *MetadataStore) SetQuery(ctx context.Context, queries ...string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE meta SET value = ? WHERE key = 'query'`, encodeQueries(queries))
+	return err
+}
+
+// encodeQueries encodes the given queries for the meta table. A single
+// query is stored as is, like before multiple queries were supported.
+func encodeQueries(queries internal.Queries) string {
+	if len(queries) == 1 {
+		return queries[0]
+	}
+
+	out, _ := json.Marshal([]string(queries))
+	return string(out)
+}
+
+// decodeQueries decodes the queries stored with encodeQueries.
+func decodeQueries(value string) internal.Queries {
+	var queries []string
+	if strings.HasPrefix(value, "[") && json.Unmarshal([]byte(value), &queries) == nil {
+		return queries
+	}
+	return internal.Queries{value}
+}
+
+func (s conn) FindRepos(ctx context.Context, filter internal.RepositoryFilter, opt internal.FindOptions) ([]*internal.Repository, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := opt.Validate(); err != nil {
+		return nil, err
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+
+	if filter.Owner != "" {
+		where = append(where, "owner = ?")
+		args = append(args, filter.Owner)
+	}
+
+	if filter.Branch != "" {
+		where = append(where, "branch = ?")
+		args = append(args, filter.Branch)
+	}
+
+	switch filter.State {
+	case internal.SyncStateNever:
+		where = append(where, "synced_at = 0")
+	case internal.SyncStateStale:
+		where = append(where, "synced_at != 0 AND synced_at < branch_updated_at")
+	case internal.SyncStateFailed:
+		where = append(where, "sync_error != ''")
+	}
+
+	if !filter.SyncedAfter.IsZero() {
+		where = append(where, "synced_at > ?")
+		args = append(args, toUnix(filter.SyncedAfter))
+	}
+
+	if !filter.SyncedBefore.IsZero() {
+		where = append(where, "synced_at != 0 AND synced_at < ?")
+		args = append(args, toUnix(filter.SyncedBefore))
+	}
+
+	if filter.Language != "" {
+		where = append(where, "language = ? COLLATE NOCASE")
+		args = append(args, filter.Language)
+	}
+
+	if filter.Topic != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(topics) WHERE value = ?)")
+		args = append(args, filter.Topic)
+	}
+
+	if filter.Visibility != "" {
+		where = append(where, "visibility = ?")
+		args = append(args, filter.Visibility)
+	}
+
+	if filter.Archived != nil {
+		where = append(where, "archived = ?")
+		args = append(args, *filter.Archived)
+	}
+
+	if filter.Fork != nil {
+		where = append(where, "fork = ?")
+		args = append(args, *filter.Fork)
+	}
+
+	q := "SELECT " + columns + " FROM repositories"
+	if len(where) != 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	dir := "ASC"
+	if opt.Descending {
+		dir = "DESC"
+	}
+	col := sortColumns[opt.SortBy]
+	q += fmt.Sprintf(" ORDER BY %s %s, id %s", col, dir, dir)
+
+	// name patterns are matched after the query, hence they're paginated
+	// afterwards as well.
+	paginate := filter.Name == ""
+	if paginate && (opt.Limit > 0 || opt.Offset > 0) {
+		limit := opt.Limit
+		if limit == 0 {
+			limit = -1 // no limit
+		}
+		q += " LIMIT ? OFFSET ?"
+		args = append(args, limit, opt.Offset)
+	}
+
+	rows, err := s.q.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	repos := []*internal.Repository{}
+	for rows.Next() {
+		repo, err := scanRepo(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if filter.Match(repo) {
+			repos = append(repos, repo)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !paginate {
+		if opt.Offset >= len(repos) {
+			return []*internal.Repository{}, nil
+		}
+		repos = repos[opt.Offset:]
+
+		if opt.Limit > 0 && opt.Limit < len(repos) {
+			repos = repos[:opt.Limit]
+		}
+	}
+
+	return repos, nil
+}
+
+func (s conn) FindRepo(ctx context.Context, repoID int64) (*internal.Repository, error) {
+	row := s.q.QueryRowContext(ctx, "SELECT "+columns+" FROM repositories WHERE id = ?", repoID)
+	repo, err := scanRepo(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, internal.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+func (s conn) FindReposByIDs(ctx context.Context, ids []int64) ([]*internal.Repository, error) {
+	byID := make(map[int64]*internal.Repository, len(ids))
+
+	// SQLite limits the number of parameters of a statement
+	const batchSize = 500
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		batch := ids[start:end]
+		args := make([]interface{}, 0, len(batch))
+		for _, id := range batch {
+			args = append(args, id)
+		}
+
+		q := "SELECT " + columns + " FROM repositories WHERE id IN (?" +
+			strings.Repeat(", ?", len(batch)-1) + ")"
+
+		rows, err := s.q.QueryContext(ctx, q, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			repo, err := scanRepo(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			byID[repo.ID] = repo
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	repos := make([]*internal.Repository, 0, len(ids))
+	for _, id := range ids {
+		repo, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("repository %d: %w", id, internal.ErrNotFound)
+		}
+
+		// the same ID might be passed more than once
+		rp := *repo
+		repos = append(repos, &rp)
+	}
+
+	return repos, nil
+}
+
+func (s conn) CreateRepo(ctx context.Context, repo *internal.Repository) (int64, error) {
+	now := time.Now().UTC()
+
+	res, err := s.q.ExecContext(ctx, "INSERT INTO repositories ("+strings.Join(fields, ", ")+
+		", created_at, updated_at) VALUES ("+placeholders(len(fields)+2)+")",
+		append(fieldValues(repo), toUnix(now), toUnix(now))...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	repo.ID = id
+	repo.CreatedAt = now
+	repo.UpdatedAt = now
+	return id, nil
+}
+
+func (s conn) UpsertRepos(ctx context.Context, repos []*internal.Repository) error {
+	now := time.Now().UTC()
+	for _, repo := range repos {
+		if repo.ID == 0 {
+			if _, err := s.CreateRepo(ctx, repo); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var createdAt int64
+		err := s.q.QueryRowContext(ctx, "UPDATE repositories SET "+
+			strings.Join(fields, " = ?, ")+" = ?, updated_at = ? WHERE id = ? RETURNING created_at",
+			append(fieldValues(repo), toUnix(now), repo.ID)...,
+		).Scan(&createdAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("repository %d: %w", repo.ID, internal.ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+
+		repo.CreatedAt = fromUnix(createdAt)
+		repo.UpdatedAt = now
+	}
+
+	return nil
+}
+
+// UpsertRepos creates or updates the given repositories in a single
+// transaction.
+func (s *MetadataStore) UpsertRepos(ctx context.Context, repos []*internal.Repository) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		return conn{q: tx}.UpsertRepos(ctx, repos)
+	})
+}
+
+// ImportRepos stores the given repositories as they are, including their
+// IDs and timestamps, i.e: to migrate them from a different store. Created
+// repositories get nextID or a higher ID, hence the IDs of repositories
+// deleted before the import aren't reused.
+func (s *MetadataStore) ImportRepos(ctx context.Context, repos []*internal.Repository, nextID int64) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		for _, repo := range repos {
+			args := append([]interface{}{repo.ID}, fieldValues(repo)...)
+			args = append(args, toUnix(repo.CreatedAt), toUnix(repo.UpdatedAt))
+
+			_, err := tx.ExecContext(ctx, "INSERT INTO repositories ("+columns+
+				") VALUES ("+placeholders(len(fields)+3)+")", args...)
+			if err != nil {
+				return fmt.Errorf("importing %q (id: %d) failed: %w", repo.Nwo, repo.ID, err)
+			}
+		}
+
+		// AUTOINCREMENT continues from the highest ID in sqlite_sequence,
+		// which otherwise is the highest imported ID
+		_, err := tx.ExecContext(ctx, `DELETE FROM sqlite_sequence WHERE name = 'repositories'`)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO sqlite_sequence (name, seq)
+			VALUES ('repositories', MAX(?, (SELECT COALESCE(MAX(id), 0) FROM repositories)))`, nextID-1)
+		return err
+	})
+}
+
+func (s conn) UpdateRepo(ctx context.Context, by internal.RepositoryBy, upd internal.RepositoryUpdate) error {
+	where, args := byClause(by)
+	if where == "" {
+		return nil
+	}
+
+	set := []string{"updated_at = ?"}
+	setArgs := []interface{}{toUnix(time.Now().UTC())}
+	add := func(column string, value interface{}) {
+		set = append(set, column+" = ?")
+		setArgs = append(setArgs, value)
+	}
+
+	if upd.Nwo != nil {
+		add("nwo", *upd.Nwo)
+	}
+	if upd.Owner != nil {
+		add("owner", *upd.Owner)
+	}
+	if upd.Name != nil {
+		add("name", *upd.Name)
+	}
+	if upd.RemoteID != nil {
+		add("remote_id", *upd.RemoteID)
+	}
+	if upd.Branch != nil {
+		add("branch", *upd.Branch)
+	}
+	if upd.SHA != nil {
+		add("sha", *upd.SHA)
+	}
+	if upd.SyncedAt != nil {
+		add("synced_at", toUnix(*upd.SyncedAt))
+	}
+	if upd.BranchUpdatedAt != nil {
+		add("branch_updated_at", toUnix(*upd.BranchUpdatedAt))
+	}
+	if upd.SyncError != nil {
+		add("sync_error", *upd.SyncError)
+	}
+
+	_, err := s.q.ExecContext(ctx,
+		"UPDATE repositories SET "+strings.Join(set, ", ")+" WHERE "+where,
+		append(setArgs, args...)...)
+	return err
+}
+
+func (s conn) DeleteRepo(ctx context.Context, by internal.RepositoryBy) error {
+	where, args := byClause(by)
+	if where == "" {
+		return internal.ErrNotFound
+	}
+
+	res, err := s.q.ExecContext(ctx, "DELETE FROM repositories WHERE "+where, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return internal.ErrNotFound
+	}
+	return nil
+}
+
+// Tx runs fn in a transaction of the database.
+func (s *MetadataStore) Tx(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
+	return s.tx(ctx, func(tx *sql.Tx) error {
+		return fn(&txStore{conn: conn{q: tx}})
+	})
+}
+
+// Tx runs fn as part of the current transaction.
+func (t *txStore) Tx(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
+	return fn(t)
+}
+
+// tx runs fn in a transaction, which is committed if fn succeeds and rolled
+// back otherwise.
+func (s *MetadataStore) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// byClause returns the WHERE clause selecting the repositories of the given
+// selector. It's empty if the selector selects nothing.
+func byClause(by internal.RepositoryBy) (string, []interface{}) {
+	var (
+		or   []string
+		args []interface{}
+	)
+
+	if by.RepoID != nil {
+		or = append(or, "id = ?")
+		args = append(args, *by.RepoID)
+	}
+	if by.Nwo != nil {
+		or = append(or, "nwo = ?")
+		args = append(args, *by.Nwo)
+	}
+	if by.Name != nil {
+		or = append(or, "name = ?")
+		args = append(args, *by.Name)
+	}
+
+	return strings.Join(or, " OR "), args
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRepo(row scanner) (*internal.Repository, error) {
+	var (
+		repo                                            internal.Repository
+		syncedAt, branchUpdatedAt, createdAt, updatedAt int64
+		pushedAt                                        int64
+		topics                                          string
+	)
+
+	err := row.Scan(&repo.ID, &repo.Nwo, &repo.Owner, &repo.Name, &repo.RemoteID,
+		&repo.Branch, &repo.SHA, &syncedAt, &branchUpdatedAt, &repo.SyncError,
+		&repo.Language, &topics, &repo.Description, &repo.Visibility,
+		&repo.Fork, &repo.Archived, &repo.Size, &repo.Stars, &pushedAt,
+		&repo.HTMLURL, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(topics), &repo.Topics); err != nil {
+		return nil, fmt.Errorf("decoding the topics of %q failed: %w", repo.Nwo, err)
+	}
+	if len(repo.Topics) == 0 {
+		repo.Topics = nil
+	}
+
+	repo.SyncedAt = fromUnix(syncedAt)
+	repo.BranchUpdatedAt = fromUnix(branchUpdatedAt)
+	repo.PushedAt = fromUnix(pushedAt)
+	repo.CreatedAt = fromUnix(createdAt)
+	repo.UpdatedAt = fromUnix(updatedAt)
+	return &repo, nil
+}
+
+// toUnix converts the given time to nanoseconds since the Unix epoch. The
+// zero time is stored as 0, so it's sorted before all other times.
+func toUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnix converts nanoseconds since the Unix epoch to a UTC time.
+func fromUnix(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
@@ -0,0 +1,455 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestNewMetadataStore(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.Close(), qt.IsNil)
+
+	// open again with the same query
+	store, err = NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.Close(), qt.IsNil)
+
+	_, err = NewMetadataStore(dir, "other:query")
+	c.Assert(err, qt.ErrorMatches, `(?s)store error: query mismatch.*`)
+}
+
+func TestMetadataStore_SetQuery(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.SetQuery(context.Background(), "other:query"), qt.IsNil)
+	c.Assert(store.Close(), qt.IsNil)
+
+	store, err = NewMetadataStore(dir, "other:query")
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.Close(), qt.IsNil)
+
+	_, err = NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.ErrorMatches, `(?s)store error: query mismatch.*`)
+}
+
+func TestNewMetadataStore_multipleQueries(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+
+	store, err := NewMetadataStore(dir, "org:fatih", "topic:vim")
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.Close(), qt.IsNil)
+
+	// the order of the queries doesn't matter
+	store, err = NewMetadataStore(dir, "topic:vim", "org:fatih")
+	c.Assert(err, qt.IsNil)
+	c.Assert(store.Close(), qt.IsNil)
+
+	_, err = NewMetadataStore(dir, "org:fatih")
+	c.Assert(err, qt.ErrorMatches, `(?s)store error: query mismatch.*`)
+}
+
+func TestMetadataStore_CreateRepo(t *testing.T) {
+	c := qt.New(t)
+	store := newStore(c)
+	ctx := context.Background()
+
+	repo := &internal.Repository{
+		Nwo:             "fatih/vim-go",
+		Owner:           "fatih",
+		Name:            "vim-go",
+		RemoteID:        100,
+		Branch:          "master",
+		SHA:             "abc",
+		BranchUpdatedAt: time.Date(2021, 10, 1, 10, 0, 0, 0, time.UTC),
+	}
+
+	id, err := store.CreateRepo(ctx, repo)
+	c.Assert(err, qt.IsNil)
+	c.Assert(id, qt.Equals, int64(1))
+	c.Assert(repo.ID, qt.Equals, id)
+	c.Assert(repo.CreatedAt.IsZero(), qt.IsFalse)
+
+	rp, err := store.FindRepo(ctx, id)
+	c.Assert(err, qt.IsNil)
+	c.Assert(rp, qt.DeepEquals, repo)
+
+	id, err = store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/color"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(id, qt.Equals, int64(2))
+
+	_, err = store.FindRepo(ctx, 3)
+	c.Assert(errors.Is(err, internal.ErrNotFound), qt.IsTrue)
+}
+
+func TestMetadataStore_CreateRepo_concurrent(t *testing.T) {
+	c := qt.New(t)
+	store := newStore(c)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+			c.Check(err, qt.IsNil)
+		}()
+	}
+	wg.Wait()
+
+	repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 50)
+}
+
+func TestMetadataStore_UpdateRepo(t *testing.T) {
+	c := qt.New(t)
+	store := newStore(c)
+	ctx := context.Background()
+
+	id, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go"})
+	c.Assert(err, qt.IsNil)
+	_, err = store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/color", Owner: "fatih", Name: "color"})
+	c.Assert(err, qt.IsNil)
+
+	syncedAt := time.Date(2021, 10, 1, 10, 0, 0, 0, time.UTC)
+	nwo, name, syncErr := "golang/vim-go", "vim-go", "exit status 1"
+
+	err = store.UpdateRepo(ctx, internal.RepositoryBy{RepoID: &id}, internal.RepositoryUpdate{
+		Nwo:       &nwo,
+		SyncedAt:  &syncedAt,
+		SyncError: &syncErr,
+	})
+	c.Assert(err, qt.IsNil)
+
+	rp, err := store.FindRepo(ctx, id)
+	c.Assert(err, qt.IsNil)
+	c.Assert(rp.Nwo, qt.Equals, nwo)
+	c.Assert(rp.Name, qt.Equals, "vim-go")
+	c.Assert(rp.SyncedAt, qt.Equals, syncedAt)
+	c.Assert(rp.SyncError, qt.Equals, syncErr)
+	c.Assert(rp.UpdatedAt.After(rp.CreatedAt), qt.IsTrue, qt.Commentf("updated_at should be updated and should have a timestamp after created_at"))
+
+	// other repositories are not updated
+	branch := "main"
+	err = store.UpdateRepo(ctx, internal.RepositoryBy{Name: &name}, internal.RepositoryUpdate{Branch: &branch})
+	c.Assert(err, qt.IsNil)
+
+	repos, err := store.FindRepos(ctx, internal.RepositoryFilter{Branch: "main"}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+	c.Assert(repos[0].ID, qt.Equals, id)
+}
+
+func TestMetadataStore_DeleteRepo(t *testing.T) {
+	c := qt.New(t)
+	store := newStore(c)
+	ctx := context.Background()
+
+	id, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+	c.Assert(err, qt.IsNil)
+	_, err = store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/color"})
+	c.Assert(err, qt.IsNil)
+
+	err = store.DeleteRepo(ctx, internal.RepositoryBy{RepoID: &id})
+	c.Assert(err, qt.IsNil)
+
+	repos, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(repos, qt.HasLen, 1)
+	c.Assert(repos[0].Nwo, qt.Equals, "fatih/color")
+
+	err = store.DeleteRepo(ctx, internal.RepositoryBy{RepoID: &id})
+	c.Assert(errors.Is(err, internal.ErrNotFound), qt.IsTrue)
+}
+
+func TestMetadataStore_FindRepos(t *testing.T) {
+	c := qt.New(t)
+	store := newStore(c)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	yes, no := true, false
+
+	repos := []*internal.Repository{
+		{Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go", Branch: "master",
+			SyncedAt: now.Add(-24 * time.Hour), BranchUpdatedAt: now.Add(-48 * time.Hour),
+			Metadata: internal.Metadata{Language: "Vim Script", Topics: []string{"vim", "go"}, Visibility: "public", Stars: 15000}},
+		{Nwo: "fatih/color", Owner: "fatih", Name: "color", Branch: "main",
+			SyncedAt: now.Add(-7 * 24 * time.Hour), BranchUpdatedAt: now.Add(-time.Hour),
+			Metadata: internal.Metadata{Language: "Go", Topics: []string{"cli"}, Visibility: "public", Stars: 6000}},
+		{Nwo: "golang/go", Owner: "golang", Name: "go", Branch: "master",
+			Metadata: internal.Metadata{Language: "Go", Topics: []string{"go", "language"}, Visibility: "public", Stars: 100000}},
+		{Nwo: "golang/vim-go", Owner: "golang", Name: "vim-go", Branch: "main",
+			SyncedAt: now.Add(-time.Hour), SyncError: "exit status 128",
+			Metadata: internal.Metadata{Language: "Vim Script", Visibility: "private", Archived: true, Fork: true, Stars: 1}},
+	}
+
+	for _, repo := range repos {
+		_, err := store.CreateRepo(ctx, repo)
+		c.Assert(err, qt.IsNil)
+	}
+
+	tests := []struct {
+		name   string
+		filter internal.RepositoryFilter
+		opt    internal.FindOptions
+		want   []string
+	}{
+		{
+			name: "all",
+			want: []string{"fatih/vim-go", "fatih/color", "golang/go", "golang/vim-go"},
+		},
+		{
+			name:   "owner",
+			filter: internal.RepositoryFilter{Owner: "golang"},
+			want:   []string{"golang/go", "golang/vim-go"},
+		},
+		{
+			name:   "name glob",
+			filter: internal.RepositoryFilter{Name: "vim-*"},
+			want:   []string{"fatih/vim-go", "golang/vim-go"},
+		},
+		{
+			name:   "stale",
+			filter: internal.RepositoryFilter{State: internal.SyncStateStale},
+			want:   []string{"fatih/color"},
+		},
+		{
+			name:   "never synced",
+			filter: internal.RepositoryFilter{State: internal.SyncStateNever},
+			want:   []string{"golang/go"},
+		},
+		{
+			name:   "failed",
+			filter: internal.RepositoryFilter{State: internal.SyncStateFailed},
+			want:   []string{"golang/vim-go"},
+		},
+		{
+			name:   "synced range",
+			filter: internal.RepositoryFilter{SyncedAfter: now.Add(-2 * 24 * time.Hour), SyncedBefore: now.Add(-2 * time.Hour)},
+			want:   []string{"fatih/vim-go"},
+		},
+		{
+			name:   "language",
+			filter: internal.RepositoryFilter{Language: "go"},
+			want:   []string{"fatih/color", "golang/go"},
+		},
+		{
+			name:   "topic",
+			filter: internal.RepositoryFilter{Topic: "go"},
+			want:   []string{"fatih/vim-go", "golang/go"},
+		},
+		{
+			name:   "visibility",
+			filter: internal.RepositoryFilter{Visibility: internal.VisibilityPrivate},
+			want:   []string{"golang/vim-go"},
+		},
+		{
+			name:   "archived",
+			filter: internal.RepositoryFilter{Archived: &yes},
+			want:   []string{"golang/vim-go"},
+		},
+		{
+			name:   "not forked",
+			filter: internal.RepositoryFilter{Fork: &no},
+			want:   []string{"fatih/vim-go", "fatih/color", "golang/go"},
+		},
+		{
+			name: "sorted by stars",
+			opt:  internal.FindOptions{SortBy: internal.SortByStars, Descending: true},
+			want: []string{"golang/go", "fatih/vim-go", "fatih/color", "golang/vim-go"},
+		},
+		{
+			name: "sorted by synced at",
+			opt:  internal.FindOptions{SortBy: internal.SortBySyncedAt, Descending: true},
+			want: []string{"golang/vim-go", "fatih/vim-go", "fatih/color", "golang/go"},
+		},
+		{
+			name: "paginated",
+			opt:  internal.FindOptions{SortBy: internal.SortByName, Offset: 1, Limit: 2},
+			want: []string{"fatih/vim-go", "golang/go"},
+		},
+		{
+			name:   "paginated name glob",
+			filter: internal.RepositoryFilter{Name: "*o*"},
+			opt:    internal.FindOptions{Offset: 1, Limit: 2},
+			want:   []string{"fatih/color", "golang/go"},
+		},
+	}
+
+	for _, tt := range tests {
+		c.Run(tt.name, func(c *qt.C) {
+			repos, err := store.FindRepos(ctx, tt.filter, tt.opt)
+			c.Assert(err, qt.IsNil)
+			c.Assert(nwos(repos), qt.DeepEquals, tt.want)
+		})
+	}
+}
+
+func TestMetadataStore_ImportRepos(t *testing.T) {
+	c := qt.New(t)
+	store := newStore(c)
+	ctx := context.Background()
+
+	createdAt := time.Date(2021, 10, 1, 10, 0, 0, 0, time.UTC)
+	repos := []*internal.Repository{
+		{ID: 3, Nwo: "fatih/vim-go", CreatedAt: createdAt, UpdatedAt: createdAt},
+		{ID: 7, Nwo: "fatih/color", CreatedAt: createdAt, UpdatedAt: createdAt},
+	}
+
+	// repositories 8 and 9 were deleted before the import
+	err := store.ImportRepos(ctx, repos, 10)
+	c.Assert(err, qt.IsNil)
+
+	rp, err := store.FindRepo(ctx, 7)
+	c.Assert(err, qt.IsNil)
+	c.Assert(rp, qt.DeepEquals, repos[1])
+
+	// new repositories don't reuse the imported or deleted IDs
+	id, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/structs"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(id, qt.Equals, int64(10))
+
+	err = store.ImportRepos(ctx, []*internal.Repository{{ID: 3, Nwo: "golang/go"}}, 1)
+	c.Assert(err, qt.ErrorMatches, `importing "golang/go" \(id: 3\) failed: .*`)
+}
+
+func TestMetadataStore_Tx(t *testing.T) {
+	c := qt.New(t)
+	store := newStore(c)
+	ctx := context.Background()
+
+	id, err := store.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/vim-go"})
+	c.Assert(err, qt.IsNil)
+
+	repos := []*internal.Repository{
+		{ID: id, Nwo: "fatih/vim-go", SHA: "123"},
+		{Nwo: "fatih/color"},
+	}
+
+	var found []*internal.Repository
+	err = store.Tx(ctx, func(tx internal.MetadataStore) error {
+		if err := tx.UpsertRepos(ctx, repos); err != nil {
+			return err
+		}
+
+		var err error
+		found, err = tx.FindReposByIDs(ctx, []int64{repos[1].ID, id})
+		return err
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(nwos(found), qt.DeepEquals, []string{"fatih/color", "fatih/vim-go"})
+	c.Assert(found[1].SHA, qt.Equals, "123")
+
+	// the creation time is kept, and set on the passed repositories
+	c.Assert(repos[0].CreatedAt.IsZero(), qt.IsFalse)
+	c.Assert(repos[0].CreatedAt, qt.Equals, found[1].CreatedAt)
+
+	// changes are rolled back if the transaction fails
+	errFailed := errors.New("failed")
+	err = store.Tx(ctx, func(tx internal.MetadataStore) error {
+		if _, err := tx.CreateRepo(ctx, &internal.Repository{Nwo: "fatih/structs"}); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	c.Assert(errors.Is(err, errFailed), qt.IsTrue)
+
+	all, err := store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(nwos(all), qt.DeepEquals, []string{"fatih/vim-go", "fatih/color"})
+
+	_, err = store.FindReposByIDs(ctx, []int64{id, 42})
+	c.Assert(errors.Is(err, internal.ErrNotFound), qt.IsTrue)
+
+	// a failed upsert doesn't create any of the repositories
+	err = store.UpsertRepos(ctx, []*internal.Repository{{Nwo: "fatih/structs"}, {ID: 42}})
+	c.Assert(errors.Is(err, internal.ErrNotFound), qt.IsTrue)
+
+	all, err = store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(all, qt.HasLen, 2)
+}
+
+func TestNewMetadataStore_migrate(t *testing.T) {
+	c := qt.New(t)
+	dir := c.Mkdir()
+	ctx := context.Background()
+
+	// a database created before the schema was versioned
+	db, err := sql.Open("sqlite", filepath.Join(dir, DBFile))
+	c.Assert(err, qt.IsNil)
+	_, err = db.ExecContext(ctx, schema)
+	c.Assert(err, qt.IsNil)
+	_, err = db.ExecContext(ctx, `INSERT INTO meta (key, value) VALUES ('query', 'test:query');
+		INSERT INTO repositories (nwo, owner, name) VALUES ('fatih/vim-go', 'fatih', 'vim-go')`)
+	c.Assert(err, qt.IsNil)
+	c.Assert(db.Close(), qt.IsNil)
+
+	store, err := NewMetadataStore(dir, "test:query")
+	c.Assert(err, qt.IsNil)
+	defer store.Close()
+
+	rp, err := store.FindRepo(ctx, 1)
+	c.Assert(err, qt.IsNil)
+	c.Assert(rp.Nwo, qt.Equals, "fatih/vim-go")
+	c.Assert(rp.Metadata, qt.DeepEquals, internal.Metadata{})
+
+	pushedAt := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	rp.Metadata = internal.Metadata{
+		Language:    "Vim Script",
+		Topics:      []string{"vim", "go"},
+		Description: "Go development plugin for Vim",
+		Visibility:  internal.VisibilityPublic,
+		Archived:    true,
+		Size:        4096,
+		Stars:       15000,
+		PushedAt:    pushedAt,
+		HTMLURL:     "https://github.com/fatih/vim-go",
+	}
+	err = store.UpsertRepos(ctx, []*internal.Repository{rp})
+	c.Assert(err, qt.IsNil)
+
+	got, err := store.FindRepo(ctx, 1)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Metadata, qt.DeepEquals, rp.Metadata)
+
+	var version int
+	err = store.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+	c.Assert(err, qt.IsNil)
+	c.Assert(version, qt.Equals, len(migrations))
+}
+
+func newStore(c *qt.C) *MetadataStore {
+	c.Helper()
+
+	store, err := NewMetadataStore(c.Mkdir(), "test:query")
+	c.Assert(err, qt.IsNil)
+	c.Cleanup(func() { store.Close() })
+	return store
+}
+
+func nwos(repos []*internal.Repository) []string {
+	out := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		out = append(out, repo.Nwo)
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package starhook
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/file"
+	"github.com/fatih/starhook/internal/fsstore"
+	"github.com/fatih/starhook/internal/git"
+	"github.com/fatih/starhook/internal/jsonstore"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// TestService_file syncs bare repositories on the local filesystem, end to
+// end, with the file provider and the real stores.
+func TestService_file(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	mirrors := c.Mkdir()
+	c.Assert(os.MkdirAll(filepath.Join(mirrors, "fatih"), 0o755), qt.IsNil)
+
+	vimgo := filepath.Join(mirrors, "fatih", "vim-go.git")
+	color := filepath.Join(mirrors, "fatih", "color.git")
+	runGit(c, "", "init", "--bare", "--initial-branch=master", vimgo)
+	runGit(c, "", "init", "--bare", "--initial-branch=main", color)
+
+	work := c.Mkdir()
+	runGit(c, work, "clone", vimgo, ".")
+	pushCommit(c, work, "master", time.Now().Add(-time.Hour))
+
+	source := &internal.Source{Kind: internal.SourceDir, Path: mirrors}
+	provider := file.NewProvider(source)
+
+	dir := c.Mkdir()
+	store, err := jsonstore.NewMetadataStore(dir, source.String())
+	c.Assert(err, qt.IsNil)
+
+	fsStore, err := fsstore.NewRepositoryStore(dir, fsstore.Options{
+		Layout:   fsstore.LayoutOwnerName,
+		CloneURL: provider.CloneURL,
+	})
+	c.Assert(err, qt.IsNil)
+
+	svc := NewService(provider, store, fsStore)
+
+	sync := func() *SyncRepos {
+		c.Helper()
+
+		local, err := svc.ListRepos(ctx)
+		c.Assert(err, qt.IsNil)
+
+		fetched, err := provider.ListRepos(ctx, source)
+		c.Assert(err, qt.IsNil)
+
+		sr, err := svc.SyncRepos(ctx, local, fetched)
+		c.Assert(err, qt.IsNil)
+		c.Assert(sr.Results.Failed(), qt.HasLen, 0)
+		return sr
+	}
+
+	// the initial sync clones vim-go, color is skipped until it has commits
+	sr := sync()
+	c.Assert(sr.Clone, qt.HasLen, 1)
+	c.Assert(sr.Clone[0].Nwo, qt.Equals, "fatih/vim-go")
+	c.Assert(sr.Update, qt.HasLen, 0)
+	c.Assert(sr.Results.Count(StatusSkipped), qt.Equals, 1)
+
+	results := svc.CloneRepos(ctx, sr.Clone)
+	c.Assert(results.Failed(), qt.HasLen, 0)
+
+	out := runGit(c, filepath.Join(dir, "fatih", "vim-go"), "log", "--format=%s")
+	c.Assert(out, qt.Equals, "commit")
+
+	// nothing changed
+	sr = sync()
+	c.Assert(sr.Clone, qt.HasLen, 0)
+	c.Assert(sr.Update, qt.HasLen, 0)
+
+	// a new commit is pushed to the mirror
+	sha := pushCommit(c, work, "master", time.Now().Add(time.Hour))
+
+	sr = sync()
+	c.Assert(sr.Clone, qt.HasLen, 0)
+	c.Assert(sr.Update, qt.HasLen, 1)
+	c.Assert(sr.Update[0].Nwo, qt.Equals, "fatih/vim-go")
+
+	results = svc.UpdateRepos(ctx, sr.Update)
+	c.Assert(results.Failed(), qt.HasLen, 0)
+
+	out = runGit(c, filepath.Join(dir, "fatih", "vim-go"), "rev-parse", "HEAD")
+	c.Assert(out, qt.Equals, sha)
+
+	// the mirror is removed, the pulled commit isn't a local change and the
+	// updated repo is deleted instead of archived
+	c.Assert(os.RemoveAll(vimgo), qt.IsNil)
+
+	sr = sync()
+	c.Assert(sr.Delete, qt.HasLen, 1)
+	c.Assert(sr.Delete[0].Nwo, qt.Equals, "fatih/vim-go")
+
+	results = svc.DeleteRepos(ctx, internal.DeleteOptions{Policy: internal.RemoveDelete}, sr.Delete)
+	c.Assert(results.Failed(), qt.HasLen, 0)
+
+	_, err = os.Stat(filepath.Join(dir, "fatih", "vim-go"))
+	c.Assert(os.IsNotExist(err), qt.IsTrue, qt.Commentf("repo should be deleted"))
+
+	archived, err := fsStore.ArchivedRepos()
+	c.Assert(err, qt.IsNil)
+	c.Assert(archived, qt.HasLen, 0)
+}
+
+// pushCommit pushes an empty commit, committed at the given time, to the
+// given branch and returns its SHA.
+func pushCommit(c *qt.C, work, branch string, at time.Time) string {
+	c.Helper()
+
+	date := at.Format(time.RFC3339)
+	g := &git.Client{
+		Dir: work,
+		Env: []string{"GIT_AUTHOR_DATE=" + date, "GIT_COMMITTER_DATE=" + date},
+	}
+	_, err := g.Run("-c", "user.name=test", "-c", "user.email=test@example.com",
+		"commit", "--allow-empty", "-m", "commit")
+	c.Assert(err, qt.IsNil)
+
+	runGit(c, work, "push", "origin", branch)
+	return runGit(c, work, "rev-parse", "HEAD")
+}
+
+func runGit(c *qt.C, dir string, args ...string) string {
+	c.Helper()
+
+	g := &git.Client{Dir: dir}
+	out, err := g.Run(args...)
+	c.Assert(err, qt.IsNil)
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,309 @@
+package starhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/git"
+
+	"github.com/fatih/semgroup"
+)
+
+// errSkipped is returned by an operation that was not applied to a
+// repository, i.e: because the repository is empty.
+var errSkipped = errors.New("skipped")
+
+// Op defines an operation that is applied to a repository during a sync.
+type Op string
+
+const (
+	OpFetch  Op = "fetch"
+	OpRename Op = "rename"
+	OpBranch Op = "branch"
+	OpClone  Op = "clone"
+	OpUpdate Op = "update"
+	OpDelete Op = "delete"
+)
+
+// Status defines the outcome of an operation.
+type Status string
+
+const (
+	StatusSuccess Status = "success"
+	StatusSkipped Status = "skipped"
+	StatusFailed  Status = "failed"
+)
+
+// Result is the outcome of a single operation applied to a repository.
+type Result struct {
+	Repo   *internal.Repository
+	Op     Op
+	Status Status
+
+	// Err is the reason of a failed or skipped operation.
+	Err error
+
+	// changes to store once the phase is finished, nil if unchanged
+	deleted   bool
+	renamed   *internal.Repository // the repository with its new name
+	branch    *string
+	syncedAt  *time.Time
+	syncError *string
+}
+
+// changed reports whether the result has changes to store.
+func (r *Result) changed() bool {
+	return r.deleted || r.renamed != nil || r.branch != nil || r.syncedAt != nil || r.syncError != nil
+}
+
+// Message returns a short description of the error. For errors returned by
+// git, it's the last line of git's output.
+func (r *Result) Message() string {
+	if r.Err == nil {
+		return ""
+	}
+
+	var gitErr *git.Error
+	if errors.As(r.Err, &gitErr) {
+		return gitErr.Message()
+	}
+
+	return r.Err.Error()
+}
+
+// Results is a list of results.
+type Results []*Result
+
+// Count returns the number of results with the given status.
+func (r Results) Count(status Status) int {
+	n := 0
+	for _, res := range r {
+		if res.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the failed results.
+func (r Results) Failed() Results {
+	var failed Results
+	for _, res := range r {
+		if res.Status == StatusFailed {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Workers limits the number of operations running concurrently, across all
+// services sharing it. A nil Workers has no limit.
+type Workers chan struct{}
+
+// NewWorkers returns workers that run at most n operations at once.
+func NewWorkers(n int) Workers {
+	if n < 1 {
+		n = 1
+	}
+	return make(Workers, n)
+}
+
+// acquire waits for a free worker. It returns false if the context is
+// cancelled before.
+func (w Workers) acquire(ctx context.Context) bool {
+	if w == nil {
+		return true
+	}
+
+	select {
+	case w <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a worker acquired before.
+func (w Workers) release() {
+	if w != nil {
+		<-w
+	}
+}
+
+// run applies fn to the given repositories concurrently and returns the
+// result for each repository. A failing repository doesn't stop the other
+// repositories. fn records the changes to store on the given result, they're
+// stored at once when all repositories are done.
+func (s *Service) run(ctx context.Context, op Op, repos []*internal.Repository, fn func(res *Result) error) Results {
+	results := make(Results, len(repos))
+	if len(repos) == 0 {
+		return results
+	}
+
+	const maxWorkers = 10
+	sem := semgroup.NewGroup(ctx, maxWorkers)
+
+	for i, repo := range repos {
+		i, repo := i, repo
+
+		sem.Go(func() error {
+			if !s.workers.acquire(ctx) {
+				return nil // skipped below
+			}
+			defer s.workers.release()
+
+			results[i] = s.apply(ctx, op, repo, fn)
+			return nil
+		})
+	}
+
+	// the group only fails if the context is cancelled, which is handled
+	// below for each repo that was never started.
+	_ = sem.Wait()
+
+	for i, repo := range repos {
+		if results[i] == nil {
+			results[i] = &Result{Repo: repo, Op: op, Status: StatusSkipped, Err: ctx.Err()}
+		}
+	}
+
+	s.storeResults(ctx, results)
+	return results
+}
+
+// apply applies fn to a single repository and records its outcome in the
+// result. A failure is stored with storeResults, so the repository can be
+// retried first on the next sync.
+func (s *Service) apply(ctx context.Context, op Op, repo *internal.Repository, fn func(res *Result) error) *Result {
+	res := &Result{Repo: repo, Op: op, Status: StatusSuccess}
+
+	if err := ctx.Err(); err != nil {
+		res.Status = StatusSkipped
+		res.Err = err
+		return res
+	}
+
+	err := fn(res)
+	if errors.Is(err, errSkipped) {
+		res.Status = StatusSkipped
+		res.Err = err
+		return res
+	}
+
+	if err != nil {
+		log.Printf("[DEBUG] %s failed, name: %q, err: %s", op, repo.Nwo, err)
+		res.Status = StatusFailed
+		res.Err = err
+	}
+
+	// repositories that were never stored or are deleted can't record
+	// their state.
+	if repo.ID == 0 || res.deleted {
+		return res
+	}
+
+	if err == nil && (op == OpClone || op == OpUpdate) {
+		now := time.Now().UTC()
+		res.syncedAt = &now
+	}
+
+	syncErr := ""
+	if err != nil {
+		syncErr = fmt.Sprintf("%s: %s", op, res.Message())
+	}
+
+	if syncErr != repo.SyncError {
+		res.syncError = &syncErr
+	}
+
+	return res
+}
+
+// storeResults stores the changes of the given results at once, instead of
+// one write per repository. If the store fails, the changed results are
+// marked as failed.
+func (s *Service) storeResults(ctx context.Context, results Results) {
+	var (
+		changed Results
+		deleted []int64
+		ids     []int64
+	)
+	for _, res := range results {
+		if !res.changed() {
+			continue
+		}
+
+		changed = append(changed, res)
+		if res.deleted {
+			deleted = append(deleted, res.Repo.ID)
+		} else {
+			ids = append(ids, res.Repo.ID)
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	err := s.store.Tx(ctx, func(tx internal.MetadataStore) error {
+		for _, id := range deleted {
+			id := id
+			if err := tx.DeleteRepo(ctx, internal.RepositoryBy{RepoID: &id}); err != nil {
+				return err
+			}
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+
+		// the repositories are read again, the results only carry the
+		// changed fields
+		repos, err := tx.FindReposByIDs(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		i := 0
+		for _, res := range changed {
+			if res.deleted {
+				continue
+			}
+
+			repo := repos[i]
+			i++
+
+			if res.renamed != nil {
+				repo.Nwo = res.renamed.Nwo
+				repo.Owner = res.renamed.Owner
+				repo.Name = res.renamed.Name
+			}
+			if res.branch != nil {
+				repo.Branch = *res.branch
+			}
+			if res.syncedAt != nil {
+				repo.SyncedAt = *res.syncedAt
+			}
+			if res.syncError != nil {
+				repo.SyncError = *res.syncError
+			}
+		}
+
+		return tx.UpsertRepos(ctx, repos)
+	})
+	if err == nil {
+		return
+	}
+
+	log.Printf("[DEBUG] storing the results failed: %s", err)
+	for _, res := range changed {
+		if res.Err == nil {
+			res.Status = StatusFailed
+			res.Err = err
+		}
+	}
+}
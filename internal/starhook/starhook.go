@@ -3,114 +3,211 @@ package starhook
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
-	"time"
+	"sort"
+	"strings"
 
 	"github.com/fatih/starhook/internal"
-	"github.com/fatih/starhook/internal/gh"
-
-	"github.com/fatih/semgroup"
 )
 
 type Service struct {
-	client *gh.Client
-	store  internal.MetadataStore
-	fs     internal.RepositoryStore
+	provider internal.Provider
+	store    internal.MetadataStore
+	fs       internal.RepositoryStore
+
+	workers Workers // optional, shared with other services
 }
 
 type SyncRepos struct {
 	Clone  []*internal.Repository
 	Update []*internal.Repository
 	Delete []*internal.Repository
+	Rename []*RenamedRepo
+	Branch []*BranchChange
+
+	// Results contains the result of fetching the branch of each fetched
+	// repository. Failed repositories are not part of any other list.
+	Results Results
+}
+
+// BranchChange is a repository whose default branch was changed on GitHub.
+type BranchChange struct {
+	Repo *internal.Repository
+	From string // previous default branch
+	To   string // new default branch
 }
 
-func NewService(ghClient *gh.Client, store internal.MetadataStore, fs internal.RepositoryStore) *Service {
+// RenamedRepo is a repository that was renamed or transferred to a different
+// owner on GitHub.
+type RenamedRepo struct {
+	From *internal.Repository // local repository, with the previous name
+	To   *internal.Repository // fetched repository, with the new name
+}
+
+func NewService(provider internal.Provider, store internal.MetadataStore, fs internal.RepositoryStore) *Service {
 	return &Service{
-		client: ghClient,
-		store:  store,
-		fs:     fs,
+		provider: provider,
+		store:    store,
+		fs:       fs,
 	}
 }
 
+// SetWorkers limits the repositories that are synced concurrently to the
+// given workers, in addition to the limit of the service itself. Services
+// sharing the same workers have a global limit.
+func (s *Service) SetWorkers(w Workers) {
+	s.workers = w
+}
+
+// Close closes the metadata store of the service, if it needs to be closed,
+// i.e: the SQLite store.
+func (s *Service) Close() error {
+	if c, ok := s.store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 // ListRepos lists all the repositories.
 func (s *Service) ListRepos(ctx context.Context) ([]*internal.Repository, error) {
-	return s.store.FindRepos(ctx, internal.RepositoryFilter{}, internal.DefaultFindOptions)
+	return s.store.FindRepos(ctx, internal.RepositoryFilter{}, internal.FindOptions{})
 }
 
-// DeleteRepos deletes the given repositories.
-func (s *Service) DeleteRepos(ctx context.Context, repos []*internal.Repository) error {
-	if len(repos) == 0 {
-		return nil
-	}
+// FindRepos lists the repositories selected by the given filter, sorted and
+// paginated with the given options.
+func (s *Service) FindRepos(ctx context.Context, filter internal.RepositoryFilter, opt internal.FindOptions) ([]*internal.Repository, error) {
+	return s.store.FindRepos(ctx, filter, opt)
+}
 
-	const maxWorkers = 10
-	sem := semgroup.NewGroup(ctx, maxWorkers)
+// DeleteRepos removes the given repositories from the store and applies the
+// remove policy to their local copies.
+func (s *Service) DeleteRepos(ctx context.Context, opts internal.DeleteOptions, repos []*internal.Repository) Results {
+	return s.run(ctx, OpDelete, repos, func(res *Result) error {
+		return s.deleteRepo(ctx, opts, res)
+	})
+}
 
-	for _, repo := range repos {
-		repo := repo
+// deleteRepo applies the remove policy to the folder of the result's repo if
+// it's exist, and records it to be deleted from the DB. A repository with
+// local changes is never deleted, it's archived instead.
+func (s *Service) deleteRepo(ctx context.Context, opts internal.DeleteOptions, res *Result) error {
+	repo := res.Repo
 
-		sem.Go(func() error {
-			return s.deleteRepo(ctx, repo)
-		})
+	policy := opts.Policy
+	if policy == "" {
+		policy = internal.DefaultRemovePolicy
 	}
 
-	return sem.Wait()
-}
+	if policy == internal.RemoveDelete {
+		changes, err := s.fs.LocalChanges(ctx, repo)
+		if err != nil {
+			return err
+		}
 
-// deleteRepo deletes the given repo from the DB and the folder if it's exist.
-func (s *Service) deleteRepo(ctx context.Context, repo *internal.Repository) error {
-	err := s.fs.DeleteRepo(ctx, repo)
-	if err != nil {
-		return err
+		if len(changes) != 0 {
+			log.Printf("[WARN] %q has %s, archiving it instead of deleting",
+				repo.Nwo, strings.Join(changes, ", "))
+			policy = internal.RemoveArchive
+		}
 	}
 
-	repoID := repo.ID
-	return s.store.DeleteRepo(ctx, internal.RepositoryBy{RepoID: &repoID})
-}
+	switch policy {
+	case internal.RemoveDelete:
+		if err := s.fs.DeleteRepo(ctx, repo); err != nil {
+			return err
+		}
+	case internal.RemoveArchive:
+		path, err := s.fs.ArchiveRepo(ctx, repo)
+		if err != nil {
+			return err
+		}
 
-// CloneRepos clones the given repositories.
-func (s *Service) CloneRepos(ctx context.Context, repos []*internal.Repository) error {
-	if len(repos) == 0 {
-		return nil
+		if path != "" {
+			log.Printf("  %q is archived to %q\n", repo.Nwo, path)
+		}
+	case internal.RemoveKeep:
+		log.Printf("[DEBUG] keeping repo on filesystem, name: %q", repo.Nwo)
+	default:
+		return fmt.Errorf("unknown remove policy %q", policy)
 	}
 
-	const maxWorkers = 10
-	sem := semgroup.NewGroup(ctx, maxWorkers)
+	res.deleted = true
+	return nil
+}
 
+// RenameRepos moves the given repositories to their new names, on the
+// filesystem and in the store.
+func (s *Service) RenameRepos(ctx context.Context, repos []*RenamedRepo) Results {
+	// renames are applied one by one, because a repository might be renamed
+	// to the previous name of another repository.
+	results := make(Results, 0, len(repos))
 	for _, repo := range repos {
 		repo := repo
+		results = append(results, s.apply(ctx, OpRename, repo.From, func(res *Result) error {
+			return s.renameRepo(ctx, res, repo)
+		}))
+	}
 
-		sem.Go(func() error {
-			return s.cloneRepo(ctx, repo)
-		})
+	s.storeResults(ctx, results)
+	return results
+}
+
+// renameRepo renames a single repository.
+func (s *Service) renameRepo(ctx context.Context, res *Result, repo *RenamedRepo) error {
+	err := s.fs.MoveRepo(ctx, repo.From, repo.To)
+	if err != nil {
+		return err
 	}
 
-	return sem.Wait()
+	res.renamed = repo.To
+	return nil
 }
 
-// cloneRepo clones a single repository.
-func (s *Service) cloneRepo(ctx context.Context, repo *internal.Repository) error {
-	err := s.fs.CreateRepo(ctx, repo)
+// SwitchBranches switches the given repositories to their new default
+// branch, on the filesystem and in the store.
+func (s *Service) SwitchBranches(ctx context.Context, changes []*BranchChange) Results {
+	repos := make([]*internal.Repository, 0, len(changes))
+	byRepo := make(map[*internal.Repository]*BranchChange, len(changes))
+	for _, change := range changes {
+		repos = append(repos, change.Repo)
+		byRepo[change.Repo] = change
+	}
+
+	return s.run(ctx, OpBranch, repos, func(res *Result) error {
+		return s.switchBranch(ctx, res, byRepo[res.Repo])
+	})
+}
+
+// switchBranch switches a single repository to its new default branch.
+func (s *Service) switchBranch(ctx context.Context, res *Result, change *BranchChange) error {
+	err := s.fs.SwitchBranch(ctx, change.Repo, change.From)
 	if err != nil {
 		return err
 	}
 
-	now := time.Now().UTC()
-	err = s.store.UpdateRepo(ctx,
-		internal.RepositoryBy{
-			Name: &repo.Name,
-		},
-		internal.RepositoryUpdate{
-			SyncedAt: &now,
-		},
-	)
+	res.branch = &change.To
+	return nil
+}
 
-	return err
+// CloneRepos clones the given repositories.
+func (s *Service) CloneRepos(ctx context.Context, repos []*internal.Repository) Results {
+	return s.run(ctx, OpClone, repos, func(res *Result) error {
+		return s.cloneRepo(ctx, res.Repo)
+	})
+}
+
+// cloneRepo clones a single repository.
+func (s *Service) cloneRepo(ctx context.Context, repo *internal.Repository) error {
+	return s.fs.CreateRepo(ctx, repo)
 }
 
 // updateRepo updates a single repository.
-func (s *Service) updateRepo(ctx context.Context, repo *internal.Repository) error {
+func (s *Service) updateRepo(ctx context.Context, res *Result) error {
+	repo := res.Repo
+
 	err := s.fs.UpdateRepo(ctx, internal.UpdateOptions{}, repo)
 	if os.IsNotExist(err) {
 		// this happens if the folder was deleted not with starhook. Remove it
@@ -118,105 +215,202 @@ func (s *Service) updateRepo(ctx context.Context, repo *internal.Repository) err
 		log.Printf("[DEBUG] repository was removed from file system, removing from metadastore owner: %q, name: %q, branch: %q",
 			repo.Owner, repo.Name, repo.Branch)
 
-		return s.store.DeleteRepo(ctx, internal.RepositoryBy{RepoID: &repo.ID})
-	}
-
-	if err != nil {
-		return err
+		res.deleted = true
+		return nil
 	}
 
-	now := time.Now().UTC()
-	err = s.store.UpdateRepo(ctx,
-		internal.RepositoryBy{
-			Name: &repo.Name,
-		},
-		internal.RepositoryUpdate{
-			SyncedAt: &now,
-		},
-	)
-
 	return err
 }
 
 // UpdateRepos updates the given repositories locally to its latest ref.
-func (s *Service) UpdateRepos(ctx context.Context, repos []*internal.Repository) error {
-	if len(repos) == 0 {
-		return nil
-	}
-
-	const maxWorkers = 10
-	sem := semgroup.NewGroup(ctx, maxWorkers)
-
-	for _, repo := range repos {
-		repo := repo
-
-		sem.Go(func() error {
-			return s.updateRepo(ctx, repo)
-		})
-	}
-
-	return sem.Wait()
+func (s *Service) UpdateRepos(ctx context.Context, repos []*internal.Repository) Results {
+	return s.run(ctx, OpUpdate, repos, func(res *Result) error {
+		return s.updateRepo(ctx, res)
+	})
 }
 
 // SyncRepos syncs the repositories in the store, with the fetched remote
 // repositories and returns the repositories to clone, update or delete.
+// Repositories are matched by their RemoteID, hence a renamed or transferred
+// repository is returned to be renamed instead of being deleted and cloned
+// again.
 func (s *Service) SyncRepos(ctx context.Context, repos, fetched []*internal.Repository) (*SyncRepos, error) {
 	var (
 		clone   []*internal.Repository
 		update  []*internal.Repository
 		deleted []*internal.Repository
+		renamed []*RenamedRepo
+		changed []*BranchChange
 	)
 
-	localRepos := make(map[string]*internal.Repository, len(repos))
+	localByID := make(map[int64]*internal.Repository, len(repos))
+	localByNwo := make(map[string]*internal.Repository, len(repos))
 	for _, repo := range repos {
-		localRepos[repo.Nwo] = repo
+		if repo.RemoteID != 0 {
+			localByID[repo.RemoteID] = repo
+		}
+		localByNwo[repo.Nwo] = repo
+	}
+
+	// match each fetched repo with its local repo. Repos that were synced
+	// before the RemoteID was stored are matched by their name.
+	matched := make(map[int64]bool, len(repos))
+	localRepos := make(map[*internal.Repository]*internal.Repository, len(fetched))
+	match := func(repo, localRepo *internal.Repository) {
+		matched[localRepo.ID] = true
+		localRepos[repo] = localRepo
+	}
+
+	for _, repo := range fetched {
+		if localRepo, ok := localByID[repo.RemoteID]; ok && repo.RemoteID != 0 {
+			match(repo, localRepo)
+		}
 	}
 
-	fetchedRepos := make(map[string]*internal.Repository, len(fetched))
 	for _, repo := range fetched {
-		fetchedRepos[repo.Nwo] = repo
+		localRepo, ok := localByNwo[repo.Nwo]
+		if !ok || localRepos[repo] != nil || matched[localRepo.ID] {
+			continue
+		}
+
+		// the name was taken over by a different repository
+		if localRepo.RemoteID != 0 && repo.RemoteID != 0 && localRepo.RemoteID != repo.RemoteID {
+			continue
+		}
+
+		match(repo, localRepo)
+	}
+
+	for _, repo := range fetched {
+		localRepo := localRepos[repo]
+		if localRepo != nil && localRepo.Nwo != repo.Nwo {
+			log.Printf("[DEBUG] rename, from: %q, to: %q", localRepo.Nwo, repo.Nwo)
+			renamed = append(renamed, &RenamedRepo{
+				From: localRepo,
+				To:   repo,
+			})
+		}
+	}
+
+	log.Printf("[DEBUG] syncing with local store, fetched repos: %d local repos: %d", len(fetched), len(localRepos))
+	// resolve the branches in batches, instead of one API call per repo
+	heads, err := s.provider.DefaultBranches(ctx, fetched)
+	if err != nil {
+		return nil, err
+	}
+
+	// check for repos to update or clone
+	results := s.run(ctx, OpFetch, fetched, func(res *Result) error {
+		return s.syncRepo(ctx, localRepos[res.Repo], res.Repo, heads)
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	const maxWorkers = 5
-	sem := semgroup.NewGroup(ctx, maxWorkers)
+	// default branches that were changed, i.e: master -> main
+	branches := make(map[int64]string)
+	for i, repo := range fetched {
+		if results[i].Status != StatusSuccess {
+			continue
+		}
+
+		localRepo := localRepos[repo]
+		if localRepo != nil && localRepo.Branch != "" && repo.Branch != "" && localRepo.Branch != repo.Branch {
+			log.Printf("[DEBUG] default branch changed, name: %q, from: %q, to: %q",
+				repo.Nwo, localRepo.Branch, repo.Branch)
+			branches[localRepo.ID] = localRepo.Branch
+		}
+	}
 
 	// check for repos to delete
-	for _, repo := range localRepos {
-		if _, ok := fetchedRepos[repo.Nwo]; !ok {
+	for _, repo := range repos {
+		if !matched[repo.ID] {
 			// local repository doesn't exist in the final, fetched list, needs
 			// to be removed
 			deleted = append(deleted, repo)
-			delete(localRepos, repo.Nwo)
 		}
 	}
 
-	log.Printf("[DEBUG] syncing with local store, fetched repos: %d local repos: %d", len(fetchedRepos), len(localRepos))
-	// check for repos to update or clone
-	for _, repo := range fetchedRepos {
-		repo := repo
-		localRepo := localRepos[repo.Nwo]
+	// store the changes of all fetched repos at once, instead of one write
+	// per repo
+	var (
+		synced []*internal.Repository
+		upsert []*internal.Repository
+	)
+	for i, repo := range fetched {
+		if results[i].Status != StatusSuccess {
+			continue // failed or skipped, keep the local state as it is
+		}
+		synced = append(synced, repo)
 
-		sem.Go(func() error {
-			return s.syncRepo(ctx, localRepo, repo)
-		})
-	}
+		localRepo := localRepos[repo]
+		if localRepo == nil {
+			log.Printf("[DEBUG] creating new entry, owner: %q, name: %q, branch: %q",
+				repo.Owner, repo.Name, repo.Branch)
+			upsert = append(upsert, repo)
+			continue
+		}
 
-	if err := sem.Wait(); err != nil {
-		return nil, err
+		if !localRepo.BranchUpdatedAt.Equal(repo.BranchUpdatedAt) ||
+			localRepo.RemoteID != repo.RemoteID ||
+			!localRepo.Metadata.Equal(repo.Metadata) {
+			log.Printf("[DEBUG] updating entry, owner: %q, name: %q, branch: %q",
+				repo.Owner, repo.Name, repo.Branch)
+
+			rp := *localRepo
+			rp.RemoteID = repo.RemoteID
+			rp.SHA = repo.SHA
+			rp.BranchUpdatedAt = repo.BranchUpdatedAt
+			rp.Metadata = repo.Metadata
+			upsert = append(upsert, &rp)
+		}
 	}
 
-	syncedRepos := make([]*internal.Repository, 0)
+	var syncedRepos []*internal.Repository
+	err = s.store.Tx(ctx, func(tx internal.MetadataStore) error {
+		if err := tx.UpsertRepos(ctx, upsert); err != nil {
+			return err
+		}
 
-	// TODO(fatih): use a more efficient fetching, dont do it one by one
-	for _, repo := range localRepos {
-		rp, err := s.store.FindRepo(ctx, repo.ID)
-		if err != nil {
-			return nil, err
+		// new repos have their ID once they're created
+		ids := make([]int64, 0, len(synced))
+		for _, repo := range synced {
+			ids = append(ids, repo.ID)
 		}
 
-		syncedRepos = append(syncedRepos, rp)
+		var err error
+		syncedRepos, err = tx.FindReposByIDs(ctx, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	for i, rp := range syncedRepos {
+		repo := synced[i]
+
+		// renames and branch changes are applied before cloning and
+		// updating, hence make sure the repos reflect their new state.
+		rp.Nwo = repo.Nwo
+		rp.Owner = repo.Owner
+		rp.Name = repo.Name
+		rp.Branch = repo.Branch
+
+		if from, ok := branches[rp.ID]; ok {
+			changed = append(changed, &BranchChange{
+				Repo: rp,
+				From: from,
+				To:   rp.Branch,
+			})
+		}
+	}
+
+	// repositories that failed during the previous sync are retried first
+	sort.SliceStable(syncedRepos, func(i, j int) bool {
+		return syncedRepos[i].SyncError != "" && syncedRepos[j].SyncError == ""
+	})
+
 	for _, repo := range syncedRepos {
 		if repo.SyncedAt.IsZero() {
 			log.Printf("[DEBUG] clone, owner: %q, name: %q, branch: %q, sha: %q",
@@ -225,7 +419,8 @@ func (s *Service) SyncRepos(ctx context.Context, repos, fetched []*internal.Repo
 			continue
 		}
 
-		if repo.SyncedAt.Before(repo.BranchUpdatedAt) {
+		_, branchChanged := branches[repo.ID]
+		if branchChanged || repo.SyncError != "" || repo.SyncedAt.Before(repo.BranchUpdatedAt) {
 			log.Printf("[DEBUG] update, owner: %q, name: %q, branch: %q, sha: %q",
 				repo.Owner, repo.Name, repo.Branch, repo.SHA)
 			update = append(update, repo)
@@ -237,55 +432,44 @@ func (s *Service) SyncRepos(ctx context.Context, repos, fetched []*internal.Repo
 		Clone:  clone,
 		Update: update,
 		Delete: deleted,
+		Rename: renamed,
+		Branch: changed,
+
+		Results: results,
 	}, nil
 }
 
-// syncRepo sync the local repo with the fetched repo
-func (s *Service) syncRepo(ctx context.Context, localRepo, repo *internal.Repository) error {
-	if err := ctx.Err(); err != nil {
-		return err
+// syncRepo sync the fetched repo with its latest branch. The changes are
+// stored by SyncRepos.
+func (s *Service) syncRepo(ctx context.Context, localRepo, repo *internal.Repository, heads map[string]*internal.Branch) error {
+	if localRepo != nil {
+		repo.ID = localRepo.ID
 	}
 
-	// NOTE(fatih): there is the possibility that the default branch
-	// might have changed, for now we assume that's not the case, but
-	// it's worth noting here.
-	branch, err := s.client.Branch(ctx, repo.Owner, repo.Name, repo.Branch)
-	if err != nil {
-		if errors.Is(err, gh.ErrBranchNotFound) {
-			log.Printf("[DEBUG] no branch information found, owner: %q, name: %q, branch: %q",
-				repo.Owner, repo.Name, repo.Branch)
-			return nil
+	// NOTE(fatih): the default branch might have changed. The fetched repo
+	// always contains the latest default branch, SyncRepos takes care of
+	// switching to it.
+	branch, ok := heads[repo.Nwo]
+	if !ok {
+		var err error
+		branch, err = s.provider.Branch(ctx, repo, repo.Branch)
+		if err != nil && !errors.Is(err, internal.ErrBranchNotFound) {
+			return err
 		}
+	}
 
-		return err
+	if branch == nil {
+		log.Printf("[DEBUG] no branch information found, owner: %q, name: %q, branch: %q",
+			repo.Owner, repo.Name, repo.Branch)
+		return fmt.Errorf("%w: no branch information found for %q", errSkipped, repo.Branch)
+	}
+
+	if branch.Name != "" {
+		repo.Branch = branch.Name
 	}
 
 	repo.BranchUpdatedAt = branch.UpdatedAt
 	repo.SHA = branch.SHA
 
-	if localRepo == nil {
-		log.Printf("[DEBUG] creating new entry, owner: %q, name: %q, branch: %q",
-			repo.Owner, repo.Name, repo.Branch)
-		_, err = s.store.CreateRepo(ctx, repo)
-		if err != nil {
-			return err
-		}
-	} else if !localRepo.BranchUpdatedAt.Equal(repo.BranchUpdatedAt) {
-		log.Printf("[DEBUG] updating entry, owner: %q, name: %q, branch: %q",
-			repo.Owner, repo.Name, repo.Branch)
-		err = s.store.UpdateRepo(ctx,
-			internal.RepositoryBy{
-				Name: &repo.Name,
-			},
-			internal.RepositoryUpdate{
-				SHA:             &repo.SHA,
-				BranchUpdatedAt: &repo.BranchUpdatedAt,
-			},
-		)
-		if err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
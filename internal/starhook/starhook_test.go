@@ -2,12 +2,17 @@ package starhook
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/fatih/starhook/internal"
+	"github.com/fatih/starhook/internal/gh"
 	"github.com/fatih/starhook/internal/mock"
 
 	qt "github.com/frankban/quicktest"
+	"github.com/google/go-github/v39/github"
 )
 
 func TestNewService(t *testing.T) {
@@ -40,3 +45,393 @@ func TestService_ListRepos(t *testing.T) {
 	c.Assert(resp, qt.DeepEquals, repos)
 	c.Assert(store.FindReposInvoked, qt.IsTrue, qt.Commentf("FindRepos() should be called"))
 }
+
+func TestService_SyncRepos_rename(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	local := []*internal.Repository{
+		{ID: 1, RemoteID: 100, Nwo: "acme/utils", Owner: "acme", Name: "utils", Branch: "main"},
+		{ID: 2, RemoteID: 200, Nwo: "acme/old", Owner: "acme", Name: "old", Branch: "main"},
+	}
+
+	fetched := []*internal.Repository{
+		{RemoteID: 100, Nwo: "contoso/utils", Owner: "contoso", Name: "utils", Branch: "main",
+			Metadata: internal.Metadata{Language: "Go", Stars: 42}},
+		{RemoteID: 300, Nwo: "acme/new", Owner: "acme", Name: "new", Branch: "main"},
+	}
+
+	repos := make(map[int64]*internal.Repository)
+	for _, repo := range local {
+		rp := *repo
+		repos[repo.ID] = &rp
+	}
+
+	store := &mock.MetadataStore{
+		FindReposByIDsFn: func(ctx context.Context, ids []int64) ([]*internal.Repository, error) {
+			var found []*internal.Repository
+			for _, id := range ids {
+				rp := *repos[id]
+				found = append(found, &rp)
+			}
+			return found, nil
+		},
+		UpsertReposFn: func(ctx context.Context, upsert []*internal.Repository) error {
+			for _, repo := range upsert {
+				if repo.ID == 0 {
+					repo.ID = int64(len(repos) + 1)
+				}
+				rp := *repo
+				repos[repo.ID] = &rp
+			}
+			return nil
+		},
+	}
+	store.TxFn = func(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
+		return fn(store)
+	}
+
+	client := &gh.Client{
+		Repositories: &fakeRepositoriesService{},
+	}
+
+	svc := NewService(gh.NewProvider(client, "github.com"), store, &mock.RepositoryStore{})
+
+	resp, err := svc.SyncRepos(ctx, local, fetched)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(resp.Rename, qt.HasLen, 1)
+	c.Assert(resp.Rename[0].From.Nwo, qt.Equals, "acme/utils")
+	c.Assert(resp.Rename[0].To.Nwo, qt.Equals, "contoso/utils")
+
+	c.Assert(resp.Delete, qt.HasLen, 1)
+	c.Assert(resp.Delete[0].Nwo, qt.Equals, "acme/old")
+
+	c.Assert(resp.Clone, qt.HasLen, 2, qt.Commentf("all repos should be cloned, they were never synced"))
+	c.Assert(resp.Clone[0].Nwo, qt.Equals, "contoso/utils")
+	c.Assert(resp.Clone[1].Nwo, qt.Equals, "acme/new")
+
+	c.Assert(store.TxInvoked, qt.IsTrue, qt.Commentf("Tx() should be called"))
+	c.Assert(store.FindRepoInvoked, qt.IsFalse, qt.Commentf("FindRepo() should not be called"))
+	c.Assert(repos[3].Nwo, qt.Equals, "acme/new", qt.Commentf("new repo should be created"))
+	c.Assert(repos[1].Metadata, qt.DeepEquals, internal.Metadata{Language: "Go", Stars: 42})
+	c.Assert(repos[1].Nwo, qt.Equals, "acme/utils", qt.Commentf("renames are stored once they're applied"))
+}
+
+func TestService_SyncRepos_branchChanged(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	syncedAt := time.Now().Add(time.Hour)
+	local := []*internal.Repository{
+		{ID: 1, RemoteID: 100, Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go", Branch: "master", SyncedAt: syncedAt},
+	}
+
+	fetched := []*internal.Repository{
+		{RemoteID: 100, Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go", Branch: "main"},
+	}
+
+	store := &mock.MetadataStore{
+		FindReposByIDsFn: func(ctx context.Context, ids []int64) ([]*internal.Repository, error) {
+			rp := *local[0]
+			return []*internal.Repository{&rp}, nil
+		},
+		UpsertReposFn: func(ctx context.Context, repos []*internal.Repository) error {
+			return nil
+		},
+	}
+	store.TxFn = func(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
+		return fn(store)
+	}
+
+	client := &gh.Client{
+		Repositories: &fakeRepositoriesService{},
+	}
+
+	svc := NewService(gh.NewProvider(client, "github.com"), store, &mock.RepositoryStore{})
+
+	resp, err := svc.SyncRepos(ctx, local, fetched)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(resp.Branch, qt.HasLen, 1)
+	c.Assert(resp.Branch[0].From, qt.Equals, "master")
+	c.Assert(resp.Branch[0].To, qt.Equals, "main")
+
+	// the repo is up to date, but it needs to be updated to the new branch
+	c.Assert(resp.Update, qt.HasLen, 1)
+	c.Assert(resp.Update[0].Branch, qt.Equals, "main")
+}
+
+func TestService_DeleteRepos_localChanges(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	store := &mock.MetadataStore{
+		DeleteRepoFn: func(ctx context.Context, by internal.RepositoryBy) error {
+			return nil
+		},
+	}
+	store.TxFn = func(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
+		return fn(store)
+	}
+
+	fsstore := &mock.RepositoryStore{
+		LocalChangesFn: func(ctx context.Context, repo *internal.Repository) ([]string, error) {
+			return []string{"uncommitted changes"}, nil
+		},
+		ArchiveRepoFn: func(ctx context.Context, repo *internal.Repository) (string, error) {
+			return "/archive/fatih/vim-go", nil
+		},
+	}
+
+	svc := NewService(nil, store, fsstore)
+
+	repos := []*internal.Repository{
+		{ID: 1, Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go"},
+	}
+
+	results := svc.DeleteRepos(ctx, internal.DeleteOptions{Policy: internal.RemoveDelete}, repos)
+	c.Assert(results.Failed(), qt.HasLen, 0)
+	c.Assert(fsstore.ArchiveRepoInvoked, qt.IsTrue, qt.Commentf("ArchiveRepo() should be called"))
+	c.Assert(fsstore.DeleteRepoInvoked, qt.IsFalse, qt.Commentf("DeleteRepo() should not be called"))
+	c.Assert(store.DeleteRepoInvoked, qt.IsTrue, qt.Commentf("DeleteRepo() should be called"))
+}
+
+func TestService_UpdateRepos_partialFailure(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	repos := []*internal.Repository{
+		{ID: 1, Nwo: "fatih/vim-go", Owner: "fatih", Name: "vim-go"},
+		{ID: 2, Nwo: "fatih/color", Owner: "fatih", Name: "color", SyncError: "update: pull failed"},
+	}
+
+	var (
+		txs      int
+		syncErrs = make(map[int64]string)
+		synced   = make(map[int64]bool)
+	)
+
+	store := &mock.MetadataStore{
+		FindReposByIDsFn: func(ctx context.Context, ids []int64) ([]*internal.Repository, error) {
+			var found []*internal.Repository
+			for _, id := range ids {
+				rp := *repos[id-1]
+				found = append(found, &rp)
+			}
+			return found, nil
+		},
+		UpsertReposFn: func(ctx context.Context, upsert []*internal.Repository) error {
+			for _, repo := range upsert {
+				syncErrs[repo.ID] = repo.SyncError
+				synced[repo.ID] = !repo.SyncedAt.IsZero()
+			}
+			return nil
+		},
+	}
+	store.TxFn = func(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
+		txs++
+		return fn(store)
+	}
+
+	fsstore := &mock.RepositoryStore{
+		UpdateRepoFn: func(ctx context.Context, opt internal.UpdateOptions, repo *internal.Repository) error {
+			if repo.ID == 1 {
+				return errors.New("pull failed")
+			}
+			return nil
+		},
+	}
+
+	svc := NewService(nil, store, fsstore)
+
+	results := svc.UpdateRepos(ctx, repos)
+	c.Assert(results, qt.HasLen, 2)
+	c.Assert(results[0].Status, qt.Equals, StatusFailed)
+	c.Assert(results[0].Message(), qt.Equals, "pull failed")
+	c.Assert(results[1].Status, qt.Equals, StatusSuccess)
+
+	c.Assert(syncErrs, qt.DeepEquals, map[int64]string{
+		1: "update: pull failed",
+		2: "", // previous error is cleared
+	})
+	c.Assert(synced, qt.DeepEquals, map[int64]bool{1: false, 2: true})
+	c.Assert(txs, qt.Equals, 1, qt.Commentf("results should be stored at once"))
+	c.Assert(store.UpdateRepoInvoked, qt.IsFalse)
+	c.Assert(store.FindReposInvoked, qt.IsFalse, qt.Commentf("only the changed repositories should be read"))
+}
+
+func TestService_RenameRepos_batched(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	repos := []*internal.Repository{
+		{ID: 1, Nwo: "acme/utils", Owner: "acme", Name: "utils", Branch: "master"},
+		{ID: 2, Nwo: "acme/api", Owner: "acme", Name: "api", Branch: "master"},
+	}
+
+	var (
+		txs    int
+		stored = make(map[int64]internal.Repository)
+	)
+
+	store := &mock.MetadataStore{
+		FindReposByIDsFn: func(ctx context.Context, ids []int64) ([]*internal.Repository, error) {
+			var found []*internal.Repository
+			for _, id := range ids {
+				rp := *repos[id-1]
+				found = append(found, &rp)
+			}
+			return found, nil
+		},
+		UpsertReposFn: func(ctx context.Context, upsert []*internal.Repository) error {
+			for _, repo := range upsert {
+				stored[repo.ID] = *repo
+			}
+			return nil
+		},
+	}
+	store.TxFn = func(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
+		txs++
+		return fn(store)
+	}
+
+	fsstore := &mock.RepositoryStore{
+		MoveRepoFn: func(ctx context.Context, from, to *internal.Repository) error {
+			return nil
+		},
+		SwitchBranchFn: func(ctx context.Context, repo *internal.Repository, from string) error {
+			return nil
+		},
+	}
+
+	svc := NewService(nil, store, fsstore)
+
+	results := svc.RenameRepos(ctx, []*RenamedRepo{
+		{From: repos[0], To: &internal.Repository{Nwo: "contoso/utils", Owner: "contoso", Name: "utils"}},
+		{From: repos[1], To: &internal.Repository{Nwo: "contoso/api", Owner: "contoso", Name: "api"}},
+	})
+	c.Assert(results.Count(StatusSuccess), qt.Equals, 2)
+	c.Assert(stored[1].Nwo, qt.Equals, "contoso/utils")
+	c.Assert(stored[2].Nwo, qt.Equals, "contoso/api")
+
+	results = svc.SwitchBranches(ctx, []*BranchChange{
+		{Repo: repos[0], From: "master", To: "main"},
+		{Repo: repos[1], From: "master", To: "main"},
+	})
+	c.Assert(results.Count(StatusSuccess), qt.Equals, 2)
+	c.Assert(stored[1].Branch, qt.Equals, "main")
+	c.Assert(stored[2].Branch, qt.Equals, "main")
+
+	c.Assert(txs, qt.Equals, 2, qt.Commentf("each phase should be stored at once"))
+	c.Assert(store.UpdateRepoInvoked, qt.IsFalse)
+}
+
+func TestService_UpdateRepos_sharedWorkers(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var (
+		mu       sync.Mutex
+		inflight int
+		max      int
+	)
+
+	store := &mock.MetadataStore{
+		FindReposByIDsFn: func(ctx context.Context, ids []int64) ([]*internal.Repository, error) {
+			found := make([]*internal.Repository, 0, len(ids))
+			for _, id := range ids {
+				found = append(found, &internal.Repository{ID: id})
+			}
+			return found, nil
+		},
+		UpsertReposFn: func(ctx context.Context, repos []*internal.Repository) error {
+			return nil
+		},
+	}
+	store.TxFn = func(ctx context.Context, fn func(tx internal.MetadataStore) error) error {
+		return fn(store)
+	}
+
+	fsstore := &mock.RepositoryStore{
+		UpdateRepoFn: func(ctx context.Context, opt internal.UpdateOptions, repo *internal.Repository) error {
+			mu.Lock()
+			inflight++
+			if inflight > max {
+				max = inflight
+			}
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			inflight--
+			mu.Unlock()
+			return nil
+		},
+	}
+
+	workers := NewWorkers(2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		svc := NewService(nil, store, fsstore)
+		svc.SetWorkers(workers)
+
+		var repos []*internal.Repository
+		for id := 1; id <= 5; id++ {
+			repos = append(repos, &internal.Repository{ID: int64(id)})
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results := svc.UpdateRepos(ctx, repos)
+			c.Check(results.Count(StatusSuccess), qt.Equals, 5)
+		}()
+	}
+	wg.Wait()
+
+	c.Assert(max <= 2, qt.IsTrue, qt.Commentf("services should share the workers, max: %d", max))
+}
+
+// fakeRepositoriesService fakes GetBranch, other methods are not used and
+// panic.
+type fakeRepositoriesService struct {
+	*github.RepositoriesService
+}
+
+func (f *fakeRepositoriesService) GetBranch(ctx context.Context, owner, repo, branch string, followRedirects bool) (*github.Branch, *github.Response, error) {
+	sha := "123"
+	date := time.Now()
+	return &github.Branch{
+		Commit: &github.RepositoryCommit{
+			SHA: &sha,
+			Commit: &github.Commit{
+				Committer: &github.CommitAuthor{Date: &date},
+			},
+		},
+	}, &github.Response{}, nil
+}
+
+// closingStore is a metadata store that records whether it's closed.
+type closingStore struct {
+	*mock.MetadataStore
+	closed bool
+}
+
+func (s *closingStore) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestService_Close(t *testing.T) {
+	c := qt.New(t)
+
+	store := &closingStore{MetadataStore: &mock.MetadataStore{}}
+	c.Assert(NewService(nil, store, nil).Close(), qt.IsNil)
+	c.Assert(store.closed, qt.IsTrue)
+
+	// stores without Close are ignored
+	c.Assert(NewService(nil, &mock.MetadataStore{}, nil).Close(), qt.IsNil)
+}